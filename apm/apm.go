@@ -3,19 +3,28 @@ package apm
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 	"google.golang.org/grpc/encoding/gzip"
 
 	"github.com/hedon954/goapm/internal"
 )
 
+const defaultShutdownTimeout = 10 * time.Second
+
 type apmBuilder struct {
 	// res is the resource for the apm, if not set, a default resource will be created.
 	res *resource.Resource
@@ -28,6 +37,33 @@ type apmBuilder struct {
 
 	// headers for the grpc client to otel exporter, it is optional.
 	headers map[string]string
+
+	// baggageKeys are the baggage members copied onto the spans, it is optional.
+	baggageKeys []string
+
+	// exporter replaces the otlp grpc exporter if set, it is optional.
+	exporter sdktrace.SpanExporter
+
+	// failOpen makes the otlp grpc exporter connect lazily, so that the apm never fails because of the collector.
+	failOpen bool
+
+	// shutdownTimeout is the timeout of flushing the spans and shutting down the apm, default is 10s.
+	shutdownTimeout time.Duration
+
+	// spanProcessors are the additional span processors, it is optional.
+	spanProcessors []sdktrace.SpanProcessor
+
+	// resourceAttrs are merged onto the resource, it is optional.
+	resourceAttrs []attribute.KeyValue
+
+	// additionalExporters are the otel collectors the spans are also sent to, it is optional.
+	additionalExporters []otlpEndpoint
+}
+
+// otlpEndpoint is an otel collector with its own grpc headers.
+type otlpEndpoint struct {
+	endpoint string
+	headers  map[string]string
 }
 
 // ApmOption is the option for the apm.
@@ -40,6 +76,14 @@ func WithResource(res *resource.Resource) ApmOption {
 	}
 }
 
+// WithResourceAttributes adds the attributes to the resource,
+// unlike WithResource, the default attributes like host, process and service.name are kept.
+func WithResourceAttributes(attrs ...attribute.KeyValue) ApmOption {
+	return func(b *apmBuilder) {
+		b.resourceAttrs = append(b.resourceAttrs, attrs...)
+	}
+}
+
 // WithGRPCAuthToken sets the grpc auth token for the apm, it is optional.
 func WithGRPCAuthToken(token string) ApmOption {
 	return func(b *apmBuilder) {
@@ -63,12 +107,88 @@ func WithGrpcHeader(headers map[string]string) ApmOption {
 	}
 }
 
+// WithFailOpen makes NewAPM not fail or block when the otel collector is unreachable,
+// the exporter would connect to the collector on the first export, and the spans are dropped until it succeeds.
+func WithFailOpen(failOpen bool) ApmOption {
+	return func(b *apmBuilder) {
+		b.failOpen = failOpen
+	}
+}
+
+// WithSpanProcessor appends an additional span processor to the tracer provider,
+// the default batch span processor exporting the spans is kept.
+func WithSpanProcessor(sp sdktrace.SpanProcessor) ApmOption {
+	return func(b *apmBuilder) {
+		b.spanProcessors = append(b.spanProcessors, sp)
+	}
+}
+
+// WithShutdownTimeout sets the timeout of flushing the remaining spans and shutting down the apm, default is 10s.
+func WithShutdownTimeout(d time.Duration) ApmOption {
+	return func(b *apmBuilder) {
+		b.shutdownTimeout = d
+	}
+}
+
+// WithStdoutExporter exports the spans to stdout instead of the otel collector,
+// which is useful for local debugging without any infrastructure.
+func WithStdoutExporter() ApmOption {
+	return func(b *apmBuilder) {
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			// it never fails without the options returning errors
+			panic(fmt.Errorf("failed to create goapm stdout exporter: %w", err))
+		}
+		b.exporter = exporter
+	}
+}
+
+// WithInMemoryExporter keeps the spans in memory instead of exporting them to the otel collector,
+// the returned exporter could be used to inspect the spans, e.g. in tests.
+func WithInMemoryExporter() (ApmOption, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	return func(b *apmBuilder) {
+		b.exporter = exporter
+	}, exporter
+}
+
+// WithAdditionalExporter also sends the spans to the otel collector at endpoint by another batch span processor,
+// e.g. to dual-write to the old and new backends during a migration. headers are only sent to this collector,
+// e.g. {"Authorization": token}. WithFailOpen applies to it too.
+func WithAdditionalExporter(endpoint string, headers map[string]string) ApmOption {
+	return func(b *apmBuilder) {
+		b.additionalExporters = append(b.additionalExporters, otlpEndpoint{endpoint: endpoint, headers: headers})
+	}
+}
+
 // NewAPM creates a new APM component, which is a wrapper of opentelemetry.
+// The tracer provider created by NewTracerProvider is set as the global one.
 func NewAPM(otelEndpoint string, opts ...ApmOption) (closeFunc func(), err error) {
+	if Disabled() {
+		return func() {}, nil
+	}
+	tp, closeFunc, err := NewTracerProvider(otelEndpoint, opts...)
+	if err != nil {
+		return nil, err
+	}
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return closeFunc, nil
+}
+
+// NewTracerProvider creates the tracer provider exporting the spans to otelEndpoint like NewAPM,
+// but it is not set as the global one, so that several tracer providers could be used in the same process,
+// e.g. by the infras created with goapm.WithIsolation. A no-op tracer provider is returned if goapm is disabled.
+// closeFunc flushes the spans and shuts the tracer provider down.
+func NewTracerProvider(otelEndpoint string, opts ...ApmOption) (tp trace.TracerProvider, closeFunc func(), err error) {
+	if Disabled() {
+		return noop.NewTracerProvider(), func() {}, nil
+	}
 	ctx := context.Background()
 
 	b := &apmBuilder{
-		headers: make(map[string]string),
+		headers:         make(map[string]string),
+		shutdownTimeout: defaultShutdownTimeout,
 	}
 	for _, opt := range opts {
 		opt(b)
@@ -78,8 +198,131 @@ func NewAPM(otelEndpoint string, opts ...ApmOption) (closeFunc func(), err error
 		b.sampler = sdktrace.AlwaysSample()
 	}
 
+	// setup a resource
+	if err := b.setupResource(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	// setup a span processor
+	sp, err := b.newSpanProcessor(ctx, otelEndpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	providerOpts := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(b.sampler),
+		sdktrace.WithResource(b.res),
+	}
+	if maxSpanEvents > 0 {
+		limits := sdktrace.NewSpanLimits()
+		limits.EventCountLimit = maxSpanEvents
+		providerOpts = append(providerOpts, sdktrace.WithRawSpanLimits(limits))
+	}
+	if len(b.baggageKeys) > 0 {
+		providerOpts = append(providerOpts, sdktrace.WithSpanProcessor(newBaggageSpanProcessor(b.baggageKeys)))
+	}
+	for _, p := range b.spanProcessors {
+		providerOpts = append(providerOpts, sdktrace.WithSpanProcessor(p))
+	}
+	providerOpts = append(providerOpts, sdktrace.WithSpanProcessor(sp))
+	created := []sdktrace.SpanProcessor{sp}
+	for _, e := range b.additionalExporters {
+		sp, err := b.newOTLPSpanProcessor(ctx, e.endpoint, e.headers)
+		if err != nil {
+			for _, p := range created {
+				_ = p.Shutdown(ctx)
+			}
+			return nil, nil, err
+		}
+		created = append(created, sp)
+		providerOpts = append(providerOpts, sdktrace.WithSpanProcessor(sp))
+	}
+	traceProvider := sdktrace.NewTracerProvider(providerOpts...)
+
+	return traceProvider, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), b.shutdownTimeout)
+		defer cancel()
+		// flush the spans in the batch explicitly, so that they would not be lost in a fast shutdown
+		if err := traceProvider.ForceFlush(ctx); err != nil {
+			otel.Handle(err)
+		}
+		if err := traceProvider.Shutdown(ctx); err != nil {
+			otel.Handle(err)
+		}
+	}, nil
+}
+
+// newSpanProcessor creates a batch span processor with the otlp grpc exporter,
+// or a simple span processor with the custom exporter so that the spans could be seen immediately.
+func (b *apmBuilder) newSpanProcessor(ctx context.Context, otelEndpoint string) (sdktrace.SpanProcessor, error) {
+	if b.exporter != nil {
+		return sdktrace.NewSimpleSpanProcessor(b.exporter), nil
+	}
+
+	// setup auth header
+	if b.grpcToken != "" {
+		b.headers["Authorization"] = b.grpcToken
+	}
+	return b.newOTLPSpanProcessor(ctx, otelEndpoint, b.headers)
+}
+
+// newOTLPSpanProcessor creates a batch span processor with the otlp grpc exporter to the otel collector at endpoint.
+func (b *apmBuilder) newOTLPSpanProcessor(ctx context.Context, endpoint string, headers map[string]string) (sdktrace.SpanProcessor, error) {
+	newClient := func() otlptrace.Client {
+		return otlptracegrpc.NewClient(
+			otlptracegrpc.WithInsecure(),
+			otlptracegrpc.WithEndpoint(endpoint),
+			otlptracegrpc.WithHeaders(headers),
+			otlptracegrpc.WithCompressor(gzip.Name),
+		)
+	}
+	if b.failOpen {
+		return sdktrace.NewBatchSpanProcessor(&lazyExporter{newClient: newClient}), nil
+	}
+
+	// setup a trace exporter
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	traceExporter, err := otlptrace.New(ctx, newClient())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create otel trace exporter to %s: %w", endpoint, err)
+	}
+	return sdktrace.NewBatchSpanProcessor(traceExporter), nil
+}
+
+// lazyExporter starts the otlp exporter on the first export, and retries on the next export if it fails.
+type lazyExporter struct {
+	mu        sync.Mutex
+	newClient func() otlptrace.Client
+	exporter  *otlptrace.Exporter
+}
+
+func (e *lazyExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	e.mu.Lock()
+	if e.exporter == nil {
+		exporter := otlptrace.NewUnstarted(e.newClient())
+		if err := exporter.Start(ctx); err != nil {
+			e.mu.Unlock()
+			return fmt.Errorf("failed to start otel trace exporter, spans are dropped: %w", err)
+		}
+		e.exporter = exporter
+	}
+	exporter := e.exporter
+	e.mu.Unlock()
+	return exporter.ExportSpans(ctx, spans)
+}
+
+func (e *lazyExporter) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.exporter == nil {
+		return nil
+	}
+	return e.exporter.Shutdown(ctx)
+}
+
+// setupResource creates the default resource if it is not set, and merges the resource attributes onto it.
+func (b *apmBuilder) setupResource(ctx context.Context) error {
 	if b.res == nil {
-		// setup a resource
 		res, err := resource.New(ctx,
 			resource.WithHost(),
 			resource.WithProcess(),
@@ -87,44 +330,34 @@ func NewAPM(otelEndpoint string, opts ...ApmOption) (closeFunc func(), err error
 			resource.WithAttributes(semconv.ServiceName(
 				internal.BuildInfo.AppName(),
 			)),
+			resource.WithAttributes(buildInfoAttributes()...),
+			resource.WithAttributes(b.resourceAttrs...),
 		)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create otel resource: %w", err)
+			return fmt.Errorf("failed to create otel resource: %w", err)
 		}
 		b.res = res
+		return nil
 	}
 
-	// setup auth header
-	if b.grpcToken != "" {
-		b.headers["Authorization"] = b.grpcToken
+	if len(b.resourceAttrs) > 0 {
+		res, err := resource.Merge(b.res, resource.NewSchemaless(b.resourceAttrs...))
+		if err != nil {
+			return fmt.Errorf("failed to merge otel resource attributes: %w", err)
+		}
+		b.res = res
 	}
+	return nil
+}
 
-	// setup a trace exporter
-	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
-	defer cancel()
-	traceExporter, err := otlptracegrpc.New(ctx,
-		otlptracegrpc.WithInsecure(),
-		otlptracegrpc.WithEndpoint(otelEndpoint),
-		otlptracegrpc.WithHeaders(b.headers),
-		otlptracegrpc.WithCompressor(gzip.Name),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create otel trace exporter: %w", err)
+// buildInfoAttributes returns the version and commit of the application as resource attributes.
+func buildInfoAttributes() []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	if v := internal.BuildInfo.Version(); v != "" {
+		attrs = append(attrs, semconv.ServiceVersion(v))
 	}
-	bsp := sdktrace.NewBatchSpanProcessor(traceExporter)
-	traceProvider := sdktrace.NewTracerProvider(
-		sdktrace.WithSampler(b.sampler),
-		sdktrace.WithResource(b.res),
-		sdktrace.WithSpanProcessor(bsp),
-	)
-	otel.SetTracerProvider(traceProvider)
-	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
-
-	return func() {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-		defer cancel()
-		if err := traceProvider.Shutdown(ctx); err != nil {
-			otel.Handle(err)
-		}
-	}, nil
+	if c := internal.BuildInfo.Commit(); c != "" {
+		attrs = append(attrs, attribute.String("git.commit.sha", c))
+	}
+	return attrs
 }
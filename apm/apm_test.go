@@ -0,0 +1,209 @@
+package apm
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	collectortracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+)
+
+// testExporter holds all the spans ended in the tests.
+var testExporter = tracetest.NewInMemoryExporter()
+
+func init() {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())),
+		sdktrace.WithSyncer(testExporter),
+	))
+}
+
+// setupTracingTest resets the spans recorded by the test exporter and returns it.
+func setupTracingTest() *tracetest.InMemoryExporter {
+	testExporter.Reset()
+	return testExporter
+}
+
+func TestNewAPM_WithInMemoryExporter(t *testing.T) {
+	tp := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(tp)
+
+	opt, exporter := WithInMemoryExporter()
+	closeFunc, err := NewAPM("unreachable:4317", opt)
+	assert.Nil(t, err)
+
+	_, span := otel.Tracer("test").Start(context.Background(), "test")
+	span.End()
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "test", spans[0].Name)
+	closeFunc()
+}
+
+func TestNewTracerProvider(t *testing.T) {
+	global := setupTracingTest()
+
+	opt, exporter := WithInMemoryExporter()
+	tp, closeFunc, err := NewTracerProvider("unreachable:4317", opt)
+	assert.Nil(t, err)
+
+	_, span := tp.Tracer("test").Start(context.Background(), "isolated")
+	span.End()
+	_, span = otel.Tracer("test").Start(context.Background(), "global")
+	span.End()
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "isolated", spans[0].Name)
+	spans = global.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "global", spans[0].Name)
+	closeFunc()
+}
+
+func TestNewAPM_WithStdoutExporter(t *testing.T) {
+	tp := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(tp)
+
+	closeFunc, err := NewAPM("unreachable:4317", WithStdoutExporter())
+	assert.Nil(t, err)
+	closeFunc()
+}
+
+type fakeOtlpClient struct {
+	startErr error
+	uploaded int
+}
+
+func (c *fakeOtlpClient) Start(context.Context) error { return c.startErr }
+func (c *fakeOtlpClient) Stop(context.Context) error  { return nil }
+func (c *fakeOtlpClient) UploadTraces(_ context.Context, spans []*tracepb.ResourceSpans) error {
+	c.uploaded += len(spans)
+	return nil
+}
+
+func TestLazyExporter(t *testing.T) {
+	tp := sdktrace.NewTracerProvider()
+	_, span := tp.Tracer("test").Start(context.Background(), "test")
+	span.End()
+	spans := []sdktrace.ReadOnlySpan{span.(sdktrace.ReadOnlySpan)}
+
+	client := &fakeOtlpClient{startErr: errors.New("collector unreachable")}
+	exporter := &lazyExporter{newClient: func() otlptrace.Client { return client }}
+
+	// spans are dropped until the exporter is started
+	assert.NotNil(t, exporter.ExportSpans(context.Background(), spans))
+	assert.Equal(t, 0, client.uploaded)
+
+	client.startErr = nil
+	assert.Nil(t, exporter.ExportSpans(context.Background(), spans))
+	assert.Equal(t, 1, client.uploaded)
+	assert.Nil(t, exporter.Shutdown(context.Background()))
+}
+
+func TestNewAPM_WithFailOpen(t *testing.T) {
+	tp := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(tp)
+
+	closeFunc, err := NewAPM("127.0.0.1:1", WithFailOpen(true))
+	assert.Nil(t, err)
+	closeFunc()
+}
+
+func TestNewAPM_WithSpanProcessor(t *testing.T) {
+	tp := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(tp)
+
+	opt, exporter := WithInMemoryExporter()
+	closeFunc, err := NewAPM("unreachable:4317", opt, WithSpanProcessor(
+		NewAttributesSpanProcessor(attribute.String("deployment.environment", "test")),
+	))
+	assert.Nil(t, err)
+	defer closeFunc()
+
+	_, span := otel.Tracer("test").Start(context.Background(), "test")
+	span.End()
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Contains(t, spans[0].Attributes, attribute.String("deployment.environment", "test"))
+}
+
+type fakeTraceCollector struct {
+	collectortracepb.UnimplementedTraceServiceServer
+	exported atomic.Int32
+}
+
+func (c *fakeTraceCollector) Export(_ context.Context, req *collectortracepb.ExportTraceServiceRequest) (
+	*collectortracepb.ExportTraceServiceResponse, error) {
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			c.exported.Add(int32(len(ss.Spans)))
+		}
+	}
+	return &collectortracepb.ExportTraceServiceResponse{}, nil
+}
+
+func TestNewAPM_WithAdditionalExporter(t *testing.T) {
+	tp := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(tp)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	collector := &fakeTraceCollector{}
+	server := grpc.NewServer()
+	collectortracepb.RegisterTraceServiceServer(server, collector)
+	go func() { _ = server.Serve(lis) }()
+	defer server.Stop()
+
+	opt, exporter := WithInMemoryExporter()
+	closeFunc, err := NewAPM("unreachable:4317", opt, WithAdditionalExporter(lis.Addr().String(), nil))
+	assert.Nil(t, err)
+
+	_, span := otel.Tracer("test").Start(context.Background(), "test")
+	span.End()
+	assert.Len(t, exporter.GetSpans(), 1)
+	// the spans are flushed to the additional collector on close
+	closeFunc()
+	assert.Equal(t, int32(1), collector.exported.Load())
+}
+
+func TestApmBuilder_SetupResource(t *testing.T) {
+	t.Run("attributes should be merged onto the default resource", func(t *testing.T) {
+		b := &apmBuilder{}
+		WithResourceAttributes(attribute.String("deployment.environment", "test"))(b)
+		assert.Nil(t, b.setupResource(context.Background()))
+
+		attrs := b.res.Set()
+		v, ok := attrs.Value("deployment.environment")
+		assert.True(t, ok)
+		assert.Equal(t, "test", v.AsString())
+		_, ok = attrs.Value(semconv.ServiceNameKey)
+		assert.True(t, ok)
+		_, ok = attrs.Value(semconv.HostNameKey)
+		assert.True(t, ok)
+	})
+
+	t.Run("attributes should be merged onto the custom resource", func(t *testing.T) {
+		b := &apmBuilder{}
+		WithResource(resource.NewSchemaless(attribute.String("custom", "v")))(b)
+		WithResourceAttributes(attribute.String("deployment.environment", "test"))(b)
+		assert.Nil(t, b.setupResource(context.Background()))
+
+		_, ok := b.res.Set().Value("custom")
+		assert.True(t, ok)
+		_, ok = b.res.Set().Value("deployment.environment")
+		assert.True(t, ok)
+	})
+}
@@ -0,0 +1,67 @@
+package apm
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// SetBaggage sets the baggage member into the ctx, the baggage is propagated across http and grpc,
+// so that the business context like tenant id could be set once at the edge and read by the downstream services.
+// The ctx is returned as it is if the key is invalid.
+func SetBaggage(ctx context.Context, key, value string) context.Context {
+	member, err := baggage.NewMemberRaw(key, value)
+	if err != nil {
+		otel.Handle(err)
+		return ctx
+	}
+	bag, err := baggage.FromContext(ctx).SetMember(member)
+	if err != nil {
+		otel.Handle(err)
+		return ctx
+	}
+	return baggage.ContextWithBaggage(ctx, bag)
+}
+
+// GetBaggage returns the value of the baggage member in the ctx, or an empty string if it does not exist.
+func GetBaggage(ctx context.Context, key string) string {
+	return baggage.FromContext(ctx).Member(key).Value()
+}
+
+// WithBaggageSpanAttributes copies the given baggage members onto every span started in their ctx as attributes,
+// e.g. tag all the spans in a request with "tenant_id".
+// Only the given keys are copied, since each of them adds a dimension to the spans,
+// so do not pass the keys with unbounded values unless you know the cost.
+func WithBaggageSpanAttributes(keys ...string) ApmOption {
+	return func(b *apmBuilder) {
+		b.baggageKeys = append(b.baggageKeys, keys...)
+	}
+}
+
+// baggageSpanProcessor is a span processor which copies the baggage members onto the spans when they start.
+type baggageSpanProcessor struct {
+	keys []string
+}
+
+func newBaggageSpanProcessor(keys []string) sdktrace.SpanProcessor {
+	return &baggageSpanProcessor{keys: keys}
+}
+
+// OnStart copies the baggage members in the parent ctx onto the span.
+func (p *baggageSpanProcessor) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {
+	bag := baggage.FromContext(parent)
+	for _, key := range p.keys {
+		if m := bag.Member(key); m.Key() != "" {
+			s.SetAttributes(attribute.String(key, m.Value()))
+		}
+	}
+}
+
+func (p *baggageSpanProcessor) OnEnd(sdktrace.ReadOnlySpan) {}
+
+func (p *baggageSpanProcessor) Shutdown(context.Context) error { return nil }
+
+func (p *baggageSpanProcessor) ForceFlush(context.Context) error { return nil }
@@ -0,0 +1,40 @@
+package apm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestBaggage(t *testing.T) {
+	ctx := SetBaggage(context.Background(), "tenant_id", "t-1")
+	ctx = SetBaggage(ctx, "user_id", "u 1")
+	assert.Equal(t, "t-1", GetBaggage(ctx, "tenant_id"))
+	assert.Equal(t, "u 1", GetBaggage(ctx, "user_id"))
+	assert.Equal(t, "", GetBaggage(ctx, "not_exist"))
+
+	t.Run("invalid key should be ignored", func(t *testing.T) {
+		assert.Equal(t, ctx, SetBaggage(ctx, "invalid key", "v"))
+	})
+
+	t.Run("selected members should be copied onto spans", func(t *testing.T) {
+		exporter := tracetest.NewInMemoryExporter()
+		tp := sdktrace.NewTracerProvider(
+			sdktrace.WithSpanProcessor(newBaggageSpanProcessor([]string{"tenant_id"})),
+			sdktrace.WithSyncer(exporter),
+		)
+		_, span := tp.Tracer("test").Start(ctx, "test")
+		span.End()
+
+		spans := exporter.GetSpans()
+		assert.Len(t, spans, 1)
+		assert.Contains(t, spans[0].Attributes, attribute.String("tenant_id", "t-1"))
+		for _, attr := range spans[0].Attributes {
+			assert.NotEqual(t, attribute.Key("user_id"), attr.Key)
+		}
+	})
+}
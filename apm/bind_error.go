@@ -0,0 +1,41 @@
+package apm
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// validationError is the field-level validation error recorded in "http.request.validation_errors".
+type validationError struct {
+	Field string `json:"field"`
+	Tag   string `json:"tag"`
+	Param string `json:"param,omitempty"`
+}
+
+// RecordBindError records the error returned by c.ShouldBind* on the span of GinOtel,
+// the validator.ValidationErrors are recorded as a json array of {field, tag, param} in "http.request.validation_errors",
+// and the other errors, e.g. the malformed json, are recorded in "http.request.bind_error".
+// The span is not marked as error since it is a client error.
+func RecordBindError(c *gin.Context, err error) {
+	if err == nil {
+		return
+	}
+	span := trace.SpanFromContext(c.Request.Context())
+
+	var ves validator.ValidationErrors
+	if !errors.As(err, &ves) {
+		span.SetAttributes(attribute.String("http.request.bind_error", err.Error()))
+		return
+	}
+	errs := make([]validationError, 0, len(ves))
+	for _, fe := range ves {
+		errs = append(errs, validationError{Field: fe.Namespace(), Tag: fe.Tag(), Param: fe.Param()})
+	}
+	b, _ := json.Marshal(errs)
+	span.SetAttributes(attribute.String("http.request.validation_errors", string(b)))
+}
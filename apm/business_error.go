@@ -0,0 +1,52 @@
+package apm
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BusinessError is the domain error reported to the traces and metrics.
+type BusinessError interface {
+	// Code returns the error code, it is used as a metric label so it should be of low cardinality.
+	Code() string
+	// Msg returns the error message.
+	Msg() string
+}
+
+// SetBusinessError writes the business error into the response headers in the canonical format,
+// which would be recorded by GinOtel on the span and counted in the business_error_total metric.
+func SetBusinessError(c *gin.Context, err BusinessError) {
+	SetBusinessErrorHeader(c.Writer.Header(), err)
+}
+
+// SetBusinessErrorHeader is like SetBusinessError but works with the net/http response headers,
+// it should be called before the response is written.
+func SetBusinessErrorHeader(h http.Header, err BusinessError) {
+	if err == nil {
+		return
+	}
+	h.Set(HeaderBusinessErrorCode, strings.TrimSpace(err.Code()))
+	// the message may be i18n, so it is escaped to be a valid header value
+	h.Set(HeaderBusinessErrorMsg, url.QueryEscape(err.Msg()))
+	h.Set(HeaderBusinessErrorMsgEncoding, businessErrorMsgURLEncoding)
+}
+
+// businessErrorMsgURLEncoding is the encoding of the messages escaped by SetBusinessErrorHeader.
+const businessErrorMsgURLEncoding = "url"
+
+// getBusinessError reads the business error from the response headers,
+// the message is unescaped only if it is marked as escaped by SetBusinessErrorHeader, the raw ones are kept as is.
+func getBusinessError(h http.Header) (code, msg string) {
+	code = h.Get(HeaderBusinessErrorCode)
+	msg = h.Get(HeaderBusinessErrorMsg)
+	if h.Get(HeaderBusinessErrorMsgEncoding) != businessErrorMsgURLEncoding {
+		return code, msg
+	}
+	if unescaped, err := url.QueryUnescape(msg); err == nil {
+		msg = unescaped
+	}
+	return code, msg
+}
@@ -0,0 +1,272 @@
+package apm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CircuitBreakerState is the state of the circuit breaker, it is also the value of the circuit_breaker_state gauge.
+type CircuitBreakerState int
+
+const (
+	// CircuitBreakerClosed lets all the requests through.
+	CircuitBreakerClosed CircuitBreakerState = iota
+	// CircuitBreakerHalfOpen lets a limited number of probe requests through.
+	CircuitBreakerHalfOpen
+	// CircuitBreakerOpen rejects all the requests.
+	CircuitBreakerOpen
+)
+
+func (s CircuitBreakerState) String() string {
+	switch s {
+	case CircuitBreakerClosed:
+		return "closed"
+	case CircuitBreakerHalfOpen:
+		return "half-open"
+	case CircuitBreakerOpen:
+		return "open"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// CircuitBreakerOpenError is returned when the request is rejected by the circuit breaker.
+type CircuitBreakerOpenError struct {
+	Name string
+}
+
+func (e *CircuitBreakerOpenError) Error() string {
+	return fmt.Sprintf("goapm circuit breaker[%s] is open", e.Name)
+}
+
+// CircuitBreakerOptions is the options of the circuit breaker, the zero values are replaced by the defaults.
+type CircuitBreakerOptions struct {
+	// FailureRatio is the ratio of the failures in the window to open the breaker, default is 0.5.
+	FailureRatio float64
+	// MinRequests is the minimum number of the requests in the window to open the breaker, default is 10.
+	MinRequests int
+	// Window is the period the requests are counted in while the breaker is closed, default is 10s.
+	Window time.Duration
+	// OpenDuration is how long the breaker keeps open before it turns half-open, default is 30s.
+	OpenDuration time.Duration
+	// HalfOpenProbes is the number of the probe requests in the half-open state,
+	// the breaker is closed if all of them succeed, and opened again on any failure. Default is 1.
+	HalfOpenProbes int
+}
+
+// CircuitBreaker stops calling the downstream for a while when it fails too much, to avoid the cascading failures.
+type CircuitBreaker struct {
+	name string
+	opts CircuitBreakerOptions
+	now  func() time.Time
+
+	mu          sync.Mutex
+	state       CircuitBreakerState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probes      int // the probes allowed in the half-open state
+	successes   int // the probes succeeded in the half-open state
+}
+
+// NewCircuitBreaker creates a circuit breaker, the name is used in the errors, span events and metrics.
+func NewCircuitBreaker(name string, opts CircuitBreakerOptions) *CircuitBreaker {
+	if opts.FailureRatio <= 0 {
+		opts.FailureRatio = 0.5 //nolint:mnd
+	}
+	if opts.MinRequests <= 0 {
+		opts.MinRequests = 10 //nolint:mnd
+	}
+	if opts.Window <= 0 {
+		opts.Window = 10 * time.Second //nolint:mnd
+	}
+	if opts.OpenDuration <= 0 {
+		opts.OpenDuration = 30 * time.Second //nolint:mnd
+	}
+	if opts.HalfOpenProbes <= 0 {
+		opts.HalfOpenProbes = 1
+	}
+	cb := &CircuitBreaker{name: name, opts: opts, now: time.Now}
+	cb.windowStart = cb.now()
+	goapmVecs().circuitBreakerStateGauge.WithLabelValues(name).Set(float64(CircuitBreakerClosed))
+	return cb
+}
+
+// State returns the current state of the circuit breaker.
+func (cb *CircuitBreaker) State() CircuitBreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.refresh(context.Background())
+	return cb.state
+}
+
+// Allow reports whether the request could be sent, a CircuitBreakerOpenError is returned if not.
+// Otherwise done must be called with the result of the request.
+func (cb *CircuitBreaker) Allow(ctx context.Context) (done func(success bool), err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.refresh(ctx)
+	switch cb.state {
+	case CircuitBreakerOpen:
+		return nil, &CircuitBreakerOpenError{Name: cb.name}
+	case CircuitBreakerHalfOpen:
+		if cb.probes >= cb.opts.HalfOpenProbes {
+			return nil, &CircuitBreakerOpenError{Name: cb.name}
+		}
+		cb.probes++
+	default:
+	}
+
+	state := cb.state
+	return func(success bool) { cb.done(ctx, state, success) }, nil
+}
+
+// Execute calls fn if the circuit breaker allows, and records its result.
+func (cb *CircuitBreaker) Execute(ctx context.Context, fn func() error) error {
+	done, err := cb.Allow(ctx)
+	if err != nil {
+		return err
+	}
+	err = fn()
+	done(err == nil)
+	return err
+}
+
+// refresh turns the open breaker half-open after OpenDuration, and resets the counts of the expired window.
+func (cb *CircuitBreaker) refresh(ctx context.Context) {
+	now := cb.now()
+	switch cb.state {
+	case CircuitBreakerOpen:
+		if now.Sub(cb.openedAt) >= cb.opts.OpenDuration {
+			cb.setState(ctx, CircuitBreakerHalfOpen)
+		}
+	case CircuitBreakerClosed:
+		if now.Sub(cb.windowStart) >= cb.opts.Window {
+			cb.windowStart, cb.requests, cb.failures = now, 0, 0
+		}
+	default:
+	}
+}
+
+func (cb *CircuitBreaker) done(ctx context.Context, state CircuitBreakerState, success bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	// ignore the results of the requests allowed in a previous state
+	if state != cb.state {
+		return
+	}
+	switch cb.state {
+	case CircuitBreakerHalfOpen:
+		if !success {
+			cb.setState(ctx, CircuitBreakerOpen)
+			return
+		}
+		cb.successes++
+		if cb.successes >= cb.opts.HalfOpenProbes {
+			cb.setState(ctx, CircuitBreakerClosed)
+		}
+	case CircuitBreakerClosed:
+		cb.requests++
+		if !success {
+			cb.failures++
+		}
+		if cb.requests >= cb.opts.MinRequests && float64(cb.failures) >= cb.opts.FailureRatio*float64(cb.requests) {
+			cb.setState(ctx, CircuitBreakerOpen)
+		}
+	default:
+	}
+}
+
+// setState changes the state and records the transition as a span event and the gauge.
+func (cb *CircuitBreaker) setState(ctx context.Context, state CircuitBreakerState) {
+	from := cb.state
+	cb.state = state
+	now := cb.now()
+	switch state {
+	case CircuitBreakerOpen:
+		cb.openedAt = now
+	case CircuitBreakerHalfOpen:
+		cb.probes, cb.successes = 0, 0
+	case CircuitBreakerClosed:
+		cb.windowStart, cb.requests, cb.failures = now, 0, 0
+	}
+
+	goapmVecs().circuitBreakerStateGauge.WithLabelValues(cb.name).Set(float64(state))
+	trace.SpanFromContext(ctx).AddEvent("circuit_breaker.state_change", trace.WithAttributes(
+		attribute.String("circuit_breaker.name", cb.name),
+		attribute.String("circuit_breaker.from", from.String()),
+		attribute.String("circuit_breaker.to", state.String()),
+	))
+	Logger.Warn(ctx, "goapm circuit breaker state changed", map[string]any{
+		"name": cb.name,
+		"from": from.String(),
+		"to":   state.String(),
+	})
+}
+
+// WithCircuitBreaker adds the circuit breaker to the grpc client, it is chained after the goapm interceptor,
+// so that the rejections and the state transitions are recorded on the client spans.
+func WithCircuitBreaker(cb *CircuitBreaker) grpc.DialOption {
+	return grpc.WithChainUnaryInterceptor(cb.UnaryClientInterceptor())
+}
+
+// UnaryClientInterceptor returns a grpc interceptor which short-circuits the calls when the breaker is open,
+// only the errors indicating the downstream is unhealthy, e.g. Unavailable and DeadlineExceeded, are counted as failures.
+func (cb *CircuitBreaker) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any,
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		done, err := cb.Allow(ctx)
+		if err != nil {
+			return err
+		}
+		err = invoker(ctx, method, req, reply, cc, opts...)
+		done(!isGrpcFailure(err))
+		return err
+	}
+}
+
+func isGrpcFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown,
+		codes.ResourceExhausted, codes.Aborted, codes.DataLoss:
+		return true
+	default:
+		return false
+	}
+}
+
+// RoundTripper wraps the http.RoundTripper with the circuit breaker, next is http.DefaultTransport if nil.
+// The transport errors and the 5xx responses are counted as failures.
+func (cb *CircuitBreaker) RoundTripper(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		done, err := cb.Allow(r.Context())
+		if err != nil {
+			return nil, err
+		}
+		resp, err := next.RoundTrip(r)
+		done(err == nil && resp.StatusCode < http.StatusInternalServerError)
+		return resp, err
+	})
+}
+
+// roundTripperFunc is an adapter to use the function as http.RoundTripper.
+type roundTripperFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
@@ -0,0 +1,132 @@
+package apm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestCircuitBreaker(name string) (*CircuitBreaker, *time.Time) {
+	now := time.Unix(0, 0)
+	cb := NewCircuitBreaker(name, CircuitBreakerOptions{MinRequests: 4, OpenDuration: time.Second, HalfOpenProbes: 2})
+	cb.now = func() time.Time { return now }
+	cb.windowStart = now
+	return cb, &now
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	exporter := setupTracingTest()
+	cb, now := newTestCircuitBreaker("test")
+	errFailed := errors.New("failed")
+	call := func(err error) error {
+		return cb.Execute(context.Background(), func() error { return err })
+	}
+
+	// not opened until MinRequests
+	assert.ErrorIs(t, call(errFailed), errFailed)
+	assert.ErrorIs(t, call(errFailed), errFailed)
+	assert.Nil(t, call(nil))
+	assert.Equal(t, CircuitBreakerClosed, cb.State())
+
+	// the failure ratio is reached
+	ctx, span := otel.Tracer("test").Start(context.Background(), "test")
+	done, err := cb.Allow(ctx)
+	assert.Nil(t, err)
+	done(false)
+	span.End()
+	assert.Equal(t, CircuitBreakerOpen, cb.State())
+	assert.Equal(t, float64(CircuitBreakerOpen), testutil.ToFloat64(goapmVecs().circuitBreakerStateGauge.WithLabelValues("test")))
+	events := exporter.GetSpans()[0].Events
+	assert.Len(t, events, 1)
+	assert.Equal(t, "circuit_breaker.state_change", events[0].Name)
+
+	// the requests are rejected while open
+	var openErr *CircuitBreakerOpenError
+	assert.ErrorAs(t, call(nil), &openErr)
+	assert.Equal(t, "test", openErr.Name)
+
+	// half-open after OpenDuration, and the probes are limited
+	*now = now.Add(time.Second)
+	assert.Equal(t, CircuitBreakerHalfOpen, cb.State())
+	done1, err := cb.Allow(context.Background())
+	assert.Nil(t, err)
+	done2, err := cb.Allow(context.Background())
+	assert.Nil(t, err)
+	_, err = cb.Allow(context.Background())
+	assert.ErrorAs(t, err, &openErr)
+
+	// closed if all the probes succeed
+	done1(true)
+	assert.Equal(t, CircuitBreakerHalfOpen, cb.State())
+	done2(true)
+	assert.Equal(t, CircuitBreakerClosed, cb.State())
+
+	// the window is reset
+	for range 3 {
+		_ = call(errFailed)
+	}
+	*now = now.Add(10 * time.Second)
+	_ = call(errFailed)
+	assert.Equal(t, CircuitBreakerClosed, cb.State())
+}
+
+func TestCircuitBreaker_HalfOpenFailure(t *testing.T) {
+	cb, now := newTestCircuitBreaker("half-open-failure")
+	for range 4 {
+		_ = cb.Execute(context.Background(), func() error { return errors.New("failed") })
+	}
+	*now = now.Add(time.Second)
+	assert.NotNil(t, cb.Execute(context.Background(), func() error { return errors.New("failed") }))
+	assert.Equal(t, CircuitBreakerOpen, cb.State())
+}
+
+func TestCircuitBreaker_UnaryClientInterceptor(t *testing.T) {
+	cb, _ := newTestCircuitBreaker("grpc")
+	interceptor := cb.UnaryClientInterceptor()
+	invoke := func(code codes.Code) error {
+		return interceptor(context.Background(), "/HelloService/SayHello", nil, nil, nil,
+			func(context.Context, string, any, any, *grpc.ClientConn, ...grpc.CallOption) error {
+				return status.Error(code, code.String())
+			})
+	}
+
+	// the business errors are not failures
+	for range 4 {
+		_ = invoke(codes.InvalidArgument)
+	}
+	assert.Equal(t, CircuitBreakerClosed, cb.State())
+	for range 4 {
+		_ = invoke(codes.Unavailable)
+	}
+	assert.Equal(t, CircuitBreakerOpen, cb.State())
+	var openErr *CircuitBreakerOpenError
+	assert.ErrorAs(t, invoke(codes.OK), &openErr)
+}
+
+func TestCircuitBreaker_RoundTripper(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	cb, _ := newTestCircuitBreaker("http")
+	client := &http.Client{Transport: cb.RoundTripper(nil)}
+	for range 4 {
+		resp, err := client.Get(server.URL)
+		assert.Nil(t, err)
+		_ = resp.Body.Close()
+	}
+	_, err := client.Get(server.URL)
+	var openErr *CircuitBreakerOpenError
+	assert.ErrorAs(t, err, &openErr)
+}
@@ -0,0 +1,157 @@
+package apm
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultCORSMethods are the methods allowed by default.
+var defaultCORSMethods = []string{
+	http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodHead, http.MethodOptions,
+}
+
+// CORSOptions is the options of the CORS middleware.
+type CORSOptions struct {
+	// AllowOrigins are the origins allowed, "*" allows all the origins,
+	// and "https://*.example.com" allows all the subdomains of example.com.
+	AllowOrigins []string
+	// AllowMethods are the methods allowed, default is GET, POST, PUT, PATCH, DELETE, HEAD and OPTIONS.
+	AllowMethods []string
+	// AllowHeaders are the request headers allowed, default is the headers requested by the preflight.
+	AllowHeaders []string
+	// ExposeHeaders are the response headers could be read by the browser.
+	ExposeHeaders []string
+	// AllowCredentials allows the cookies and the authorization headers.
+	AllowCredentials bool
+	// MaxAge is how long the preflight result could be cached, zero means not set.
+	MaxAge time.Duration
+}
+
+// CORS creates a gin middleware handling the CORS, the preflight requests are responded with 204.
+// Use it before GinOtel, e.g. `r.Use(apm.CORS(opts), apm.GinOtel())`, so that the preflight requests are not traced.
+func CORS(opts CORSOptions) gin.HandlerFunc {
+	c := newCORS(opts)
+	return func(ctx *gin.Context) {
+		if c.handle(ctx.Writer, ctx.Request) {
+			ctx.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// CORSHandler is the net/http variant of CORS.
+// Use it outside TraceHTTP, e.g. `apm.CORSHandler(opts)(apm.TraceHTTP(mux))`, so that the preflight requests are not traced.
+func CORSHandler(opts CORSOptions) func(next http.Handler) http.Handler {
+	c := newCORS(opts)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if c.handle(w, r) {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type cors struct {
+	allowAllOrigins  bool
+	allowOrigins     map[string]bool
+	wildcardOrigins  [][2]string // the prefix and suffix around the "*"
+	allowMethods     string
+	allowHeaders     string
+	exposeHeaders    string
+	allowCredentials bool
+	maxAge           string
+}
+
+func newCORS(opts CORSOptions) *cors {
+	c := &cors{
+		allowOrigins:     make(map[string]bool),
+		allowMethods:     strings.Join(defaultCORSMethods, ", "),
+		allowHeaders:     strings.Join(opts.AllowHeaders, ", "),
+		exposeHeaders:    strings.Join(opts.ExposeHeaders, ", "),
+		allowCredentials: opts.AllowCredentials,
+	}
+	for _, origin := range opts.AllowOrigins {
+		if origin == "*" {
+			c.allowAllOrigins = true
+		} else if prefix, suffix, ok := strings.Cut(strings.ToLower(origin), "*"); ok {
+			c.wildcardOrigins = append(c.wildcardOrigins, [2]string{prefix, suffix})
+		} else {
+			c.allowOrigins[strings.ToLower(origin)] = true
+		}
+	}
+	if len(opts.AllowMethods) > 0 {
+		c.allowMethods = strings.ToUpper(strings.Join(opts.AllowMethods, ", "))
+	}
+	if opts.MaxAge > 0 {
+		c.maxAge = strconv.Itoa(int(opts.MaxAge.Seconds()))
+	}
+	return c
+}
+
+func (c *cors) isAllowedOrigin(origin string) bool {
+	if c.allowAllOrigins {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if c.allowOrigins[origin] {
+		return true
+	}
+	for _, w := range c.wildcardOrigins {
+		if len(origin) > len(w[0])+len(w[1]) && strings.HasPrefix(origin, w[0]) && strings.HasSuffix(origin, w[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// handle sets the CORS headers, and reports whether the request is a preflight request which should not be handled further.
+func (c *cors) handle(w http.ResponseWriter, r *http.Request) (preflight bool) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	preflight = r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	if !c.isAllowedOrigin(origin) {
+		return preflight
+	}
+
+	// "*" is not allowed with the credentials, so the origin is echoed
+	if c.allowAllOrigins && !c.allowCredentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.allowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if !preflight {
+		if c.exposeHeaders != "" {
+			h.Set("Access-Control-Expose-Headers", c.exposeHeaders)
+		}
+		return false
+	}
+
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	h.Set("Access-Control-Allow-Methods", c.allowMethods)
+	if c.allowHeaders != "" {
+		h.Set("Access-Control-Allow-Headers", c.allowHeaders)
+	} else if reqHeaders := r.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+		h.Set("Access-Control-Allow-Headers", reqHeaders)
+	}
+	if c.maxAge != "" {
+		h.Set("Access-Control-Max-Age", c.maxAge)
+	}
+	return true
+}
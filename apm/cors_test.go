@@ -0,0 +1,99 @@
+package apm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORS(t *testing.T) {
+	exporter := setupTracingTest()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(CORS(CORSOptions{
+		AllowOrigins:     []string{"https://a.com", "https://*.example.com"},
+		AllowMethods:     []string{"get", "post"},
+		ExposeHeaders:    []string{HeaderRequestID},
+		AllowCredentials: true,
+		MaxAge:           time.Hour,
+	}), GinOtel())
+	r.GET("/hello", func(c *gin.Context) { c.String(http.StatusOK, "hello") })
+
+	serve := func(method, origin string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/hello", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("preflight", func(t *testing.T) {
+		exporter.Reset()
+		w := serve(http.MethodOptions, "https://api.example.com", map[string]string{
+			"Access-Control-Request-Method":  "POST",
+			"Access-Control-Request-Headers": "X-Tenant-ID",
+		})
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "https://api.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "GET, POST", w.Header().Get("Access-Control-Allow-Methods"))
+		assert.Equal(t, "X-Tenant-ID", w.Header().Get("Access-Control-Allow-Headers"))
+		assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+		assert.Equal(t, "3600", w.Header().Get("Access-Control-Max-Age"))
+		// the preflight requests are not traced
+		assert.Empty(t, exporter.GetSpans())
+	})
+
+	t.Run("simple request", func(t *testing.T) {
+		exporter.Reset()
+		w := serve(http.MethodGet, "https://a.com", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "https://a.com", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, HeaderRequestID, w.Header().Get("Access-Control-Expose-Headers"))
+		assert.Len(t, exporter.GetSpans(), 1)
+	})
+
+	t.Run("disallowed origin", func(t *testing.T) {
+		for _, origin := range []string{"https://b.com", "https://.example.com", "https://example.com"} {
+			w := serve(http.MethodGet, origin, nil)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+		}
+	})
+
+	t.Run("no origin", func(t *testing.T) {
+		w := serve(http.MethodGet, "", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Vary"))
+	})
+}
+
+func TestCORSHandler(t *testing.T) {
+	handler := CORSHandler(CORSOptions{AllowOrigins: []string{"*"}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	req := httptest.NewRequest(http.MethodOptions, "/hello", nil)
+	req.Header.Set("Origin", "https://any.com")
+	req.Header.Set("Access-Control-Request-Method", "DELETE")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, w.Header().Get("Access-Control-Allow-Methods"), "DELETE")
+
+	req = httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req.Header.Set("Origin", "https://any.com")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusTeapot, w.Code)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+}
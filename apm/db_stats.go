@@ -0,0 +1,58 @@
+package apm
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dbStatsCollector is a prometheus.Collector which exposes the connection pool stats of sql.DB.
+type dbStatsCollector struct {
+	db *sql.DB
+
+	openConnections *prometheus.Desc
+	inUse           *prometheus.Desc
+	idle            *prometheus.Desc
+	waitCount       *prometheus.Desc
+	waitDuration    *prometheus.Desc
+}
+
+// NewDBStatsCollector returns a collector which exposes the connection pool stats of sql.DB.
+// name is the business name of the db, it will be used as the "name" label of the metrics.
+// The metric names are prefixed with the namespace set by WithMetricNamespace when it is created.
+func NewDBStatsCollector(name string, db *sql.DB) prometheus.Collector {
+	labels := prometheus.Labels{"name": name}
+	namespace := goapmVecs().namespace
+	return &dbStatsCollector{
+		db: db,
+		openConnections: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "db_open_connections"),
+			"The number of established connections both in use and idle", nil, labels),
+		inUse: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "db_in_use"),
+			"The number of connections currently in use", nil, labels),
+		idle: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "db_idle"),
+			"The number of idle connections", nil, labels),
+		waitCount: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "db_wait_count"),
+			"The total number of connections waited for", nil, labels),
+		waitDuration: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "db_wait_duration_seconds"),
+			"The total time blocked waiting for a new connection", nil, labels),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.openConnections
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.waitCount
+	ch <- c.waitDuration
+}
+
+// Collect implements prometheus.Collector.
+func (c *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.db.Stats()
+	ch <- prometheus.MustNewConstMetric(c.openConnections, prometheus.GaugeValue, float64(stats.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.inUse, prometheus.GaugeValue, float64(stats.InUse))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stats.Idle))
+	ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.GaugeValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.GaugeValue, stats.WaitDuration.Seconds())
+}
@@ -1,11 +1,47 @@
 package apm
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+)
 
-type dbUtils struct{}
+type dbUtils struct {
+	// stringValues keeps all the []byte values as strings, see WithStringValues.
+	stringValues bool
+	// nullValue is the value of the NULL columns, see WithNullValue.
+	nullValue any
+}
 
+// DBUtils maps the query results to maps, the values are converted to the Go types by the column types,
+// i.e. the integers to int64 or uint64, the floats to float64, and the dates and times to time.Time in UTC.
+// The booleans are TINYINT(1) in mysql, which the driver reports as TINYINT, so they are returned as int64 0 or 1.
+// The decimals are kept as strings to avoid losing the precision.
+// The NULL columns are kept in the maps as nil, so that all the maps of a query have the same keys.
 var DBUtils = &dbUtils{}
 
+// WithStringValues returns a DBUtils keeping the []byte values as strings without the type conversion,
+// which is the legacy behavior.
+func (d *dbUtils) WithStringValues() *dbUtils {
+	c := *d
+	c.stringValues = true
+	return &c
+}
+
+// WithNullValue returns a DBUtils setting the NULL columns to v instead of nil, e.g. "" or sql.RawBytes(nil).
+func (d *dbUtils) WithNullValue(v any) *dbUtils {
+	c := *d
+	c.nullValue = v
+	return &c
+}
+
+// Queryer is the interface to run the queries, e.g. *sql.DB, *sql.Tx and *sql.Conn.
+type Queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
 func (d *dbUtils) Query(rows *sql.Rows, err any) []map[string]any {
 	if err != nil {
 		return nil
@@ -13,11 +49,50 @@ func (d *dbUtils) Query(rows *sql.Rows, err any) []map[string]any {
 	if rows == nil {
 		return make([]map[string]any, 0)
 	}
+	res, scanErr := d.scanMaps(rows)
+	if scanErr != nil {
+		return nil
+	}
+	return res
+}
+
+func (d *dbUtils) QueryFirst(rows *sql.Rows, err any) map[string]any {
+	res := d.Query(rows, err)
+	if len(res) == 0 {
+		return nil
+	}
+	return res[0]
+}
+
+// QueryMap runs the query by db and maps each row to a map from the column names to the values.
+// Run it by the db created by NewMySQL or its transactions, so that the query is traced.
+// Different from Query, the errors of the query and scanning are returned.
+func (d *dbUtils) QueryMap(ctx context.Context, db Queryer, query string, args ...any) ([]map[string]any, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return d.scanMaps(rows)
+}
+
+// scanMaps scans the rows into the maps and closes the rows.
+func (d *dbUtils) scanMaps(rows *sql.Rows) ([]map[string]any, error) {
 	defer func() { _ = rows.Close() }()
 
 	columns, err := rows.Columns()
 	if err != nil {
-		return nil
+		return nil, err
+	}
+	var typeNames []string
+	if !d.stringValues {
+		columnTypes, err := rows.ColumnTypes()
+		if err != nil {
+			return nil, err
+		}
+		typeNames = make([]string, len(columnTypes))
+		for i, ct := range columnTypes {
+			typeNames[i] = strings.ToUpper(ct.DatabaseTypeName())
+		}
 	}
 	scanArgs := make([]any, len(columns))
 	values := make([]any, len(columns))
@@ -27,30 +102,54 @@ func (d *dbUtils) Query(rows *sql.Rows, err any) []map[string]any {
 	res := make([]map[string]any, 0)
 	for rows.Next() {
 		record := make(map[string]any)
-		err = rows.Scan(scanArgs...)
-		if err != nil {
-			return nil
+		if err := rows.Scan(scanArgs...); err != nil {
+			return nil, err
 		}
 		for i, col := range values {
-			if col != nil {
-				switch v := col.(type) {
-				case []byte:
+			switch v := col.(type) {
+			case nil:
+				record[columns[i]] = d.nullValue
+			case []byte:
+				if d.stringValues {
 					record[columns[i]] = string(v)
-				default:
-					record[columns[i]] = col
+				} else {
+					record[columns[i]] = convertColumnValue(typeNames[i], v)
 				}
+			default:
+				record[columns[i]] = col
 			}
 		}
 
 		res = append(res, record)
 	}
-	return res
+	return res, rows.Err()
 }
 
-func (d *dbUtils) QueryFirst(rows *sql.Rows, err any) map[string]any {
-	res := d.Query(rows, err)
-	if len(res) == 0 {
-		return nil
+// convertColumnValue converts the raw value in the text protocol to the Go type by the database type name,
+// the value is kept as a string if the type is unknown or the conversion fails.
+func convertColumnValue(typeName string, v []byte) any {
+	str := string(v)
+	switch strings.TrimPrefix(typeName, "UNSIGNED ") {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "INTEGER", "BIGINT", "YEAR":
+		if strings.HasPrefix(typeName, "UNSIGNED ") {
+			if n, err := strconv.ParseUint(str, 10, 64); err == nil {
+				return n
+			}
+		} else if n, err := strconv.ParseInt(str, 10, 64); err == nil {
+			return n
+		}
+	case "FLOAT", "DOUBLE", "REAL":
+		if f, err := strconv.ParseFloat(str, 64); err == nil {
+			return f
+		}
+	case "DATE":
+		if t, err := time.Parse(time.DateOnly, str); err == nil {
+			return t
+		}
+	case "DATETIME", "TIMESTAMP":
+		if t, err := time.Parse("2006-01-02 15:04:05.999999999", str); err == nil {
+			return t
+		}
 	}
-	return res[0]
+	return str
 }
@@ -0,0 +1,97 @@
+package apm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDBUtils_QueryMap(t *testing.T) {
+	exporter := setupTracingTest()
+	db, err := NewMySQL("test", "root:root@tcp(127.0.0.1:3306)/goapm")
+	assert.Nil(t, err)
+	defer db.Close()
+
+	ctx, span := StartNamedSpan(context.Background(), "query map")
+	res, err := DBUtils.QueryMap(ctx, db, "SELECT `uid`, `name` FROM `t_user` WHERE `uid` IN (?, ?) ORDER BY `uid`", "u001", "u002")
+	span.End()
+	assert.Nil(t, err)
+	assert.Equal(t, []map[string]any{{"uid": "u001", "name": "Alice"}, {"uid": "u002", "name": "Bob"}}, res)
+
+	var querySpans int
+	for _, s := range exporter.GetSpans() {
+		if s.Parent.SpanID() == span.SpanContext().SpanID() {
+			querySpans++
+		}
+	}
+	assert.NotZero(t, querySpans)
+
+	_, err = DBUtils.QueryMap(context.Background(), db, "SELECT * FROM `t_not_exists`")
+	assert.NotNil(t, err)
+}
+
+func TestDBUtils_TypeConversion(t *testing.T) {
+	db, err := NewMySQL("test", "root:root@tcp(127.0.0.1:3306)/goapm")
+	assert.Nil(t, err)
+	defer db.Close()
+
+	query := "SELECT `id`, `name`, `age`, `salary`, `ctime` FROM `t_user` WHERE `uid` = 'u001'"
+	res, err := DBUtils.QueryMap(context.Background(), db, query)
+	assert.Nil(t, err)
+	assert.Len(t, res, 1)
+	assert.IsType(t, int64(0), res[0]["id"])
+	assert.Equal(t, "Alice", res[0]["name"])
+	assert.Equal(t, int64(25), res[0]["age"])
+	assert.Equal(t, "50000.00", res[0]["salary"])
+	assert.IsType(t, time.Time{}, res[0]["ctime"])
+
+	res, err = DBUtils.WithStringValues().QueryMap(context.Background(), db, query)
+	assert.Nil(t, err)
+	assert.Equal(t, "50000.00", res[0]["salary"])
+	assert.IsType(t, "", res[0]["ctime"])
+}
+
+func TestConvertColumnValue(t *testing.T) {
+	tests := []struct {
+		typeName string
+		value    string
+		want     any
+	}{
+		{"BIGINT", "-42", int64(-42)},
+		{"UNSIGNED BIGINT", "18446744073709551615", uint64(18446744073709551615)},
+		{"DOUBLE", "1.5", 1.5},
+		{"TINYINT", "1", int64(1)},
+		{"DATE", "2024-01-02", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"DATETIME", "2024-01-02 03:04:05.123", time.Date(2024, 1, 2, 3, 4, 5, 123000000, time.UTC)},
+		{"DECIMAL", "1.10", "1.10"},
+		{"DATETIME", "0000-00-00 00:00:00", "0000-00-00 00:00:00"},
+		{"VARCHAR", "abc", "abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typeName+" "+tt.value, func(t *testing.T) {
+			assert.Equal(t, tt.want, convertColumnValue(tt.typeName, []byte(tt.value)))
+		})
+	}
+}
+
+func TestDBUtils_NullValue(t *testing.T) {
+	db, err := NewMySQL("test", "root:root@tcp(127.0.0.1:3306)/goapm")
+	assert.Nil(t, err)
+	defer db.Close()
+
+	query := "SELECT `name`, IF(`uid` = 'u001', NULL, `email`) AS `email` FROM `t_user` WHERE `uid` IN ('u001', 'u002') ORDER BY `uid`"
+	res, err := DBUtils.QueryMap(context.Background(), db, query)
+	assert.Nil(t, err)
+	assert.Len(t, res, 2)
+	email, ok := res[0]["email"]
+	assert.True(t, ok)
+	assert.Nil(t, email)
+	assert.Equal(t, "bob@example.com", res[1]["email"])
+
+	res, err = DBUtils.WithStringValues().WithNullValue("").QueryMap(context.Background(), db, query)
+	assert.Nil(t, err)
+	assert.Equal(t, "", res[0]["email"])
+	assert.Equal(t, "bob@example.com", res[1]["email"])
+}
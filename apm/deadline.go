@@ -0,0 +1,30 @@
+package apm
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+)
+
+// WithNoDeadlineCheck marks the grpc client calls made without a context deadline,
+// which may hang forever if the server does not respond. The span of the call is set with "no_deadline",
+// and the client_no_deadline_total metric is increased with the target of the connection as the server.
+func WithNoDeadlineCheck() grpc.DialOption {
+	// chained after the goapm interceptor, so that the span of the call is in ctx
+	return grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply any,
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		checkDeadline(ctx, MetricTypeGRPC, method, cc.Target())
+		return invoker(ctx, method, req, reply, cc, opts...)
+	})
+}
+
+// checkDeadline records the "no_deadline" attribute and the metric if ctx has no deadline.
+func checkDeadline(ctx context.Context, typ, method, server string) {
+	if _, ok := ctx.Deadline(); ok {
+		return
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("no_deadline", true))
+	goapmVecs().clientNoDeadlineCounter.WithLabelValues(typ, method, server).Inc()
+}
@@ -0,0 +1,27 @@
+package apm
+
+import "sync/atomic"
+
+// disabled turns the goapm instrumentations into passthroughs, see Disable.
+var disabled atomic.Bool
+
+// Disable turns off the whole goapm instrumentation, e.g. for the unit tests:
+// NewAPM sets no tracer provider, and GinOtel, TraceHTTP, the grpc interceptors, the http client,
+// the sql, gorm and redis hooks only call the next handlers without tracing, metrics or logging.
+// The functional behaviors are kept, e.g. the query timeout of the sql hooks.
+// It should be called before anything is created, since a span started before would not be ended after it.
+// The components could also be disabled one by one, e.g. by WithMySQLDisabled, WithRedisDisabled,
+// WithHTTPDisabled and WithGrpcDisabled, which is what goapm.WithDisabled does for the components of an infra.
+func Disable() {
+	disabled.Store(true)
+}
+
+// Enable reverts Disable, e.g. at the end of a test, it should be called when no request is being handled.
+func Enable() {
+	disabled.Store(false)
+}
+
+// Disabled reports whether the goapm instrumentation is disabled by Disable.
+func Disabled() bool {
+	return disabled.Load()
+}
@@ -0,0 +1,43 @@
+package apm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDisable(t *testing.T) {
+	exporter := setupTracingTest()
+	Disable()
+	t.Cleanup(Enable)
+	assert.True(t, Disabled())
+
+	closeFunc, err := NewAPM("localhost:4317")
+	assert.Nil(t, err)
+	closeFunc()
+
+	server := httptest.NewServer(TraceHTTPMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})))
+	defer server.Close()
+
+	resp, err := NewHTTPClient("test").Get(server.URL + "/hello")
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, exporter.GetSpans())
+}
+
+func TestWithHTTPDisabled(t *testing.T) {
+	exporter := setupTracingTest()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	TraceHTTPMiddleware(WithHTTPDisabled(true))(handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Empty(t, exporter.GetSpans())
+
+	// the other handlers are still traced
+	TraceHTTPMiddleware()(handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Len(t, exporter.GetSpans(), 1)
+}
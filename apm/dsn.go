@@ -0,0 +1,67 @@
+package apm
+
+import (
+	"strings"
+)
+
+const redactedPassword = "***"
+
+// redactDSN masks the password in the mysql dsn, e.g. "root:***@tcp(127.0.0.1:3306)/test",
+// so that the dsn could be logged or recorded safely.
+func redactDSN(dsn string) string {
+	start, end, ok := dsnPasswordRange(dsn)
+	if !ok {
+		return dsn
+	}
+	return dsn[:start] + redactedPassword + dsn[end:]
+}
+
+// dsnPassword returns the password in the mysql dsn, or "" if there is none.
+func dsnPassword(dsn string) string {
+	start, end, ok := dsnPasswordRange(dsn)
+	if !ok {
+		return ""
+	}
+	return dsn[start:end]
+}
+
+// dsnPasswordRange returns the range of the password in the mysql dsn,
+// the format is [username[:password]@][protocol[(address)]]/dbname[?params],
+// the password may contain '@' and '/', so the last '@' before the last '/' separates the credentials.
+func dsnPasswordRange(dsn string) (start, end int, ok bool) {
+	slash := strings.LastIndexByte(dsn, '/')
+	if slash < 0 {
+		slash = len(dsn)
+	}
+	at := strings.LastIndexByte(dsn[:slash], '@')
+	if at < 0 {
+		return 0, 0, false
+	}
+	colon := strings.IndexByte(dsn[:at], ':')
+	if colon < 0 {
+		return 0, 0, false
+	}
+	return colon + 1, at, true
+}
+
+// redactedError is an error whose message has the dsn password masked, the original error is kept for errors.Is/As.
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+
+func (e *redactedError) Unwrap() error { return e.err }
+
+// redactDSNError masks the dsn password in the error message, in case the driver puts the dsn into it.
+func redactDSNError(err error, dsn string) error {
+	if err == nil {
+		return nil
+	}
+	password := dsnPassword(dsn)
+	if password == "" || !strings.Contains(err.Error(), password) {
+		return err
+	}
+	return &redactedError{msg: strings.ReplaceAll(err.Error(), password, redactedPassword), err: err}
+}
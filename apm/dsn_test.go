@@ -0,0 +1,39 @@
+package apm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactDSN(t *testing.T) {
+	tests := []struct {
+		dsn  string
+		want string
+	}{
+		{"root:password123@tcp(127.0.0.1:3306)/test", "root:***@tcp(127.0.0.1:3306)/test"},
+		{"root:p@ss/word@tcp(127.0.0.1:3306)/test?parseTime=true", "root:***@tcp(127.0.0.1:3306)/test?parseTime=true"},
+		{"root:@tcp(127.0.0.1:3306)/test", "root:***@tcp(127.0.0.1:3306)/test"},
+		{"root@tcp(127.0.0.1:3306)/test", "root@tcp(127.0.0.1:3306)/test"},
+		{"/test", "/test"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, redactDSN(tt.dsn))
+	}
+}
+
+func TestRedactDSNError(t *testing.T) {
+	dsn := "root:password123@tcp(127.0.0.1:1)/test"
+	origin := errors.New("failed to connect " + dsn)
+	err := redactDSNError(origin, dsn)
+	assert.NotContains(t, err.Error(), "password123")
+	assert.Contains(t, err.Error(), "root:***@tcp(127.0.0.1:1)/test")
+	assert.ErrorIs(t, err, origin)
+
+	_, err = NewMySQL("redact", dsn)
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "password123")
+
+	assert.NotContains(t, newGormDialector("redact", dsn, newMySQLOptions()).connectURL, "password123")
+}
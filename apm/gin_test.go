@@ -1,13 +1,22 @@
 package apm
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func init() {
@@ -47,3 +56,188 @@ func TestGinServer_Handle(t *testing.T) {
 		}
 	}
 }
+
+func TestGinOtel_Panic(t *testing.T) {
+	exporter := setupTracingTest()
+
+	var hooked, nextCalled bool
+	router := gin.New()
+	router.Use(GinOtel(WithPanicHook(func(ctx context.Context, panic any) bool {
+		hooked = true
+		return false
+	})))
+	router.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	}, func(c *gin.Context) {
+		nextCalled = true
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.True(t, hooked)
+	assert.False(t, nextCalled)
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "HTTP GET /panic", spans[0].Name)
+	assert.Contains(t, spans[0].Attributes, attribute.Bool("error", true))
+}
+
+type testBusinessError struct{}
+
+func (testBusinessError) Code() string { return "USER_NOT_FOUND" }
+func (testBusinessError) Msg() string  { return "用户不存在" }
+
+func TestGinOtel_BusinessError(t *testing.T) {
+	exporter := setupTracingTest()
+
+	router := gin.New()
+	router.Use(GinOtel())
+	router.GET("/user", func(c *gin.Context) {
+		SetBusinessError(c, testBusinessError{})
+		c.JSON(http.StatusOK, gin.H{})
+	})
+
+	before := testutil.ToFloat64(goapmVecs().businessErrorCounter.WithLabelValues("USER_NOT_FOUND"))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/user", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, before+1, testutil.ToFloat64(goapmVecs().businessErrorCounter.WithLabelValues("USER_NOT_FOUND")))
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Contains(t, spans[0].Attributes, attribute.String("http.response.business_error_code", "USER_NOT_FOUND"))
+	assert.Contains(t, spans[0].Attributes, attribute.String("http.response.business_error_msg", "用户不存在"))
+}
+
+func TestGinOtel_RawBusinessErrorMsg(t *testing.T) {
+	exporter := setupTracingTest()
+
+	router := gin.New()
+	router.Use(GinOtel())
+	router.GET("/user", func(c *gin.Context) {
+		c.Header(HeaderBusinessErrorCode, "BAD_QUERY")
+		c.Header(HeaderBusinessErrorMsg, "a+b=100%25")
+		c.JSON(http.StatusOK, gin.H{})
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user", nil))
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Contains(t, spans[0].Attributes, attribute.String("http.response.business_error_msg", "a+b=100%25"))
+}
+
+func TestRequestID(t *testing.T) {
+	exporter := setupTracingTest()
+	buf := &bytes.Buffer{}
+	logrus.SetOutput(buf)
+	defer logrus.SetOutput(os.Stderr)
+
+	router := gin.New()
+	router.Use(GinOtel(), RequestID())
+	router.GET("/hello", func(c *gin.Context) {
+		Logger.Info(c.Request.Context(), "hello", nil)
+	})
+
+	t.Run("request id should be propagated", func(t *testing.T) {
+		exporter.Reset()
+		buf.Reset()
+		r := httptest.NewRequest(http.MethodGet, "/hello", nil)
+		r.Header.Set(HeaderRequestID, "req-1")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, "req-1", w.Header().Get(HeaderRequestID))
+		assert.Contains(t, exporter.GetSpans()[0].Attributes, attribute.String("request_id", "req-1"))
+		assert.Contains(t, buf.String(), `"request_id":"req-1"`)
+	})
+
+	t.Run("request id should be generated if absent", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/hello", nil))
+		assert.NotEmpty(t, w.Header().Get(HeaderRequestID))
+	})
+}
+
+func ginTestHandler(c *gin.Context) {
+	c.Status(http.StatusOK)
+}
+
+func TestGinOtel_HandlerName(t *testing.T) {
+	exporter := setupTracingTest()
+	router := gin.New()
+	router.Use(GinOtel())
+	router.GET("/hello", func(c *gin.Context) { c.Next() }, ginTestHandler)
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/hello", nil))
+	assert.Contains(t, exporter.GetSpans()[0].Attributes,
+		attribute.String("http.handler", "github.com/hedon954/goapm/apm.ginTestHandler"))
+
+	// no handler is matched
+	exporter.Reset()
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/not-found", nil))
+	for _, attr := range exporter.GetSpans()[0].Attributes {
+		assert.NotEqual(t, attribute.Key("http.handler"), attr.Key)
+	}
+}
+
+func TestGinOtel_TraceResponseHeader(t *testing.T) {
+	exporter := setupTracingTest()
+	router := gin.New()
+	router.Use(GinOtel(WithTraceResponseHeader("X-Trace-Id")))
+	router.GET("/hello", func(c *gin.Context) { c.String(http.StatusOK, "hello") })
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/hello", nil))
+	assert.Equal(t, exporter.GetSpans()[0].SpanContext.TraceID().String(), w.Header().Get("X-Trace-Id"))
+}
+
+func TestRecordBindError(t *testing.T) {
+	exporter := setupTracingTest()
+	type request struct {
+		Name string `json:"name" binding:"required"`
+		Age  int    `json:"age" binding:"gte=18"`
+	}
+	router := gin.New()
+	router.Use(GinOtel())
+	router.POST("/bind", func(c *gin.Context) {
+		var req request
+		if err := c.ShouldBindJSON(&req); err != nil {
+			RecordBindError(c, err)
+			c.Status(http.StatusBadRequest)
+		}
+	})
+
+	serve := func(body string) []attribute.KeyValue {
+		exporter.Reset()
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/bind", bytes.NewBufferString(body)))
+		return exporter.GetSpans()[0].Attributes
+	}
+
+	assert.Contains(t, serve(`{"age":1}`), attribute.String("http.request.validation_errors",
+		`[{"field":"request.Name","tag":"required"},{"field":"request.Age","tag":"gte","param":"18"}]`))
+	assert.Contains(t, serve(`{`), attribute.String("http.request.bind_error", "unexpected EOF"))
+}
+
+func TestGinOtel_Errors(t *testing.T) {
+	exporter := setupTracingTest()
+	router := gin.New()
+	router.Use(GinOtel())
+	router.GET("/errors", func(c *gin.Context) {
+		_ = c.Error(errors.New("first error"))
+		_ = c.Error(errors.New("second error"))
+		c.Status(http.StatusOK)
+	})
+	router.GET("/ok", func(c *gin.Context) {})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/errors", nil))
+	span := exporter.GetSpans()[0]
+	assert.Contains(t, span.Attributes, attribute.Bool("error", true))
+	assert.Len(t, span.Events, 2)
+	assert.Contains(t, span.Events[0].Attributes, attribute.String("exception.message", "first error"))
+
+	exporter.Reset()
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+	assert.NotContains(t, exporter.GetSpans()[0].Attributes, attribute.Bool("error", true))
+}
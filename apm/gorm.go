@@ -12,11 +12,28 @@ import (
 )
 
 // NewGorm returns a new Gorm DB with hooks.
-func NewGorm(name, connectURL string) (*gorm.DB, error) {
-	db, err := gorm.Open(newGormDialector(name, connectURL), &gorm.Config{})
+// Besides the sqltrace spans recorded by the driver, it also starts a span for each gorm operation.
+func NewGorm(name, connectURL string, opts ...MySQLOption) (*gorm.DB, error) {
+	o := newMySQLOptions(opts...)
+	if o.gormLogger == nil {
+		o.gormLogger = NewGormLogger()
+	}
+	db, err := gorm.Open(newGormDialector(name, connectURL, o), &gorm.Config{
+		Logger: o.gormLogger,
+	})
+	if err != nil {
+		return nil, redactDSNError(err, connectURL)
+	}
+	sqlDB, err := db.DB()
 	if err != nil {
 		return nil, err
 	}
+	o.configurePool(sqlDB)
+	if !o.disabled {
+		if err := db.Use(newGormPlugin()); err != nil {
+			return nil, err
+		}
+	}
 
 	Logger.Info(context.TODO(), fmt.Sprintf("mysql gorm client[%s] connected", name), nil)
 	return db, nil
@@ -24,21 +41,22 @@ func NewGorm(name, connectURL string) (*gorm.DB, error) {
 
 // gormDialector is a wrapper of gorm.Dialector which provides hooks.
 type gormDialector struct {
+	// connectURL is the redacted dsn, the password is masked.
 	connectURL string
 	driverName string
 	gorm.Dialector
 }
 
-func newGormDialector(name, connectURL string) *gormDialector {
+func newGormDialector(name, connectURL string, o *mysqlOptions) *gormDialector {
 	driverName := fmt.Sprintf("%s-%s", "mysql-wrapper", uuid.NewString())
-	sql.Register(driverName, wrap(&mysqldriver.MySQLDriver{}, name, connectURL))
+	sql.Register(driverName, wrap(&mysqldriver.MySQLDriver{}, name, connectURL, o))
 	return &gormDialector{
-		connectURL: connectURL,
+		connectURL: redactDSN(connectURL),
 		driverName: driverName,
-		Dialector:  mysql.Open(connectURL),
+		// DriverName makes gorm open the db with the wrapped driver instead of the default mysql driver.
+		Dialector: mysql.New(mysql.Config{
+			DriverName: driverName,
+			DSN:        connectURL,
+		}),
 	}
 }
-
-func (d *gormDialector) Name() string {
-	return d.driverName
-}
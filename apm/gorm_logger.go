@@ -0,0 +1,99 @@
+package apm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// GormLogger is a gorm logger.Interface which forwards the gorm logs to the goapm Logger,
+// so that the gorm logs share the same format with others and could be correlated with the traces.
+type GormLogger struct {
+	// LogLevel is the log level of gorm, default is warn.
+	LogLevel gormlogger.LogLevel
+	// SlowThreshold is the threshold of slow query, the query exceeding it would be logged as a warning.
+	// Default is the slow sql threshold set by SetSlowSqlThreshold.
+	SlowThreshold time.Duration
+	// IgnoreRecordNotFoundError ignores the gorm.ErrRecordNotFound error, default is true.
+	IgnoreRecordNotFoundError bool
+}
+
+// NewGormLogger creates a new gorm logger which forwards the logs to the goapm Logger.
+func NewGormLogger() *GormLogger {
+	return &GormLogger{
+		LogLevel:                  gormlogger.Warn,
+		SlowThreshold:             slowSqlThreshold,
+		IgnoreRecordNotFoundError: true,
+	}
+}
+
+// LogMode returns a copy of the logger with the given log level.
+func (l *GormLogger) LogMode(level gormlogger.LogLevel) gormlogger.Interface {
+	newLogger := *l
+	newLogger.LogLevel = level
+	return &newLogger
+}
+
+// Info logs the message in info level.
+func (l *GormLogger) Info(ctx context.Context, msg string, data ...any) {
+	if l.LogLevel >= gormlogger.Info {
+		Logger.Info(ctx, fmt.Sprintf(msg, data...), l.fields(ctx, nil))
+	}
+}
+
+// Warn logs the message in warn level.
+func (l *GormLogger) Warn(ctx context.Context, msg string, data ...any) {
+	if l.LogLevel >= gormlogger.Warn {
+		Logger.Warn(ctx, fmt.Sprintf(msg, data...), l.fields(ctx, nil))
+	}
+}
+
+// Error logs the message in error level.
+func (l *GormLogger) Error(ctx context.Context, msg string, data ...any) {
+	if l.LogLevel >= gormlogger.Error {
+		msg = fmt.Sprintf(msg, data...)
+		Logger.Error(ctx, "gorm error", errors.New(msg), l.fields(ctx, nil))
+	}
+}
+
+// Trace logs the sql executed by gorm, the failed and slow queries would be logged in error and warn level.
+func (l *GormLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if l.LogLevel <= gormlogger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+	switch {
+	case err != nil && l.LogLevel >= gormlogger.Error &&
+		(!errors.Is(err, gorm.ErrRecordNotFound) || !l.IgnoreRecordNotFoundError):
+		Logger.Error(ctx, "gorm query failed", err, l.traceFields(ctx, elapsed, fc))
+	case l.SlowThreshold > 0 && elapsed > l.SlowThreshold && l.LogLevel >= gormlogger.Warn:
+		Logger.Warn(ctx, "gorm slow query", l.traceFields(ctx, elapsed, fc))
+	case l.LogLevel >= gormlogger.Info:
+		Logger.Info(ctx, "gorm query", l.traceFields(ctx, elapsed, fc))
+	}
+}
+
+func (l *GormLogger) traceFields(ctx context.Context, elapsed time.Duration, fc func() (string, int64)) map[string]any {
+	sql, rows := fc()
+	return l.fields(ctx, map[string]any{
+		"sql":         truncate(sql),
+		"rows":        rows,
+		"duration_ms": elapsed.Milliseconds(),
+	})
+}
+
+// fields attaches the trace id to the kv, since only the error logs get it from the logrus hook.
+func (l *GormLogger) fields(ctx context.Context, kv map[string]any) map[string]any {
+	if kv == nil {
+		kv = make(map[string]any)
+	}
+	if id := TraceIDFromContext(ctx); id != "" {
+		kv[traceID] = id
+	}
+	return kv
+}
@@ -0,0 +1,61 @@
+package apm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+func TestGormLogger_Trace(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logrus.SetOutput(buf)
+	defer logrus.SetOutput(os.Stderr)
+
+	ctx, span := otel.Tracer("test").Start(context.Background(), "test")
+	defer span.End()
+	fc := func() (string, int64) { return "SELECT 1", 1 }
+
+	l := NewGormLogger()
+	l.SlowThreshold = 10 * time.Millisecond
+
+	t.Run("fast query should not be logged in warn level", func(t *testing.T) {
+		buf.Reset()
+		l.Trace(ctx, time.Now(), fc, nil)
+		assert.Empty(t, buf.String())
+	})
+
+	t.Run("slow query should be logged with trace id", func(t *testing.T) {
+		buf.Reset()
+		l.Trace(ctx, time.Now().Add(-time.Second), fc, nil)
+		assert.Contains(t, buf.String(), "gorm slow query")
+		assert.Contains(t, buf.String(), span.SpanContext().TraceID().String())
+	})
+
+	t.Run("record not found should be ignored", func(t *testing.T) {
+		buf.Reset()
+		l.Trace(ctx, time.Now(), fc, gorm.ErrRecordNotFound)
+		assert.Empty(t, buf.String())
+	})
+
+	t.Run("failed query should be logged in error level", func(t *testing.T) {
+		buf.Reset()
+		l.Trace(ctx, time.Now(), fc, errors.New("boom"))
+		assert.Contains(t, buf.String(), "gorm query failed")
+		assert.Contains(t, buf.String(), `"level":"error"`)
+	})
+
+	t.Run("silent mode should log nothing", func(t *testing.T) {
+		buf.Reset()
+		l.LogMode(gormlogger.Silent).Trace(ctx, time.Now(), fc, errors.New("boom"))
+		assert.Empty(t, buf.String())
+	})
+}
@@ -0,0 +1,88 @@
+package apm
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const (
+	gormTracerName = "goapm/gorm"
+
+	gormPluginName = "goapm:tracing"
+	gormSpanKey    = "goapm:span"
+)
+
+// gormPlugin is a gorm.Plugin which starts a span for each gorm operation.
+// The span is named as "gorm.<op> <table>", and the sqltrace spans of the underlying driver would be its children.
+// It only records traces, the metrics are still recorded by the driver to avoid double-counting.
+type gormPlugin struct {
+	tracer trace.Tracer
+}
+
+func newGormPlugin() *gormPlugin {
+	return &gormPlugin{tracer: otel.Tracer(gormTracerName)}
+}
+
+// Name returns the name of the plugin.
+func (p *gormPlugin) Name() string {
+	return gormPluginName
+}
+
+// Initialize registers the callbacks around each gorm operation.
+func (p *gormPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	return errors.Join(
+		cb.Create().Before("gorm:create").Register("goapm:before_create", p.before("create")),
+		cb.Create().After("gorm:create").Register("goapm:after_create", p.after),
+		cb.Query().Before("gorm:query").Register("goapm:before_query", p.before("query")),
+		cb.Query().After("gorm:query").Register("goapm:after_query", p.after),
+		cb.Update().Before("gorm:update").Register("goapm:before_update", p.before("update")),
+		cb.Update().After("gorm:update").Register("goapm:after_update", p.after),
+		cb.Delete().Before("gorm:delete").Register("goapm:before_delete", p.before("delete")),
+		cb.Delete().After("gorm:delete").Register("goapm:after_delete", p.after),
+		cb.Row().Before("gorm:row").Register("goapm:before_row", p.before("row")),
+		cb.Row().After("gorm:row").Register("goapm:after_row", p.after),
+		cb.Raw().Before("gorm:raw").Register("goapm:before_raw", p.before("raw")),
+		cb.Raw().After("gorm:raw").Register("goapm:after_raw", p.after),
+	)
+}
+
+func (p *gormPlugin) before(op string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		if Disabled() {
+			return
+		}
+		name := "gorm." + op
+		if table := db.Statement.Table; table != "" {
+			name += " " + table
+		}
+		ctx, span := p.tracer.Start(db.Statement.Context, name)
+		span.SetAttributes(
+			attribute.String("gorm.op", op),
+			attribute.String("gorm.table", db.Statement.Table),
+		)
+		db.Statement.Context = ctx
+		db.InstanceSet(gormSpanKey, span)
+	}
+}
+
+func (p *gormPlugin) after(db *gorm.DB) {
+	v, ok := db.InstanceGet(gormSpanKey)
+	if !ok {
+		return
+	}
+	span, ok := v.(trace.Span)
+	if !ok {
+		return
+	}
+	defer span.End()
+
+	span.SetAttributes(attribute.Int64("gorm.rows_affected", db.RowsAffected))
+	if setSpanError(span, db.Error) {
+		span.SetAttributes(attribute.String("gorm.error", db.Error.Error()))
+	}
+}
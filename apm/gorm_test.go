@@ -7,6 +7,8 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"gorm.io/gorm"
 )
 
@@ -200,3 +202,31 @@ func Test_GORM_DELETE(t *testing.T) {
 		assert.Equal(t, gorm.ErrRecordNotFound, result.Error)
 	})
 }
+
+func Test_GORM_Plugin(t *testing.T) {
+	db, err := setupTestDB()
+	assert.Nil(t, err)
+
+	exporter := setupTracingTest()
+	user := User{
+		Uid:    uuid.NewString(),
+		Name:   "John",
+		Age:    18,
+		Salary: 10000,
+	}
+	result := db.Create(&user)
+	assert.Nil(t, result.Error)
+
+	var sqlSpan, gormSpan tracetest.SpanStub
+	for _, span := range exporter.GetSpans() {
+		switch span.Name {
+		case "sqltrace":
+			sqlSpan = span
+		case "gorm.create t_user":
+			gormSpan = span
+		}
+	}
+	assert.True(t, gormSpan.SpanContext.IsValid())
+	assert.Equal(t, gormSpan.SpanContext.SpanID(), sqlSpan.Parent.SpanID())
+	assert.Contains(t, gormSpan.Attributes, attribute.Int64("gorm.rows_affected", 1))
+}
@@ -0,0 +1,33 @@
+package apm
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	metadataKeyAuthorization = "authorization"
+)
+
+// WithGrpcAuthToken injects "authorization: Bearer <token>" into the outgoing metadata of each call,
+// the token is got from tokenFn per call, so that it could be cached and refreshed by tokenFn.
+// The call fails with codes.Unauthenticated without being sent if tokenFn returns an error.
+// It is chained after the goapm interceptor, and the token is never recorded in the spans or logs.
+func WithGrpcAuthToken(tokenFn func(ctx context.Context) (string, error)) grpc.DialOption {
+	return grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply any,
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		token, err := tokenFn(ctx)
+		if err != nil {
+			return status.Errorf(codes.Unauthenticated, "goapm failed to get grpc auth token: %v", err)
+		}
+		// the metadata of ctx should not be modified, since it may be shared by the concurrent calls
+		md, _ := metadata.FromOutgoingContext(ctx)
+		md = md.Copy()
+		md.Set(metadataKeyAuthorization, "Bearer "+token)
+		return invoker(metadata.NewOutgoingContext(ctx, md), method, req, reply, cc, opts...)
+	})
+}
@@ -8,6 +8,8 @@ import (
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
@@ -17,6 +19,9 @@ import (
 
 const (
 	grpcClientTracerName = "goapm/grpcClient"
+
+	// grpcRoundRobinServiceConfig is the service config balancing the calls over all the resolved addresses.
+	grpcRoundRobinServiceConfig = `{"loadBalancingConfig":[{"round_robin":{}}]}`
 )
 
 // GrpcClient is a wrapper around grpc.ClientConn that provides tracing, metrics, and logging.
@@ -24,9 +29,12 @@ type GrpcClient struct {
 	*grpc.ClientConn
 }
 
+// NewGrpcClient creates a grpc client of addr, server is the name of the downstream used in the metrics.
+// addr is resolved by dns unless it has another scheme, e.g. "dns:///service.namespace:8080" or "service.namespace:8080".
+// The custom interceptors should be added by WithUnaryClientInterceptors rather than grpc.WithUnaryInterceptor.
 func NewGrpcClient(addr, server string, opts ...grpc.DialOption) (*GrpcClient, error) {
 	options := []grpc.DialOption{
-		grpc.WithUnaryInterceptor(unaryClientInterceptor(server)),
+		grpc.WithChainUnaryInterceptor(unaryClientInterceptor(server)),
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 	}
 	options = append(options, opts...)
@@ -35,23 +43,72 @@ func NewGrpcClient(addr, server string, opts ...grpc.DialOption) (*GrpcClient, e
 	if err != nil {
 		return nil, err
 	}
+	if !Disabled() {
+		go watchConnState(conn, server)
+	}
 	return &GrpcClient{conn}, nil
 }
 
+// watchConnState logs the state transitions of the connection and sets the grpc_client_connection_state gauge,
+// until the connection is closed.
+func watchConnState(conn *grpc.ClientConn, server string) {
+	state := conn.GetState()
+	goapmVecs().grpcClientConnStateGauge.WithLabelValues(server).Set(float64(state))
+	for state != connectivity.Shutdown {
+		if !conn.WaitForStateChange(context.Background(), state) {
+			return
+		}
+		from := state
+		state = conn.GetState()
+		goapmVecs().grpcClientConnStateGauge.WithLabelValues(server).Set(float64(state))
+		fields := map[string]any{
+			"server": server,
+			"target": conn.Target(),
+			"from":   from.String(),
+			"to":     state.String(),
+		}
+		if state == connectivity.TransientFailure {
+			Logger.Warn(context.Background(), "goapm grpc client connection state changed", fields)
+		} else {
+			Logger.Info(context.Background(), "goapm grpc client connection state changed", fields)
+		}
+	}
+}
+
+// WithUnaryClientInterceptors chains the interceptors after the goapm one, so that they run inside the client span,
+// e.g. the auth token and logging interceptors.
+// grpc.WithUnaryInterceptor should not be used, it runs before the goapm interceptor and only one of them is kept.
+func WithUnaryClientInterceptors(interceptors ...grpc.UnaryClientInterceptor) grpc.DialOption {
+	return grpc.WithChainUnaryInterceptor(interceptors...)
+}
+
+// WithGrpcRoundRobin balances the calls over all the addresses resolved from the target by round robin,
+// instead of pinning to the first one, e.g. the pods of a headless service with the "dns:///service:port" target.
+// It is the default service config, so the one provided by the resolver takes precedence.
+func WithGrpcRoundRobin() grpc.DialOption {
+	return grpc.WithDefaultServiceConfig(grpcRoundRobinServiceConfig)
+}
+
 func unaryClientInterceptor(server string) grpc.UnaryClientInterceptor {
 	tracer := otel.Tracer(grpcClientTracerName)
 
 	return func(ctx context.Context, method string, req, reply interface{},
 		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if Disabled() {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
 		// trace
 		ctx, span := tracer.Start(ctx, method, trace.WithSpanKind(trace.SpanKindClient))
+		statusCode := codes.OK
 		start := time.Now()
 		defer func() {
 			span.SetAttributes(attribute.Int64("grpc.duration_ms", time.Since(start).Milliseconds()))
 			span.End()
 
 			// metric
-			clientHandleHistogram.WithLabelValues(MetricTypeGRPC, method, server).Observe(time.Since(start).Seconds())
+			goapmVecs().clientHandleCounter.WithLabelValues(MetricTypeGRPC, method, server, statusCode.String()).Inc()
+			observeClientHandle(time.Since(start).Seconds(), MetricTypeGRPC, method, server, statusCode.String())
 		}()
 
 		// set peer info into metadata
@@ -64,18 +121,14 @@ func unaryClientInterceptor(server string) grpc.UnaryClientInterceptor {
 		otel.GetTextMapPropagator().Inject(ctx, &metadataSupplier{metadata: &md})
 		ctx = metadata.NewOutgoingContext(ctx, md)
 
-		// metric
-		clientHandleCounter.WithLabelValues(MetricTypeGRPC, method, server).Inc()
-
 		// invoke the actual call
 		err := invoker(ctx, method, req, reply, cc, opts...)
 		if err != nil {
-			span.RecordError(err, trace.WithStackTrace(true), trace.WithTimestamp(time.Now()))
-			span.SetAttributes(attribute.Bool("error", true))
-			s, ok := status.FromError(err)
-			if ok {
-				span.SetAttributes(attribute.String("grpc.status_code", s.Code().String()))
-			}
+			setSpanError(span, err)
+			// non-status errors are converted to codes.Unknown
+			s, _ := status.FromError(err)
+			statusCode = s.Code()
+			span.SetAttributes(attribute.String("grpc.status_code", statusCode.String()))
 		}
 		return err
 	}
@@ -0,0 +1,37 @@
+package apm
+
+import (
+	"net/http"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+)
+
+const (
+	grpcGatewayTracerName = "goapm/grpcGateway"
+)
+
+// NewGRPCGatewayMux creates a grpc-gateway mux, the REST requests are traced by GRPCGatewayMiddleware.
+// Register the handlers with the connection of NewGrpcClient, e.g. `pb.RegisterXXXHandler(ctx, mux, client.ClientConn)`,
+// so that the grpc calls of the gateway are traced as the children of the REST spans.
+func NewGRPCGatewayMux(opts ...runtime.ServeMuxOption) *runtime.ServeMux {
+	opts = append([]runtime.ServeMuxOption{runtime.WithMiddlewares(GRPCGatewayMiddleware())}, opts...)
+	return runtime.NewServeMux(opts...)
+}
+
+// GRPCGatewayMiddleware creates a grpc-gateway middleware which traces the REST requests like TraceHTTP,
+// the spans are named by the path pattern, e.g. "HTTP GET /v1/users/{id=*}".
+// The requests not matching any handler are not traced.
+func GRPCGatewayMiddleware(opts ...HTTPOption) runtime.Middleware {
+	t := newHTTPTracer(grpcGatewayTracerName, opts...)
+	return func(next runtime.HandlerFunc) runtime.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+			route := ""
+			if pattern, ok := runtime.HTTPPattern(r.Context()); ok {
+				route = pattern.String()
+			}
+			t.serve(w, r, route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next(w, r, pathParams)
+			}))
+		}
+	}
+}
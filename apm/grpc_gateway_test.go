@@ -0,0 +1,66 @@
+package apm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	protos "github.com/hedon954/goapm/fixtures"
+)
+
+func TestNewGRPCGatewayMux(t *testing.T) {
+	exporter := setupTracingTest()
+	propagator := otel.GetTextMapPropagator()
+	defer otel.SetTextMapPropagator(propagator)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	server := NewGrpcServer(":")
+	protos.RegisterHelloServiceServer(server, &helloSvc{})
+	server.Start()
+	defer server.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+
+	client, err := NewGrpcClient(server.listener.Addr().String(), "gateway server")
+	assert.Nil(t, err)
+	defer client.Close()
+
+	mux := NewGRPCGatewayMux()
+	err = mux.HandlePath(http.MethodGet, "/v1/hello/{name}", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		res, err := protos.NewHelloServiceClient(client).SayHello(r.Context(), &protos.HelloRequest{Name: params["name"]})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(res.Message))
+	})
+	assert.Nil(t, err)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/hello/world", nil))
+	assert.Equal(t, "Hello, world", w.Body.String())
+
+	spans := exporter.GetSpans()
+	var restSpan, serverSpan, clientSpan trace.SpanContext
+	var serverParent, clientParent trace.SpanID
+	for _, s := range spans {
+		switch {
+		case s.Name == "HTTP GET /v1/hello/{name=*}":
+			restSpan = s.SpanContext
+		case s.SpanKind == trace.SpanKindClient:
+			clientSpan, clientParent = s.SpanContext, s.Parent.SpanID()
+		case s.SpanKind == trace.SpanKindServer:
+			serverSpan, serverParent = s.SpanContext, s.Parent.SpanID()
+		}
+	}
+	assert.True(t, restSpan.IsValid())
+	assert.Equal(t, restSpan.SpanID(), clientParent)
+	assert.Equal(t, clientSpan.SpanID(), serverParent)
+	assert.Equal(t, restSpan.TraceID(), serverSpan.TraceID())
+}
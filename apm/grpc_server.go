@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"runtime/debug"
+	"strings"
 	"time"
 
 	"go.opentelemetry.io/otel"
@@ -12,7 +14,11 @@ import (
 	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
 
 	"github.com/hedon954/goapm/internal"
@@ -26,6 +32,114 @@ const (
 type GrpcServer struct {
 	*grpc.Server
 	listener net.Listener
+	// health is the grpc.health.v1.Health service, it is nil if disabled by WithoutGrpcHealth.
+	health *health.Server
+}
+
+// GrpcMethodFilter reports whether the method should be skipped by the goapm interceptor.
+type GrpcMethodFilter func(fullMethod string) bool
+
+// grpcMethodFilterOption is a grpc.ServerOption carrying the GrpcMethodFilter,
+// it would be picked up by NewGrpcServer2 and is a no-op for grpc itself.
+type grpcMethodFilterOption struct {
+	grpc.EmptyServerOption
+	filter GrpcMethodFilter
+}
+
+// WithGrpcMethodFilter skips the traces and metrics of the methods for which filter returns true,
+// e.g. the health check and reflection methods. The handler is still called for them.
+// Default is to skip the grpc.health.v1.Health methods only, like the "/heartbeat" of the http servers,
+// the default is replaced by the given filter.
+func WithGrpcMethodFilter(filter GrpcMethodFilter) grpc.ServerOption {
+	return grpcMethodFilterOption{filter: filter}
+}
+
+// defaultGrpcMethodFilter skips the health checks, which are called frequently by the probes of kubelet and the LBs.
+func defaultGrpcMethodFilter(fullMethod string) bool {
+	return strings.HasPrefix(fullMethod, "/grpc.health.v1.Health/")
+}
+
+// grpcHealthOption is a grpc.ServerOption disabling the default health service,
+// it would be picked up by NewGrpcServer2 and is a no-op for grpc itself.
+type grpcHealthOption struct {
+	grpc.EmptyServerOption
+}
+
+// WithoutGrpcHealth disables the grpc.health.v1.Health service registered by default,
+// e.g. the user registers their own one.
+func WithoutGrpcHealth() grpc.ServerOption {
+	return grpcHealthOption{}
+}
+
+// grpcReflectionOption is a grpc.ServerOption enabling the server reflection,
+// it would be picked up by NewGrpcServer2 and is a no-op for grpc itself.
+type grpcReflectionOption struct {
+	grpc.EmptyServerOption
+}
+
+// WithGrpcReflection registers the grpc server reflection service, e.g. for debugging with grpcurl.
+// Default is off, since it exposes all the services and messages to the clients.
+func WithGrpcReflection() grpc.ServerOption {
+	return grpcReflectionOption{}
+}
+
+// grpcCaptureMetadataOption is a grpc.ServerOption carrying the metadata keys to capture,
+// it would be picked up by NewGrpcServer2 and is a no-op for grpc itself.
+type grpcCaptureMetadataOption struct {
+	grpc.EmptyServerOption
+	keys []string
+}
+
+// sensitiveMetadata are never captured by WithGrpcCaptureMetadata.
+var sensitiveMetadata = map[string]bool{metadataKeyAuthorization: true, "proxy-authorization": true, "cookie": true}
+
+// WithGrpcCaptureMetadata records the incoming metadata in the allowlist as the "grpc.metadata.<key>" attributes
+// of the server spans, the key is lowercased, e.g. "grpc.metadata.x-tenant-id". Authorization is never captured.
+func WithGrpcCaptureMetadata(keys ...string) grpc.ServerOption {
+	o := grpcCaptureMetadataOption{}
+	for _, key := range keys {
+		key = strings.ToLower(key)
+		if !sensitiveMetadata[key] {
+			o.keys = append(o.keys, key)
+		}
+	}
+	return o
+}
+
+// grpcAccessLogOption is a grpc.ServerOption enabling the access log,
+// it would be picked up by NewGrpcServer2 and is a no-op for grpc itself.
+type grpcAccessLogOption struct {
+	grpc.EmptyServerOption
+	enable bool
+}
+
+// WithGrpcAccessLog logs a "grpc_access" line for each call handled by the goapm interceptor,
+// with the method, status code, duration, peer info and trace id. Default is disabled.
+func WithGrpcAccessLog(enable bool) grpc.ServerOption {
+	return grpcAccessLogOption{enable: enable}
+}
+
+// grpcDisabledOption is a grpc.ServerOption turning off the goapm interceptor,
+// it would be picked up by NewGrpcServer2 and is a no-op for grpc itself.
+type grpcDisabledOption struct {
+	grpc.EmptyServerOption
+	disabled bool
+}
+
+// WithGrpcDisabled turns off the tracing, metrics and logging like Disable, but only for this server,
+// the panics are still recovered.
+func WithGrpcDisabled(disabled bool) grpc.ServerOption {
+	return grpcDisabledOption{disabled: disabled}
+}
+
+// grpcServerOptions are the goapm options picked up from the grpc.ServerOption by NewGrpcServer2.
+type grpcServerOptions struct {
+	filter           GrpcMethodFilter
+	captureMetadata  []string
+	accessLog        bool
+	enableHealth     bool
+	enableReflection bool
+	disabled         bool
 }
 
 // NewGrpcServer creates a new grpc server with the given address.
@@ -38,17 +152,52 @@ func NewGrpcServer(addr string, opts ...grpc.ServerOption) *GrpcServer {
 }
 
 // NewGrpcServer2 creates a new grpc server with the given listener.
+// The grpc.health.v1.Health service is registered with SERVING for all the services, unless WithoutGrpcHealth is given.
 func NewGrpcServer2(listener net.Listener, opts ...grpc.ServerOption) *GrpcServer {
+	o := &grpcServerOptions{enableHealth: true, filter: defaultGrpcMethodFilter}
+	for _, opt := range opts {
+		switch opt := opt.(type) {
+		case grpcMethodFilterOption:
+			o.filter = opt.filter
+		case grpcCaptureMetadataOption:
+			o.captureMetadata = append(o.captureMetadata, opt.keys...)
+		case grpcAccessLogOption:
+			o.accessLog = opt.enable
+		case grpcHealthOption:
+			o.enableHealth = false
+		case grpcReflectionOption:
+			o.enableReflection = true
+		case grpcDisabledOption:
+			o.disabled = opt.disabled
+		}
+	}
+
 	options := []grpc.ServerOption{
-		grpc.UnaryInterceptor(unaryServerInterceptor()),
+		grpc.UnaryInterceptor(unaryServerInterceptor(o)),
 	}
 	options = append(options, opts...)
 
 	server := grpc.NewServer(options...)
-	return &GrpcServer{
+	s := &GrpcServer{
 		listener: listener,
 		Server:   server,
 	}
+	if o.enableHealth {
+		s.health = health.NewServer()
+		healthpb.RegisterHealthServer(server, s.health)
+	}
+	if o.enableReflection {
+		reflection.Register(server)
+	}
+	return s
+}
+
+// SetServingStatus sets the serving status of the service reported by the health service,
+// the empty service is the status of the whole server. It is a no-op if the health service is disabled.
+func (s *GrpcServer) SetServingStatus(service string, status healthpb.HealthCheckResponse_ServingStatus) {
+	if s.health != nil {
+		s.health.SetServingStatus(service, status)
+	}
 }
 
 func (s *GrpcServer) Start() {
@@ -64,14 +213,23 @@ func (s *GrpcServer) Start() {
 	}()
 }
 
+// Stop sets all the services NOT_SERVING, and stops the server gracefully.
 func (s *GrpcServer) Stop() {
+	if s.health != nil {
+		s.health.Shutdown()
+	}
 	s.Server.GracefulStop()
 }
 
-func unaryServerInterceptor() grpc.UnaryServerInterceptor {
+func unaryServerInterceptor(o *grpcServerOptions) grpc.UnaryServerInterceptor {
 	tracer := otel.Tracer(grpcServerTracerName)
 
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if Disabled() || o.disabled || (o.filter != nil && o.filter(info.FullMethod)) {
+			// the panics are still recovered, since grpc-go does not recover them and the process would crash
+			return callGrpcHandler(ctx, req, info, handler, false)
+		}
+
 		// get the metadata from the incoming context or create a new one
 		md, ok := metadata.FromIncomingContext(ctx)
 		if !ok {
@@ -84,6 +242,15 @@ func unaryServerInterceptor() grpc.UnaryServerInterceptor {
 
 		// trace: start the span
 		ctx, span := tracer.Start(ctx, info.FullMethod, trace.WithSpanKind(trace.SpanKindServer))
+		for _, key := range o.captureMetadata {
+			if values := md.Get(key); len(values) > 0 {
+				span.SetAttributes(attribute.StringSlice("grpc.metadata."+key, values))
+			}
+		}
+		// the transport peer identifies the callers not setting the peer info in the metadata
+		if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+			span.SetAttributes(attribute.String("grpc.peer.address", p.Addr.String()))
+		}
 
 		statusCode := codes.OK
 		start := time.Now()
@@ -92,16 +259,27 @@ func unaryServerInterceptor() grpc.UnaryServerInterceptor {
 			span.End()
 
 			// metric
-			serverHandleHistogram.WithLabelValues(
-				MetricTypeGRPC, info.FullMethod, statusCode.String(), peerApp, peerHost,
-			).Observe(time.Since(start).Seconds())
+			elapsed := time.Since(start)
+			observeServerHandle(elapsed.Seconds(),
+				MetricTypeGRPC, info.FullMethod, statusCode.String(), peerApp, peerHost)
+
+			if o.accessLog {
+				Logger.Info(ctx, "grpc_access", map[string]any{
+					"method":      info.FullMethod,
+					"status_code": statusCode.String(),
+					"duration_ms": elapsed.Milliseconds(),
+					"peer_app":    peerApp,
+					"peer_host":   peerHost,
+					traceID:       span.SpanContext().TraceID().String(),
+				})
+			}
 		}()
 
 		// metric
-		serverHandleCounter.WithLabelValues(MetricTypeGRPC, info.FullMethod, peerApp, peerHost).Inc()
+		goapmVecs().serverHandleCounter.WithLabelValues(MetricTypeGRPC, info.FullMethod, peerApp, peerHost).Inc()
 
 		// call the handler
-		resp, err := handler(ctx, req)
+		resp, err := callGrpcHandler(ctx, req, info, handler, true)
 
 		// set the status and error on the span
 		if err != nil {
@@ -109,11 +287,30 @@ func unaryServerInterceptor() grpc.UnaryServerInterceptor {
 			if ok {
 				statusCode = s.Code()
 			}
-			span.RecordError(err, trace.WithStackTrace(true), trace.WithTimestamp(time.Now()))
-			span.SetAttributes(attribute.Bool("error", true))
+			setSpanError(span, err)
 			span.SetAttributes(attribute.String("grpc.status_code", s.Code().String()))
 		}
 
 		return resp, err
 	}
 }
+
+// callGrpcHandler calls the handler and converts the panic into a codes.Internal error,
+// the span and the panic_recovered_total metric are only updated if the method is instrumented.
+func callGrpcHandler(ctx context.Context, req any, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler, instrumented bool) (resp any, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			Logger.Error(ctx, "panic in grpc handler", fmt.Errorf("panic: %v", p), map[string]any{
+				"method": info.FullMethod,
+				"stack":  string(debug.Stack()),
+			})
+			if instrumented {
+				trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("grpc.panic", true))
+				goapmVecs().panicRecoveredCounter.WithLabelValues(MetricTypeGRPC, info.FullMethod).Inc()
+			}
+			err = status.Errorf(codes.Internal, "panic: %v", p)
+		}
+	}()
+	return handler(ctx, req)
+}
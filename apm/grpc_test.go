@@ -1,11 +1,31 @@
 package apm
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/status"
 
 	protos "github.com/hedon954/goapm/fixtures"
 )
@@ -32,4 +52,386 @@ func TestGrpcServerAndClient_ShouldWork(t *testing.T) {
 		&protos.HelloRequest{Name: "World"})
 	assert.Nil(t, err)
 	assert.Equal(t, "Hello, World", res.Message)
+
+	method := "/HelloService/SayHello"
+	assert.Equal(t, float64(1), testutil.ToFloat64(
+		goapmVecs().clientHandleCounter.WithLabelValues(MetricTypeGRPC, method, "test server", codes.OK.String())))
+}
+
+func TestGrpcClient_WithNoDeadlineCheck(t *testing.T) {
+	server := NewGrpcServer(":")
+	protos.RegisterHelloServiceServer(server, &helloSvc{})
+	server.Start()
+	defer server.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+
+	addr := server.listener.Addr().String()
+	client, err := NewGrpcClient(addr, "no deadline server", WithNoDeadlineCheck())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	method := "/HelloService/SayHello"
+	counter := goapmVecs().clientNoDeadlineCounter.WithLabelValues(MetricTypeGRPC, method, addr)
+	_, err = protos.NewHelloServiceClient(client).SayHello(context.Background(), &protos.HelloRequest{Name: "World"})
+	assert.Nil(t, err)
+	assert.Equal(t, float64(1), testutil.ToFloat64(counter))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = protos.NewHelloServiceClient(client).SayHello(ctx, &protos.HelloRequest{Name: "World"})
+	assert.Nil(t, err)
+	assert.Equal(t, float64(1), testutil.ToFloat64(counter))
+}
+
+func TestGrpcServer_WithGrpcMethodFilter(t *testing.T) {
+	exporter := setupTracingTest()
+
+	server := NewGrpcServer(":", WithGrpcMethodFilter(func(fullMethod string) bool {
+		return fullMethod == "/HelloService/SayHello"
+	}))
+	protos.RegisterHelloServiceServer(server, &helloSvc{})
+	server.Start()
+	defer server.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := grpc.NewClient(server.listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+	defer conn.Close()
+	res, err := protos.NewHelloServiceClient(conn).SayHello(context.Background(), &protos.HelloRequest{Name: "World"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello, World", res.Message)
+	assert.Empty(t, exporter.GetSpans())
+}
+
+type panicHelloSvc struct {
+	protos.UnimplementedHelloServiceServer
+}
+
+func (s *panicHelloSvc) SayHello(ctx context.Context, in *protos.HelloRequest) (*protos.HelloResponse, error) {
+	panic("boom")
+}
+
+func TestGrpcServer_Panic(t *testing.T) {
+	server := NewGrpcServer(":")
+	protos.RegisterHelloServiceServer(server, &panicHelloSvc{})
+	server.Start()
+	defer server.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+
+	client, err := NewGrpcClient(server.listener.Addr().String(), "panic server")
+	assert.Nil(t, err)
+	method := "/HelloService/SayHello"
+	before := testutil.ToFloat64(goapmVecs().panicRecoveredCounter.WithLabelValues(MetricTypeGRPC, method))
+	_, err = protos.NewHelloServiceClient(client).SayHello(context.Background(), &protos.HelloRequest{Name: "World"})
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Equal(t, before+1, testutil.ToFloat64(goapmVecs().panicRecoveredCounter.WithLabelValues(MetricTypeGRPC, method)))
+}
+
+func TestGrpcServer_PanicInFilteredMethod(t *testing.T) {
+	server := NewGrpcServer(":", WithGrpcMethodFilter(func(fullMethod string) bool {
+		return true
+	}))
+	protos.RegisterHelloServiceServer(server, &panicHelloSvc{})
+	server.Start()
+	defer server.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+
+	client, err := NewGrpcClient(server.listener.Addr().String(), "panic server")
+	assert.Nil(t, err)
+	defer client.Close()
+	method := "/HelloService/SayHello"
+	before := testutil.ToFloat64(goapmVecs().panicRecoveredCounter.WithLabelValues(MetricTypeGRPC, method))
+	_, err = protos.NewHelloServiceClient(client).SayHello(context.Background(), &protos.HelloRequest{Name: "World"})
+	assert.Equal(t, codes.Internal, status.Code(err))
+	// the panic is recovered without being counted, since the method is not instrumented
+	assert.Equal(t, before, testutil.ToFloat64(goapmVecs().panicRecoveredCounter.WithLabelValues(MetricTypeGRPC, method)))
+}
+
+func TestGrpcServer_Health(t *testing.T) {
+	exporter := setupTracingTest()
+	server := NewGrpcServer(":")
+	protos.RegisterHelloServiceServer(server, &helloSvc{})
+	server.Start()
+	defer server.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+
+	client, err := NewGrpcClient(server.listener.Addr().String(), "health server")
+	assert.Nil(t, err)
+	defer client.Close()
+
+	healthClient := healthpb.NewHealthClient(client)
+	res, err := healthClient.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, res.Status)
+
+	// the health checks are not instrumented by the server by default
+	for _, span := range exporter.GetSpans() {
+		assert.NotEqual(t, trace.SpanKindServer, span.SpanKind)
+	}
+
+	server.SetServingStatus("HelloService", healthpb.HealthCheckResponse_NOT_SERVING)
+	res, err = healthClient.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "HelloService"})
+	assert.Nil(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, res.Status)
+}
+
+func TestGrpcServer_WithoutGrpcHealth(t *testing.T) {
+	server := NewGrpcServer(":", WithoutGrpcHealth())
+	server.Start()
+	defer server.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+
+	client, err := NewGrpcClient(server.listener.Addr().String(), "no health server")
+	assert.Nil(t, err)
+	defer client.Close()
+
+	server.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	_, err = healthpb.NewHealthClient(client).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+func TestGrpcServer_WithGrpcReflection(t *testing.T) {
+	for _, enable := range []bool{false, true} {
+		var opts []grpc.ServerOption
+		if enable {
+			opts = append(opts, WithGrpcReflection())
+		}
+		server := NewGrpcServer(":", opts...)
+		protos.RegisterHelloServiceServer(server, &helloSvc{})
+		server.Start()
+
+		time.Sleep(100 * time.Millisecond)
+
+		client, err := NewGrpcClient(server.listener.Addr().String(), "reflection server")
+		assert.Nil(t, err)
+		stream, err := reflectionpb.NewServerReflectionClient(client).ServerReflectionInfo(context.Background())
+		assert.Nil(t, err)
+		err = stream.Send(&reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+		})
+		assert.Nil(t, err)
+		res, err := stream.Recv()
+		if enable {
+			assert.Nil(t, err)
+			var services []string
+			for _, s := range res.GetListServicesResponse().GetService() {
+				services = append(services, s.GetName())
+			}
+			assert.Contains(t, services, "HelloService")
+		} else {
+			assert.Equal(t, codes.Unimplemented, status.Code(err))
+		}
+		_ = client.Close()
+		server.Stop()
+	}
+}
+
+func TestGrpcServer_WithGrpcCaptureMetadata(t *testing.T) {
+	exporter := setupTracingTest()
+
+	server := NewGrpcServer(":", WithGrpcCaptureMetadata("X-Tenant-Id", "Authorization", "x-missing"))
+	protos.RegisterHelloServiceServer(server, &helloSvc{})
+	server.Start()
+	defer server.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := grpc.NewClient(server.listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+	defer conn.Close()
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-tenant-id", "t1", "authorization", "Bearer secret")
+	_, err = protos.NewHelloServiceClient(conn).SayHello(ctx, &protos.HelloRequest{Name: "World"})
+	assert.Nil(t, err)
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, attr := range spans[0].Attributes {
+		attrs[attr.Key] = attr.Value
+	}
+	assert.Equal(t, []string{"t1"}, attrs["grpc.metadata.x-tenant-id"].AsStringSlice())
+	assert.NotContains(t, attrs, attribute.Key("grpc.metadata.authorization"))
+	assert.NotContains(t, attrs, attribute.Key("grpc.metadata.x-missing"))
+	host, _, err := net.SplitHostPort(attrs["grpc.peer.address"].AsString())
+	assert.Nil(t, err)
+	assert.True(t, net.ParseIP(host).IsLoopback())
+}
+
+type countingHelloSvc struct {
+	protos.UnimplementedHelloServiceServer
+	calls atomic.Int32
+}
+
+func (s *countingHelloSvc) SayHello(ctx context.Context, in *protos.HelloRequest) (*protos.HelloResponse, error) {
+	s.calls.Add(1)
+	return &protos.HelloResponse{Message: "Hello, " + in.Name}, nil
+}
+
+func TestGrpcClient_WithGrpcRoundRobin(t *testing.T) {
+	svcs := []*countingHelloSvc{{}, {}}
+	r := manual.NewBuilderWithScheme("goapm")
+	var addrs []resolver.Address
+	for _, svc := range svcs {
+		server := NewGrpcServer(":")
+		protos.RegisterHelloServiceServer(server, svc)
+		server.Start()
+		defer server.Stop()
+		addrs = append(addrs, resolver.Address{Addr: server.listener.Addr().String()})
+	}
+	r.InitialState(resolver.State{Addresses: addrs})
+
+	time.Sleep(100 * time.Millisecond)
+
+	client, err := NewGrpcClient("goapm:///hello", "round robin server", grpc.WithResolvers(r), WithGrpcRoundRobin())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	// the calls are spread once both the backends are connected
+	assert.Eventually(t, func() bool {
+		_, err := protos.NewHelloServiceClient(client).SayHello(context.Background(), &protos.HelloRequest{Name: "World"})
+		return err == nil && svcs[0].calls.Load() > 0 && svcs[1].calls.Load() > 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestGrpcClient_ConnStateGauge(t *testing.T) {
+	server := NewGrpcServer(":")
+	protos.RegisterHelloServiceServer(server, &helloSvc{})
+	server.Start()
+	defer server.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+
+	client, err := NewGrpcClient(server.listener.Addr().String(), "conn state server")
+	assert.Nil(t, err)
+	_, err = protos.NewHelloServiceClient(client).SayHello(context.Background(), &protos.HelloRequest{Name: "World"})
+	assert.Nil(t, err)
+
+	gauge := goapmVecs().grpcClientConnStateGauge.WithLabelValues("conn state server")
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(gauge) == float64(connectivity.Ready)
+	}, time.Second, 10*time.Millisecond)
+
+	_ = client.Close()
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(gauge) == float64(connectivity.Shutdown)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestGrpcClient_WithUnaryClientInterceptors(t *testing.T) {
+	exporter := setupTracingTest()
+	server := NewGrpcServer(":")
+	protos.RegisterHelloServiceServer(server, &helloSvc{})
+	server.Start()
+	defer server.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+
+	var calls []string
+	interceptor := func(name string) grpc.UnaryClientInterceptor {
+		return func(ctx context.Context, method string, req, reply any,
+			cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			calls = append(calls, name)
+			if name == "custom" {
+				// the custom interceptors run inside the client span
+				assert.True(t, trace.SpanFromContext(ctx).SpanContext().IsValid())
+			}
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+	}
+	client, err := NewGrpcClient(server.listener.Addr().String(), "interceptors server",
+		WithUnaryClientInterceptors(interceptor("custom")), grpc.WithUnaryInterceptor(interceptor("raw")))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	_, err = protos.NewHelloServiceClient(client).SayHello(context.Background(), &protos.HelloRequest{Name: "World"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"raw", "custom"}, calls)
+
+	var clientSpans int
+	for _, s := range exporter.GetSpans() {
+		if s.SpanKind == trace.SpanKindClient {
+			clientSpans++
+		}
+	}
+	assert.Equal(t, 1, clientSpans)
+}
+
+func TestGrpcClient_WithGrpcAuthToken(t *testing.T) {
+	exporter := setupTracingTest()
+	var authorization []string
+	server := NewGrpcServer(":", grpc.ChainUnaryInterceptor(func(ctx context.Context, req any,
+		info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		authorization = md.Get("authorization")
+		return handler(ctx, req)
+	}))
+	protos.RegisterHelloServiceServer(server, &helloSvc{})
+	server.Start()
+	defer server.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+
+	tokens := []string{"t1", "t2"}
+	var tokenErr error
+	client, err := NewGrpcClient(server.listener.Addr().String(), "auth server",
+		WithGrpcAuthToken(func(ctx context.Context) (string, error) {
+			if tokenErr != nil {
+				return "", tokenErr
+			}
+			token := tokens[0]
+			tokens = tokens[1:]
+			return token, nil
+		}))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	helloClient := protos.NewHelloServiceClient(client)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer stale")
+	for _, want := range []string{"Bearer t1", "Bearer t2"} {
+		_, err = helloClient.SayHello(ctx, &protos.HelloRequest{Name: "World"})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{want}, authorization)
+	}
+
+	authorization = nil
+	tokenErr = errors.New("token expired")
+	_, err = helloClient.SayHello(context.Background(), &protos.HelloRequest{Name: "World"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Nil(t, authorization)
+
+	for _, s := range exporter.GetSpans() {
+		for _, attr := range s.Attributes {
+			assert.NotContains(t, attr.Value.Emit(), "Bearer")
+		}
+	}
+}
+
+func TestGrpcServer_WithGrpcAccessLog(t *testing.T) {
+	setupTracingTest()
+	buf := &bytes.Buffer{}
+	logrus.SetOutput(buf)
+	defer logrus.SetOutput(os.Stderr)
+
+	interceptor := unaryServerInterceptor(&grpcServerOptions{accessLog: true})
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(metadataKeyPeerApp, "caller"))
+	_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/HelloService/SayHello"},
+		func(ctx context.Context, req any) (any, error) {
+			return nil, status.Error(codes.NotFound, "not found")
+		})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	var entry map[string]any
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "grpc_access", entry["msg"])
+	assert.Equal(t, "/HelloService/SayHello", entry["method"])
+	assert.Equal(t, codes.NotFound.String(), entry["status_code"])
+	assert.Equal(t, "caller", entry["peer_app"])
+	assert.Contains(t, entry, "duration_ms")
+	assert.NotEmpty(t, entry[traceID])
 }
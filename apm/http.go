@@ -1,19 +1,18 @@
 package apm
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"net"
 	"net/http"
-	"runtime/debug"
-	"strconv"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 const (
@@ -21,43 +20,93 @@ const (
 
 	HeaderBusinessErrorCode = "X-Business-Error-Code"
 	HeaderBusinessErrorMsg  = "X-Business-Error-Msg"
+	// HeaderBusinessErrorMsgEncoding marks the encoding of X-Business-Error-Msg, it is "url" if the message is query escaped.
+	HeaderBusinessErrorMsgEncoding = "X-Business-Error-Msg-Encoding"
 )
 
 // HTTPServer is a wrapper around http.Server that adds tracing to the server.
 type HTTPServer struct {
 	mux *http.ServeMux
 	*http.Server
-	tracer   trace.Tracer
-	listener net.Listener
+	tracer      *httpTracer
+	listener    net.Listener
+	closed      sync.Once
+	middlewares []func(http.Handler) http.Handler
+}
+
+// WithHTTPTimeouts sets the ReadTimeout, WriteTimeout and IdleTimeout of the HTTPServer, zero means no timeout.
+// The ReadHeaderTimeout is always 30s. It is only used by HTTPServer, and ignored by the middlewares.
+func WithHTTPTimeouts(read, write, idle time.Duration) HTTPOption {
+	return func(o *httpOptions) {
+		o.readTimeout = read
+		o.writeTimeout = write
+		o.idleTimeout = idle
+	}
+}
+
+// WithH2C makes the HTTPServer serve the cleartext HTTP/2 (h2c) requests besides HTTP/1 on the same listener,
+// e.g. for the gRPC-Web and h2c clients. It is only used by HTTPServer, and ignored by the middlewares.
+func WithH2C() HTTPOption {
+	return func(o *httpOptions) {
+		o.h2c = true
+	}
+}
+
+// WithTracerProvider starts the spans by tp instead of the global tracer provider,
+// e.g. the one created by NewTracerProvider for an isolated infra.
+func WithTracerProvider(tp trace.TracerProvider) HTTPOption {
+	return func(o *httpOptions) {
+		o.tracerProvider = tp
+	}
+}
+
+// WithMetricRegistry serves the metrics of reg on the /metrics of the HTTPServer instead of MetricsReg.
+// If reg is created by NewMetricRegistry, the http handle metrics of the HTTPServer and the middlewares
+// are recorded to the goapm metrics of reg as well.
+func WithMetricRegistry(reg MetricRegistry) HTTPOption {
+	return func(o *httpOptions) {
+		o.metricsReg = reg
+	}
 }
 
 // NewHTTPServer creates a new HTTPServer,
 // it is a wrapper around http.Server that adds tracing and metrics to the server.
-func NewHTTPServer(addr string) *HTTPServer {
+func NewHTTPServer(addr string, opts ...HTTPOption) *HTTPServer {
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		panic(fmt.Errorf("failed to listen goapm http server: %w", err))
 	}
 
-	return NewHTTPServer2(listener)
+	return NewHTTPServer2(listener, opts...)
 }
 
 // NewHTTPServer2 creates a new HTTPServer with a given listener,
 // it is a wrapper around http.Server that adds tracing and metrics to the server.
-func NewHTTPServer2(listener net.Listener) *HTTPServer {
+func NewHTTPServer2(listener net.Listener, opts ...HTTPOption) *HTTPServer {
 	mux := http.NewServeMux()
+	tracer := newHTTPTracer(httpTracerName, opts...)
 	srv := &HTTPServer{
-		tracer: otel.Tracer(httpTracerName),
+		tracer: tracer,
 		mux:    mux,
 		Server: &http.Server{
 			Handler:           mux,
 			ReadHeaderTimeout: 30 * time.Second, //nolint:mnd
+			ReadTimeout:       tracer.o.readTimeout,
+			WriteTimeout:      tracer.o.writeTimeout,
+			IdleTimeout:       tracer.o.idleTimeout,
 		},
 		listener: listener,
 	}
+	if tracer.o.h2c {
+		srv.Server.Handler = h2c.NewHandler(mux, &http2.Server{IdleTimeout: tracer.o.idleTimeout})
+	}
 
-	srv.Handle("/metrics", promhttp.HandlerFor(MetricsReg, promhttp.HandlerOpts{
-		Registry: MetricsReg,
+	var reg MetricRegistry = MetricsReg
+	if tracer.o.metricsReg != nil {
+		reg = tracer.o.metricsReg
+	}
+	srv.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{
+		Registry: reg,
 	}))
 	srv.Handle("/heartbeat", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte("ok"))
@@ -77,115 +126,104 @@ func (s *HTTPServer) Start() {
 	}()
 }
 
-// Close shutdowns the http server.
+// Close shutdowns the http server, it is safe to call it multiple times.
 func (s *HTTPServer) Close() {
-	if s.Server != nil {
-		if err := s.Server.Shutdown(context.Background()); err != nil {
-			Logger.Error(context.Background(), "failed to shutdown http server", err, nil)
+	s.closed.Do(func() {
+		if s.Server != nil {
+			if err := s.Server.Shutdown(context.Background()); err != nil {
+				Logger.Error(context.Background(), "failed to shutdown http server", err, nil)
+			}
 		}
-	}
+	})
+}
+
+// Use adds the middlewares to all the routes of the server, including the ones registered before,
+// they are executed in the order of being added and inside the trace span.
+// It should be called before the server starts.
+func (s *HTTPServer) Use(middlewares ...func(http.Handler) http.Handler) {
+	s.middlewares = append(s.middlewares, middlewares...)
 }
 
 // Handle registers a new handler for the given pattern.
 func (s *HTTPServer) Handle(pattern string, handler http.Handler) {
 	s.mux.Handle(pattern, &traceHandler{
 		handler: handler,
-		tracer:  s.tracer,
+		server:  s,
 	})
 }
 
 // HandleFunc registers a new handler function for the given pattern.
 func (s *HTTPServer) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
-	s.mux.Handle(pattern, &traceHandler{
-		handler: http.HandlerFunc(handler),
-		tracer:  s.tracer,
-	})
+	s.Handle(pattern, http.HandlerFunc(handler))
 }
 
-// traceHandler is a wrapper around http.Handler that adds tracing to the handler.
+// traceHandler is a wrapper around http.Handler that adds tracing and the middlewares of the server to the handler.
 type traceHandler struct {
 	handler http.Handler
-	tracer  trace.Tracer
+	server  *HTTPServer
+
+	// chain is the handler wrapped by the middlewares, it is built on the first request.
+	chain     http.Handler
+	chainOnce sync.Once
 }
 
 func (th *traceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if th.handler == nil {
-		th.handler.ServeHTTP(w, r)
-		return
-	}
-
-	// metrics
-	serverHandleCounter.WithLabelValues(MetricTypeHTTP, r.Method+"."+r.URL.Path, "", "").Inc()
-
-	// trace
-	ctx := r.Context()
-	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(r.Header))
-	ctx, span := th.tracer.Start(ctx, "HTTP "+r.Method+" "+r.URL.Path)
-	defer span.End()
-	r = r.Clone(ctx)
-	respWrapper := &responseWrapper{ResponseWriter: w}
-
-	start := time.Now()
-	func() {
-		// panic recover
-		defer func() {
-			if err := recover(); err != nil {
-				span.SetAttributes(attribute.Bool("error", true))
-				span.RecordError(
-					fmt.Errorf("%v", err),
-					trace.WithStackTrace(true),
-					trace.WithTimestamp(time.Now()),
-				)
-
-				// log
-				Logger.Error(ctx, "panic in http handler", fmt.Errorf("panic: %v", err), map[string]any{
-					"method": r.Method,
-					"path":   r.URL.Path,
-					"params": r.Form.Encode(),
-					"stack":  string(debug.Stack()),
-				})
-				http.Error(respWrapper, "Internal Server Error", http.StatusInternalServerError)
-			}
-		}()
-
-		// handle request
-		th.handler.ServeHTTP(respWrapper, r)
-	}()
-
-	// http response status code
-	if respWrapper.status == 0 {
-		respWrapper.status = http.StatusOK
-	}
-	elapsed := time.Since(start)
-	span.SetAttributes(
-		attribute.Int("http.response.code", respWrapper.status),
-		attribute.Int64("http.duration_ms", elapsed.Milliseconds()),
-	)
-
-	// business error code
-	// TODO: check if needs
-	businessErrorCode := respWrapper.Header().Get(HeaderBusinessErrorCode)
-	businessErrorMsg := respWrapper.Header().Get(HeaderBusinessErrorMsg)
-	if businessErrorCode != "" {
-		span.SetAttributes(
-			attribute.String("http.response.business_error_code", businessErrorCode),
-			attribute.String("http.response.business_error_msg", businessErrorMsg),
-		)
-	}
-
-	// metrics
-	serverHandleHistogram.WithLabelValues(
-		MetricTypeHTTP, r.Method+"."+r.URL.Path, strconv.Itoa(respWrapper.status), "", "",
-	).Observe(elapsed.Seconds())
+	th.chainOnce.Do(func() {
+		th.chain = th.handler
+		for i := len(th.server.middlewares) - 1; i >= 0; i-- {
+			th.chain = th.server.middlewares[i](th.chain)
+		}
+	})
+	th.server.tracer.serve(w, r, "", th.chain)
 }
 
-// responseWrapper is a wrapper around http.ResponseWriter that store the status code.
+// responseWrapper is a wrapper around http.ResponseWriter that store the status code and the size of the body.
 type responseWrapper struct {
 	http.ResponseWriter
 	status int
+	size   int
 }
 
 func (r *responseWrapper) WriteHeader(statusCode int) {
 	r.status = statusCode
 	r.ResponseWriter.WriteHeader(statusCode)
 }
+
+// Status returns the status code, it is 200 if WriteHeader has not been called.
+func (r *responseWrapper) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+func (r *responseWrapper) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.size += n
+	return n, err
+}
+
+// Size returns the number of bytes written to the body.
+func (r *responseWrapper) Size() int {
+	return r.size
+}
+
+// Flush implements http.Flusher for the streaming handlers, e.g. SSE.
+func (r *responseWrapper) Flush() {
+	_ = http.NewResponseController(r.ResponseWriter).Flush()
+}
+
+// Hijack implements http.Hijacker for the handlers taking over the connection, e.g. websocket.
+// The status is recorded as 101 if nothing has been written.
+func (r *responseWrapper) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err == nil && r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the wrapped http.ResponseWriter for http.ResponseController.
+func (r *responseWrapper) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
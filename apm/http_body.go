@@ -0,0 +1,79 @@
+package apm
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithRequestBodyCapture records the JSON request body as the "http.request.body" attribute,
+// at most maxBytes of the body is recorded. The gzip and deflate encoded bodies are decompressed
+// for the attribute, and the body stream read by the handler is left intact.
+func WithRequestBodyCapture(maxBytes int) HTTPOption {
+	return func(o *httpOptions) {
+		o.maxRequestBodyBytes = maxBytes
+	}
+}
+
+// captureRequestBody reads at most maxRequestBodyBytes from the body and sets it on the span,
+// the read bytes are put back in front of the rest of the body.
+func (o *httpOptions) captureRequestBody(span trace.Span, r *http.Request) {
+	if o.maxRequestBodyBytes <= 0 || r.Body == nil || r.Body == http.NoBody ||
+		!strings.Contains(r.Header.Get("Content-Type"), "json") {
+		return
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(r.Body, int64(o.maxRequestBodyBytes)))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(raw), r.Body), r.Body}
+	if err != nil {
+		return
+	}
+
+	body, err := decodeBody(raw, r.Header.Get("Content-Encoding"), o.maxRequestBodyBytes)
+	if err != nil {
+		span.SetAttributes(attribute.String("http.request.body_error", err.Error()))
+		return
+	}
+	span.SetAttributes(attribute.String("http.request.body", string(o.redactJSON(body))))
+}
+
+// decodeBody decompresses at most maxBytes from the raw body according to the content encoding,
+// the raw body may be truncated, so the data decompressed before the unexpected EOF is returned.
+func decodeBody(raw []byte, encoding string, maxBytes int) ([]byte, error) {
+	var reader io.Reader
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip":
+		gr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = gr.Close() }()
+		reader = gr
+	case "deflate":
+		// deflate should be zlib wrapped by the spec, but some clients send the raw deflate data
+		zr, err := zlib.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			zr = flate.NewReader(bytes.NewReader(raw))
+		}
+		defer func() { _ = zr.Close() }()
+		reader = zr
+	default:
+		return raw, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(reader, int64(maxBytes)))
+	if err != nil && len(body) == 0 {
+		return nil, err
+	}
+	return body, nil
+}
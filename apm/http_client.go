@@ -0,0 +1,254 @@
+package apm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	httpClientTracerName = "goapm/httpClient"
+
+	// maxRetryAfter is the max Retry-After waited by WithHTTPRetry, the response is returned if the server asks for longer.
+	maxRetryAfter = 30 * time.Second
+)
+
+// httpClientOptions is the options of the http client.
+type httpClientOptions struct {
+	transport   http.RoundTripper
+	timeout     time.Duration
+	maxAttempts int
+	baseBackoff time.Duration
+	noDeadline  bool
+}
+
+// HTTPClientOption is the option for NewHTTPClient.
+type HTTPClientOption func(o *httpClientOptions)
+
+// WithHTTPTransport sets the underlying transport of the http client, default is http.DefaultTransport,
+// e.g. CircuitBreaker.RoundTripper(nil).
+func WithHTTPTransport(rt http.RoundTripper) HTTPClientOption {
+	return func(o *httpClientOptions) {
+		o.transport = rt
+	}
+}
+
+// WithHTTPClientTimeout sets the timeout of the http client, including all the retries, default is no timeout.
+func WithHTTPClientTimeout(d time.Duration) HTTPClientOption {
+	return func(o *httpClientOptions) {
+		o.timeout = d
+	}
+}
+
+// WithHTTPRetry retries the idempotent requests at most maxAttempts times in total on the transient failures,
+// i.e. the connection refused errors and the 502, 503 and 504 responses.
+// The backoff starts from baseBackoff and doubles with jitter, the Retry-After header is respected if present,
+// but the response is returned without retrying if its Retry-After is longer than 30s.
+// Each retry is recorded as a "http.retry" event on the client span.
+func WithHTTPRetry(maxAttempts int, baseBackoff time.Duration) HTTPClientOption {
+	return func(o *httpClientOptions) {
+		o.maxAttempts = maxAttempts
+		o.baseBackoff = baseBackoff
+	}
+}
+
+// WithHTTPNoDeadlineCheck marks the requests sent without a deadline, i.e. neither the context has a deadline
+// nor the client has a timeout, which may hang forever if the server does not respond.
+// The span of the request is set with "no_deadline", and the client_no_deadline_total metric is increased.
+func WithHTTPNoDeadlineCheck(enable bool) HTTPClientOption {
+	return func(o *httpClientOptions) {
+		o.noDeadline = enable
+	}
+}
+
+// NewHTTPClient creates a http client with tracing and metrics, the trace context is injected into the requests,
+// server is the name of the downstream used in the metrics.
+func NewHTTPClient(server string, opts ...HTTPClientOption) *http.Client {
+	o := &httpClientOptions{transport: http.DefaultTransport}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	var rt http.RoundTripper = o.transport
+	if o.maxAttempts > 1 {
+		rt = &retryRoundTripper{next: rt, maxAttempts: o.maxAttempts, baseBackoff: o.baseBackoff}
+	}
+	return &http.Client{
+		Transport: &traceRoundTripper{
+			next:       rt,
+			server:     server,
+			tracer:     otel.Tracer(httpClientTracerName),
+			noDeadline: o.noDeadline,
+		},
+		Timeout: o.timeout,
+	}
+}
+
+// traceRoundTripper starts a client span for the request and records the metrics.
+type traceRoundTripper struct {
+	next       http.RoundTripper
+	server     string
+	tracer     trace.Tracer
+	noDeadline bool
+}
+
+func (t *traceRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	if Disabled() {
+		return t.next.RoundTrip(r)
+	}
+	ctx, span := t.tracer.Start(r.Context(), "HTTP "+r.Method, trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("http.method", r.Method),
+		attribute.String("http.url", r.URL.Redacted()),
+	)
+
+	// the timeout of http.Client is also set as the deadline of the request context
+	if t.noDeadline {
+		checkDeadline(ctx, MetricTypeHTTP, r.Method, t.server)
+	}
+
+	// the request should not be modified by the RoundTripper, so a clone is sent
+	r = r.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(r.Header))
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(r)
+	status := "error"
+	if err != nil {
+		setSpanError(span, err)
+	} else {
+		status = strconv.Itoa(resp.StatusCode)
+		span.SetAttributes(attribute.Int("http.response.code", resp.StatusCode))
+		if resp.StatusCode >= http.StatusInternalServerError {
+			span.SetAttributes(attribute.Bool("error", true))
+		}
+	}
+
+	goapmVecs().clientHandleCounter.WithLabelValues(MetricTypeHTTP, r.Method, t.server, status).Inc()
+	observeClientHandle(time.Since(start).Seconds(), MetricTypeHTTP, r.Method, t.server, status)
+	return resp, err
+}
+
+// retryRoundTripper retries the idempotent requests on the transient failures with the exponential backoff.
+type retryRoundTripper struct {
+	next        http.RoundTripper
+	maxAttempts int
+	baseBackoff time.Duration
+}
+
+func (t *retryRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	retryable := isIdempotent(r.Method) && (r.Body == nil || r.Body == http.NoBody || r.GetBody != nil)
+	span := trace.SpanFromContext(r.Context())
+
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(r)
+		if !retryable || attempt >= t.maxAttempts || !isTransientFailure(resp, err) {
+			return resp, err
+		}
+
+		backoff, ok := t.backoff(attempt, resp)
+		if !ok {
+			return resp, err
+		}
+		var reason string
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			// drain the body so that the connection could be reused
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096)) //nolint:mnd
+			_ = resp.Body.Close()
+		}
+		span.AddEvent("http.retry", trace.WithAttributes(
+			attribute.Int("http.retry.attempt", attempt),
+			attribute.String("http.retry.reason", reason),
+			attribute.Int64("http.retry.backoff_ms", backoff.Milliseconds()),
+		))
+
+		if err := sleepContext(r.Context(), backoff); err != nil {
+			return nil, err
+		}
+		if r.GetBody != nil {
+			body, err := r.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r = r.Clone(r.Context())
+			r.Body = body
+		}
+	}
+}
+
+// backoff returns the Retry-After of the response if present,
+// or baseBackoff * 2^(attempt-1) with the jitter in [-50%, 0].
+// It returns false if the Retry-After is longer than maxRetryAfter, i.e. the request should not be retried.
+func (t *retryRoundTripper) backoff(attempt int, resp *http.Response) (time.Duration, bool) {
+	if resp != nil {
+		if d, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			return d, d <= maxRetryAfter
+		}
+	}
+	backoff := t.baseBackoff << (attempt - 1)
+	if backoff <= 0 {
+		return 0, true
+	}
+	return backoff/2 + rand.N(backoff/2+1), true //nolint:mnd,gosec
+}
+
+// parseRetryAfter parses the Retry-After header in seconds or in http date.
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+func isTransientFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		return errors.Is(err, syscall.ECONNREFUSED)
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleepContext sleeps for d, it returns the error of ctx if ctx is done earlier.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,78 @@
+package apm
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// WithTrustedProxies sets the CIDRs or IPs of the trusted proxies,
+// the client ip would be resolved from X-Forwarded-For and X-Real-IP only if the request comes from them,
+// so that the spoofed headers from untrusted sources are ignored.
+// Default is no trusted proxy, which means the client ip is always the RemoteAddr.
+// It panics if any of the proxies is invalid.
+func WithTrustedProxies(proxies []string) HTTPOption {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			panic(fmt.Errorf("goapm invalid trusted proxy %q: %w", proxy, err))
+		}
+		nets = append(nets, ipNet)
+	}
+
+	return func(o *httpOptions) {
+		o.trustedProxies = append(o.trustedProxies, nets...)
+	}
+}
+
+// isTrustedProxy reports whether the ip is one of the trusted proxies.
+func (o *httpOptions) isTrustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range o.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP resolves the real client ip of the request.
+// If the request comes from a trusted proxy, X-Forwarded-For is walked from right to left and the first
+// untrusted ip is the client ip, then X-Real-IP is tried. Otherwise, it falls back to the RemoteAddr.
+func (o *httpOptions) clientIP(r *http.Request) string {
+	remoteIP := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		remoteIP = host
+	}
+	if !o.isTrustedProxy(net.ParseIP(remoteIP)) {
+		return remoteIP
+	}
+
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		ips := strings.Split(xff, ",")
+		for i := len(ips) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(ips[i]))
+			if ip == nil {
+				break
+			}
+			if i == 0 || !o.isTrustedProxy(ip) {
+				return ip.String()
+			}
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return remoteIP
+}
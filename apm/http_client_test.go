@@ -0,0 +1,163 @@
+package apm
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	exporter := setupTracingTest()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+	defer server.Close()
+
+	resp, err := NewHTTPClient("test").Get(server.URL + "/hello")
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Contains(t, traceparent, spans[0].SpanContext.TraceID().String())
+	assert.Contains(t, spans[0].Attributes, attribute.Int("http.response.code", http.StatusOK))
+}
+
+func TestHTTPClient_NoDeadlineCheck(t *testing.T) {
+	exporter := setupTracingTest()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	get := func(client *http.Client) {
+		exporter.Reset()
+		resp, err := client.Get(server.URL)
+		assert.Nil(t, err)
+		_ = resp.Body.Close()
+	}
+	counter := goapmVecs().clientNoDeadlineCounter.WithLabelValues(MetricTypeHTTP, http.MethodGet, "no deadline")
+
+	get(NewHTTPClient("no deadline", WithHTTPNoDeadlineCheck(true)))
+	assert.Contains(t, exporter.GetSpans()[0].Attributes, attribute.Bool("no_deadline", true))
+	assert.Equal(t, float64(1), testutil.ToFloat64(counter))
+
+	// the client timeout is a deadline
+	get(NewHTTPClient("no deadline", WithHTTPNoDeadlineCheck(true), WithHTTPClientTimeout(time.Second)))
+	assert.NotContains(t, exporter.GetSpans()[0].Attributes, attribute.Bool("no_deadline", true))
+	assert.Equal(t, float64(1), testutil.ToFloat64(counter))
+}
+
+func TestHTTPClient_Retry(t *testing.T) {
+	exporter := setupTracingTest()
+
+	t.Run("retry on 503 with Retry-After", func(t *testing.T) {
+		exporter.Reset()
+		var calls atomic.Int32
+		var bodies []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b := make([]byte, 16)
+			n, _ := r.Body.Read(b)
+			bodies = append(bodies, string(b[:n]))
+			if calls.Add(1) < 3 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		defer server.Close()
+
+		client := NewHTTPClient("test", WithHTTPRetry(3, time.Hour))
+		req, _ := http.NewRequest(http.MethodPut, server.URL, strings.NewReader("body"))
+		resp, err := client.Do(req)
+		assert.Nil(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, int32(3), calls.Load())
+		assert.Equal(t, []string{"body", "body", "body"}, bodies)
+
+		events := exporter.GetSpans()[0].Events
+		assert.Len(t, events, 2)
+		assert.Equal(t, "http.retry", events[0].Name)
+	})
+
+	t.Run("no retry if Retry-After is too long", func(t *testing.T) {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.Header().Set("Retry-After", "86400")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		resp, err := NewHTTPClient("test", WithHTTPRetry(3, time.Millisecond)).Get(server.URL)
+		assert.Nil(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		assert.Equal(t, "86400", resp.Header.Get("Retry-After"))
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("no retry for non-idempotent methods", func(t *testing.T) {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer server.Close()
+
+		resp, err := NewHTTPClient("test", WithHTTPRetry(3, time.Millisecond)).Post(server.URL, "text/plain", nil)
+		assert.Nil(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("retry on connection refused until max attempts", func(t *testing.T) {
+		exporter.Reset()
+		listener, _ := net.Listen("tcp", "127.0.0.1:0")
+		addr := listener.Addr().String()
+		_ = listener.Close()
+
+		_, err := NewHTTPClient("test", WithHTTPRetry(3, time.Millisecond)).Get("http://" + addr)
+		assert.NotNil(t, err)
+		assert.Len(t, exporter.GetSpans()[0].Events, 2+1) // 2 retries and the error
+	})
+
+	t.Run("stop retrying when the context is done", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}))
+		defer server.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		_, err := NewHTTPClient("test", WithHTTPRetry(3, time.Hour)).Do(req)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	d, ok := parseRetryAfter("3")
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, d)
+
+	d, ok = parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	assert.True(t, ok)
+	assert.InDelta(t, time.Hour.Seconds(), d.Seconds(), 2)
+
+	_, ok = parseRetryAfter("invalid")
+	assert.False(t, ok)
+}
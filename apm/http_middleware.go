@@ -0,0 +1,409 @@
+package apm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	httpMiddlewareTracerName = "goapm/httpMiddleware"
+)
+
+// httpOptions is the options of the http instrumentation, shared by TraceHTTP, GinOtel and HTTPServer.
+type httpOptions struct {
+	panicHooks     []func(ctx context.Context, panic any) (stop bool)
+	trustedProxies []*net.IPNet
+	ignorePaths    []string
+	accessLog      bool
+	traceHeader    string
+	captureHeaders []string
+	pinSlow        time.Duration
+	pinErrors      bool
+
+	sensitiveKeys     map[string]struct{}
+	sensitiveKeyRegex *regexp.Regexp
+
+	maxRequestBodyBytes int
+
+	// disabled turns off the instrumentation, see WithHTTPDisabled.
+	disabled bool
+
+	// routeResolver resolves the route of the request when the framework does not give one.
+	routeResolver func(r *http.Request) string
+
+	// tracerProvider is the provider of the tracer, it is the global one if nil.
+	tracerProvider trace.TracerProvider
+
+	// the server options, they are only used by HTTPServer.
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	idleTimeout  time.Duration
+	h2c          bool
+	metricsReg   MetricRegistry
+}
+
+// defaultIgnorePaths are the paths not traced by default.
+var defaultIgnorePaths = []string{"/metrics", "/heartbeat"}
+
+// HTTPOption is the option for the http instrumentation.
+type HTTPOption func(o *httpOptions)
+
+// GinOtelOption is the option for GinOtel, it is the same as HTTPOption.
+type GinOtelOption = HTTPOption
+
+// WithPanicHook adds a hook which would be called when the handler panics,
+// the rest hooks would be skipped if it returns true.
+func WithPanicHook(hook func(ctx context.Context, panic any) (stop bool)) HTTPOption {
+	return func(o *httpOptions) {
+		o.panicHooks = append(o.panicHooks, hook)
+	}
+}
+
+// WithHTTPDisabled turns off the tracing, metrics and logging like Disable, but only for this handler.
+func WithHTTPDisabled(disabled bool) HTTPOption {
+	return func(o *httpOptions) {
+		o.disabled = disabled
+	}
+}
+
+// WithRouteResolver sets the function resolving the route of a request, which names the span and labels the metrics,
+// it is called after the handler so that the routing has been done, e.g. for chi:
+//
+//	apm.WithRouteResolver(func(r *http.Request) string { return chi.RouteContext(r.Context()).RoutePattern() })
+//
+// The default one uses the pattern matched by http.ServeMux, which is only set when the middleware wraps the mux directly.
+// The requests resolved to an empty route are labeled as "unmatched" rather than by their raw paths to bound the cardinality.
+func WithRouteResolver(resolver func(r *http.Request) string) HTTPOption {
+	return func(o *httpOptions) {
+		o.routeResolver = resolver
+	}
+}
+
+// unmatchedRoute is the route of the requests whose route could not be resolved.
+const unmatchedRoute = "unmatched"
+
+// serveMuxPattern returns the path of the pattern matched by http.ServeMux, e.g. "/users/{id}" for "GET /users/{id}".
+func serveMuxPattern(r *http.Request) string {
+	pattern := r.Pattern
+	if i := strings.IndexAny(pattern, " \t"); i >= 0 {
+		pattern = strings.TrimLeft(pattern[i:], " \t")
+	}
+	return pattern
+}
+
+// resolveRoute returns the route given by the framework, or the one resolved by the route resolver if it is empty.
+func (o *httpOptions) resolveRoute(r *http.Request, route string) string {
+	if route != "" {
+		return route
+	}
+	resolver := o.routeResolver
+	if resolver == nil {
+		resolver = serveMuxPattern
+	}
+	if route = resolver(r); route != "" {
+		return route
+	}
+	return unmatchedRoute
+}
+
+// WithIgnorePaths skips the traces and metrics of the requests matching the paths,
+// a path ending with "*" is a prefix match, e.g. "/debug/*", otherwise it is an exact match.
+// It overrides the default ignored paths "/metrics" and "/heartbeat", call it without paths to trace all the requests.
+func WithIgnorePaths(paths ...string) HTTPOption {
+	return func(o *httpOptions) {
+		o.ignorePaths = append([]string(nil), paths...)
+	}
+}
+
+// WithAccessLog enables the access log, which is an info log named "access" emitted after each request,
+// it carries the trace_id so that it could be cross-referenced with the traces even if they are sampled out.
+func WithAccessLog(enable bool) HTTPOption {
+	return func(o *httpOptions) {
+		o.accessLog = enable
+	}
+}
+
+// WithTraceResponseHeader sets the trace id of the request on the response header name, e.g. "X-Trace-Id",
+// so that the clients could report it in the support tickets. An empty name disables it, which is the default.
+// Remember to add it to CORSOptions.ExposeHeaders if the header should be read by the browsers.
+func WithTraceResponseHeader(name string) HTTPOption {
+	return func(o *httpOptions) {
+		o.traceHeader = name
+	}
+}
+
+// WithPinSlowRequests sets the "pinned" attribute on the spans of the requests taking longer than threshold,
+// so that they could be kept by the tail sampling of the otel collector, see WithPinErrors.
+func WithPinSlowRequests(threshold time.Duration) HTTPOption {
+	return func(o *httpOptions) {
+		o.pinSlow = threshold
+	}
+}
+
+// WithPinErrors sets the "pinned" attribute on the spans of the requests panicking or responding 5xx.
+// The pin is only a mark, the otel collector should be configured to keep the pinned traces, e.g.
+//
+//	processors:
+//	  tail_sampling:
+//	    policies:
+//	      - name: pinned
+//	        type: boolean_attribute
+//	        boolean_attribute: { key: pinned, value: true }
+//	      - name: others
+//	        type: probabilistic
+//	        probabilistic: { sampling_percentage: 10 }
+//
+// Note that the spans sampled out by the sampler of the apm never reach the collector,
+// so the head sampling should be kept at a high ratio to make the pin effective.
+func WithPinErrors(enable bool) HTTPOption {
+	return func(o *httpOptions) {
+		o.pinErrors = enable
+	}
+}
+
+// shouldPin reports whether the span of the request should be pinned.
+func (o *httpOptions) shouldPin(panicked bool, status int, elapsed time.Duration) bool {
+	if o.pinErrors && (panicked || status >= http.StatusInternalServerError) {
+		return true
+	}
+	return o.pinSlow > 0 && elapsed >= o.pinSlow
+}
+
+// sensitiveHeaders are never captured by WithCaptureHeaders.
+var sensitiveHeaders = map[string]bool{"Authorization": true, "Proxy-Authorization": true, "Cookie": true}
+
+// WithCaptureHeaders records the request headers in the allowlist as the "http.request.header.<name>" attributes,
+// the name is lowercased, e.g. "http.request.header.x-tenant-id". Authorization and Cookie are never captured.
+func WithCaptureHeaders(names ...string) HTTPOption {
+	return func(o *httpOptions) {
+		for _, name := range names {
+			name = http.CanonicalHeaderKey(name)
+			if !sensitiveHeaders[name] {
+				o.captureHeaders = append(o.captureHeaders, name)
+			}
+		}
+	}
+}
+
+// setHeaderAttributes sets the allowlisted request headers on the span.
+func (o *httpOptions) setHeaderAttributes(span trace.Span, r *http.Request) {
+	for _, name := range o.captureHeaders {
+		if values := r.Header.Values(name); len(values) > 0 {
+			span.SetAttributes(attribute.StringSlice("http.request.header."+strings.ToLower(name), values))
+		}
+	}
+}
+
+// isIgnoredPath reports whether the path should not be traced.
+func (o *httpOptions) isIgnoredPath(path string) bool {
+	for _, p := range o.ignorePaths {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if path == p {
+			return true
+		}
+	}
+	return false
+}
+
+// TraceHTTP is a net/http middleware for tracing, metrics and logging,
+// it could be used with any framework compatible with net/http, e.g. `r.Use(apm.TraceHTTP)` for chi.
+// The route is resolved by the pattern of http.ServeMux, use TraceHTTPMiddleware with WithRouteResolver for other routers.
+func TraceHTTP(next http.Handler) http.Handler {
+	return TraceHTTPMiddleware()(next)
+}
+
+// TraceHTTPMiddleware creates a net/http middleware like TraceHTTP with the given options.
+func TraceHTTPMiddleware(opts ...HTTPOption) func(next http.Handler) http.Handler {
+	t := newHTTPTracer(httpMiddlewareTracerName, opts...)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.serve(w, r, "", next)
+		})
+	}
+}
+
+// httpTracer does the extract -> start span -> record metrics -> recover -> finish work for a http request,
+// the framework wrappers should delegate to it.
+type httpTracer struct {
+	tracer trace.Tracer
+	o      *httpOptions
+	// vecs are the goapm metrics of the registry given by WithMetricRegistry, the global ones are used if nil.
+	vecs *metricVecs
+}
+
+// metrics returns the goapm metrics the tracer records to.
+func (t *httpTracer) metrics() *metricVecs {
+	if t.vecs != nil {
+		return t.vecs
+	}
+	return goapmVecs()
+}
+
+func newHTTPTracer(tracerName string, opts ...HTTPOption) *httpTracer {
+	o := &httpOptions{
+		ignorePaths: defaultIgnorePaths,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	tp := o.tracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return &httpTracer{
+		tracer: tp.Tracer(tracerName),
+		o:      o,
+		vecs:   metricVecsOf(o.metricsReg),
+	}
+}
+
+// statusWriter is a http.ResponseWriter which records the status code and the body size, e.g. gin.ResponseWriter.
+type statusWriter interface {
+	http.ResponseWriter
+	Status() int
+	Size() int
+}
+
+// serve handles the request by next, the route is used to name the span and label the metrics,
+// it is resolved by the route resolver after the handler if empty.
+// It returns true if next panicked, in which case the response has been set to 500.
+func (t *httpTracer) serve(w http.ResponseWriter, r *http.Request, route string, next http.Handler) (panicked bool) {
+	if Disabled() || t.o.disabled || t.o.isIgnoredPath(r.URL.Path) {
+		next.ServeHTTP(w, r)
+		return false
+	}
+
+	// trace, the span is renamed once the route is resolved
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := t.tracer.Start(ctx, strings.TrimSpace("HTTP "+r.Method+" "+route))
+	defer span.End()
+	if sc := span.SpanContext(); t.o.traceHeader != "" && sc.HasTraceID() {
+		w.Header().Set(t.o.traceHeader, sc.TraceID().String())
+	}
+	clientIP := t.o.clientIP(r)
+	span.SetAttributes(attribute.String("http.client_ip", clientIP))
+	setRequestAttributes(span, r)
+	t.o.setHeaderAttributes(span, r)
+	t.o.captureRequestBody(span, r)
+	r = r.WithContext(ctx)
+
+	sw, ok := w.(statusWriter)
+	if !ok {
+		sw = &responseWrapper{ResponseWriter: w}
+	}
+
+	start := time.Now()
+	panicked = t.handle(sw, r, route, next)
+	route = t.o.resolveRoute(r, route)
+	span.SetName("HTTP " + r.Method + " " + route)
+
+	// http response status code
+	status := sw.Status()
+	elapsed := time.Since(start)
+	span.SetAttributes(
+		attribute.Int("http.response.code", status),
+		attribute.Int64("http.duration_ms", elapsed.Milliseconds()),
+		// gin.ResponseWriter returns -1 if nothing is written
+		attribute.Int("http.response.size", max(sw.Size(), 0)),
+	)
+	if t.o.shouldPin(panicked, status, elapsed) {
+		span.SetAttributes(attribute.Bool("pinned", true))
+	}
+
+	// business error code
+	businessErrorCode, businessErrorMsg := getBusinessError(sw.Header())
+	if businessErrorCode != "" {
+		span.SetAttributes(
+			attribute.String("http.response.business_error_code", businessErrorCode),
+			attribute.String("http.response.business_error_msg", businessErrorMsg),
+		)
+		t.metrics().businessErrorCounter.WithLabelValues(businessErrorCode).Inc()
+	}
+
+	// metrics
+	t.metrics().serverHandleCounter.WithLabelValues(MetricTypeHTTP, r.Method+"."+route, "", "").Inc()
+	t.metrics().observeServerHandle(elapsed.Seconds(), MetricTypeHTTP, r.Method+"."+route, strconv.Itoa(status), "", "")
+
+	// access log
+	if t.o.accessLog {
+		Logger.Info(ctx, "access", map[string]any{
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"status":      status,
+			"duration_ms": elapsed.Milliseconds(),
+			"client_ip":   clientIP,
+			traceID:       span.SpanContext().TraceID().String(),
+		})
+	}
+	return panicked
+}
+
+// setRequestAttributes sets the request metadata on the span.
+// The request size is taken from the Content-Length header rather than reading the body.
+func setRequestAttributes(span trace.Span, r *http.Request) {
+	if ua := r.UserAgent(); ua != "" {
+		span.SetAttributes(attribute.String("http.user_agent", ua))
+	}
+	if referer := r.Referer(); referer != "" {
+		span.SetAttributes(attribute.String("http.referer", referer))
+	}
+	if r.ContentLength > 0 {
+		span.SetAttributes(attribute.Int64("http.request.size", r.ContentLength))
+	}
+}
+
+// handle calls next and recovers the panic.
+func (t *httpTracer) handle(w http.ResponseWriter, r *http.Request, route string, next http.Handler) (panicked bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			panicked = true
+			ctx := r.Context()
+			route := t.o.resolveRoute(r, route)
+			params := t.o.redactForm(r.Form)
+			span := trace.SpanFromContext(ctx)
+			span.SetAttributes(
+				attribute.Bool("error", true),
+				attribute.String("path", route),
+				attribute.String("method", r.Method),
+				attribute.String("params", params),
+			)
+			RecordError(span, fmt.Errorf("%v", err))
+
+			// log
+			Logger.Error(ctx, "panic in http handler", fmt.Errorf("panic: %v", err), map[string]any{
+				"method": r.Method,
+				"path":   route,
+				"params": params,
+				"stack":  string(debug.Stack()),
+			})
+			t.metrics().panicRecoveredCounter.WithLabelValues(MetricTypeHTTP, r.Method+"."+route).Inc()
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+
+			// run panic hooks
+			for _, hook := range t.o.panicHooks {
+				if hook(ctx, err) {
+					break
+				}
+			}
+		}
+	}()
+
+	// handle request
+	next.ServeHTTP(w, r)
+	return false
+}
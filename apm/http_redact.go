@@ -0,0 +1,150 @@
+package apm
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const redactedValue = "***"
+
+// WithSensitiveKeys replaces the values of the json and form keys with "***" in the recorded request params and body,
+// the keys are matched case-insensitively, e.g. "password", "token".
+func WithSensitiveKeys(keys ...string) HTTPOption {
+	return func(o *httpOptions) {
+		if o.sensitiveKeys == nil {
+			o.sensitiveKeys = make(map[string]struct{}, len(keys))
+		}
+		for _, k := range keys {
+			o.sensitiveKeys[strings.ToLower(k)] = struct{}{}
+		}
+	}
+}
+
+// WithSensitiveKeyRegex is like WithSensitiveKeys, but the keys matching the regex are replaced,
+// e.g. regexp.MustCompile(`(?i)secret|token`).
+func WithSensitiveKeyRegex(re *regexp.Regexp) HTTPOption {
+	return func(o *httpOptions) {
+		o.sensitiveKeyRegex = re
+	}
+}
+
+func (o *httpOptions) hasSensitiveKeys() bool {
+	return len(o.sensitiveKeys) > 0 || o.sensitiveKeyRegex != nil
+}
+
+func (o *httpOptions) isSensitiveKey(key string) bool {
+	if _, ok := o.sensitiveKeys[strings.ToLower(key)]; ok {
+		return true
+	}
+	return o.sensitiveKeyRegex != nil && o.sensitiveKeyRegex.MatchString(key)
+}
+
+// redactForm encodes the form with the values of the sensitive keys replaced.
+func (o *httpOptions) redactForm(form url.Values) string {
+	if !o.hasSensitiveKeys() {
+		return form.Encode()
+	}
+	redacted := make(url.Values, len(form))
+	for k, vs := range form {
+		if o.isSensitiveKey(k) {
+			vs = []string{redactedValue}
+		}
+		redacted[k] = vs
+	}
+	return redacted.Encode()
+}
+
+// redactJSON replaces the values of the sensitive keys in the json body, including the objects and arrays,
+// it scans the text rather than decoding the body, so that the truncated body could be redacted too.
+func (o *httpOptions) redactJSON(body []byte) []byte {
+	if !o.hasSensitiveKeys() {
+		return body
+	}
+	var redacted []byte
+	last := 0
+	for i := 0; i < len(body); {
+		if body[i] != '"' {
+			i++
+			continue
+		}
+		keyEnd := skipJSONString(body, i)
+		colon := skipJSONSpaces(body, keyEnd)
+		if colon >= len(body) || body[colon] != ':' {
+			// a string value or an unterminated key
+			i = keyEnd
+			continue
+		}
+		valueStart := skipJSONSpaces(body, colon+1)
+		if !o.isSensitiveKey(string(body[i+1 : keyEnd-1])) {
+			// the nested keys of the value are scanned as well
+			i = valueStart
+			continue
+		}
+		valueEnd := skipJSONValue(body, valueStart)
+		if valueEnd > valueStart {
+			redacted = append(redacted, body[last:valueStart]...)
+			redacted = append(redacted, `"`+redactedValue+`"`...)
+			last = valueEnd
+		}
+		i = valueEnd
+	}
+	if redacted == nil {
+		return body
+	}
+	return append(redacted, body[last:]...)
+}
+
+// skipJSONString returns the index after the string starting at body[start], or len(body) if it is unterminated.
+func skipJSONString(body []byte, start int) int {
+	for i := start + 1; i < len(body); i++ {
+		switch body[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return len(body)
+}
+
+// skipJSONSpaces returns the index of the first non-space byte from start.
+func skipJSONSpaces(body []byte, start int) int {
+	for start < len(body) && strings.IndexByte(" \t\r\n", body[start]) >= 0 {
+		start++
+	}
+	return start
+}
+
+// skipJSONValue returns the index after the value starting at body[start],
+// the objects and arrays end at the matching bracket, and the unterminated ones at len(body).
+func skipJSONValue(body []byte, start int) int {
+	if start >= len(body) {
+		return start
+	}
+	switch body[start] {
+	case '"':
+		return skipJSONString(body, start)
+	case '{', '[':
+		depth := 0
+		for i := start; i < len(body); i++ {
+			switch body[i] {
+			case '"':
+				i = skipJSONString(body, i) - 1
+			case '{', '[':
+				depth++
+			case '}', ']':
+				if depth--; depth == 0 {
+					return i + 1
+				}
+			}
+		}
+		return len(body)
+	default:
+		i := start
+		for i < len(body) && strings.IndexByte(" \t\r\n,}]", body[i]) < 0 {
+			i++
+		}
+		return i
+	}
+}
@@ -1,10 +1,29 @@
 package apm
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/http2"
 )
 
 func TestHTTPServer_Handle(t *testing.T) {
@@ -31,3 +50,497 @@ func TestHTTPServer_Handle(t *testing.T) {
 		}
 	}
 }
+
+func TestHTTPServer_Timeouts(t *testing.T) {
+	server := NewHTTPServer(":", WithHTTPTimeouts(time.Second, 2*time.Second, 3*time.Second))
+	defer server.listener.Close()
+
+	assert.Equal(t, 30*time.Second, server.ReadHeaderTimeout)
+	assert.Equal(t, time.Second, server.ReadTimeout)
+	assert.Equal(t, 2*time.Second, server.WriteTimeout)
+	assert.Equal(t, 3*time.Second, server.IdleTimeout)
+}
+
+func TestHTTPServer_H2C(t *testing.T) {
+	exporter := setupTracingTest()
+	server := NewHTTPServer("127.0.0.1:", WithH2C())
+	server.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	})
+	server.Start()
+	defer server.Close()
+
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	resp, err := client.Get("http://" + server.listener.Addr().String() + "/hello")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "HTTP/2.0", string(body))
+	assert.Len(t, exporter.GetSpans(), 1)
+
+	// http/1 still works
+	resp, err = http.Get("http://" + server.listener.Addr().String() + "/hello")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	body, _ = io.ReadAll(resp.Body)
+	assert.Equal(t, "HTTP/1.1", string(body))
+}
+
+func TestHTTPServer_Use(t *testing.T) {
+	exporter := setupTracingTest()
+	server := NewHTTPServer(":")
+	defer server.listener.Close()
+
+	var order []string
+	middleware := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// the middlewares are executed inside the span
+				assert.True(t, trace.SpanFromContext(r.Context()).IsRecording())
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	server.HandleFunc("/before", func(w http.ResponseWriter, r *http.Request) { order = append(order, "handler") })
+	server.Use(middleware("first"), middleware("second"))
+	server.HandleFunc("/after", func(w http.ResponseWriter, r *http.Request) { order = append(order, "handler") })
+
+	for _, path := range []string{"/before", "/after"} {
+		order = nil
+		exporter.Reset()
+		server.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, []string{"first", "second", "handler"}, order)
+		assert.Len(t, exporter.GetSpans(), 1)
+	}
+}
+
+func TestHTTPServer_RegisterPprofHandlers(t *testing.T) {
+	server := NewHTTPServer(":")
+	defer server.listener.Close()
+	server.RegisterPprofHandlers(&BasicAuth{Username: "admin", Password: "secret"})
+
+	t.Run("request without auth should be rejected", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("request with auth should work", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil)
+		r.SetBasicAuth("admin", "secret")
+		server.Handler.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "goroutine")
+	})
+}
+
+func TestTraceHTTP(t *testing.T) {
+	exporter := setupTracingTest()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("hello"))
+	})
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	handler := TraceHTTP(mux)
+
+	t.Run("normal request should be traced", func(t *testing.T) {
+		exporter.Reset()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/hello", nil))
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		spans := exporter.GetSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "HTTP GET /hello", spans[0].Name)
+		assert.Contains(t, spans[0].Attributes, attribute.Int("http.response.code", http.StatusCreated))
+		assert.Contains(t, spans[0].Attributes, attribute.Int("http.response.size", 5))
+	})
+
+	t.Run("request metadata should be recorded", func(t *testing.T) {
+		exporter.Reset()
+		r := httptest.NewRequest(http.MethodPost, "/hello", strings.NewReader("hello"))
+		r.Header.Set("User-Agent", "goapm-test")
+		r.Header.Set("Referer", "https://example.com")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+
+		spans := exporter.GetSpans()
+		assert.Len(t, spans, 1)
+		assert.Contains(t, spans[0].Attributes, attribute.String("http.user_agent", "goapm-test"))
+		assert.Contains(t, spans[0].Attributes, attribute.String("http.referer", "https://example.com"))
+		assert.Contains(t, spans[0].Attributes, attribute.Int64("http.request.size", 5))
+	})
+
+	t.Run("panic should be recovered", func(t *testing.T) {
+		exporter.Reset()
+		before := testutil.ToFloat64(goapmVecs().panicRecoveredCounter.WithLabelValues(MetricTypeHTTP, "GET./panic"))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Equal(t, before+1, testutil.ToFloat64(goapmVecs().panicRecoveredCounter.WithLabelValues(MetricTypeHTTP, "GET./panic")))
+
+		spans := exporter.GetSpans()
+		assert.Len(t, spans, 1)
+		assert.Contains(t, spans[0].Attributes, attribute.Bool("error", true))
+	})
+}
+
+func TestTraceHTTP_Route(t *testing.T) {
+	exporter := setupTracingTest()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	handler := TraceHTTP(mux)
+
+	t.Run("the pattern of the mux should be the route", func(t *testing.T) {
+		exporter.Reset()
+		counter := goapmVecs().serverHandleCounter.WithLabelValues(MetricTypeHTTP, "GET./users/{id}", "", "")
+		before := testutil.ToFloat64(counter)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/123", nil))
+
+		spans := exporter.GetSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "HTTP GET /users/{id}", spans[0].Name)
+		assert.Equal(t, before+1, testutil.ToFloat64(counter))
+	})
+
+	t.Run("unmatched path should not be the route", func(t *testing.T) {
+		exporter.Reset()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/scan/a1b2c3", nil))
+
+		spans := exporter.GetSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "HTTP GET unmatched", spans[0].Name)
+	})
+
+	t.Run("the route resolver should be used", func(t *testing.T) {
+		exporter.Reset()
+		resolved := TraceHTTPMiddleware(WithRouteResolver(func(r *http.Request) string {
+			return "/custom"
+		}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		resolved.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/anything/42", nil))
+
+		spans := exporter.GetSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "HTTP GET /custom", spans[0].Name)
+	})
+}
+
+func TestTraceHTTP_ResponseController(t *testing.T) {
+	_ = setupTracingTest()
+
+	t.Run("flush should be passed through", func(t *testing.T) {
+		handler := TraceHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("data: 1\n\n"))
+			w.(http.Flusher).Flush()
+		}))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events", nil))
+		assert.True(t, w.Flushed)
+	})
+
+	t.Run("hijack should be passed through", func(t *testing.T) {
+		server := httptest.NewServer(TraceHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			if !assert.Nil(t, err) {
+				return
+			}
+			defer conn.Close()
+			_, _ = rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+			_ = rw.Flush()
+		})))
+		defer server.Close()
+
+		resp, err := http.Get(server.URL + "/ws")
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, "hijacked", string(body))
+	})
+}
+
+func TestHTTPOptions_ClientIP(t *testing.T) {
+	o := &httpOptions{}
+	WithTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"})(o)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		xRealIP    string
+		want       string
+	}{
+		{"no proxy", "1.2.3.4:1234", "", "", "1.2.3.4"},
+		{"spoofed xff from untrusted source", "1.2.3.4:1234", "5.6.7.8", "5.6.7.8", "1.2.3.4"},
+		{"xff from trusted proxy", "10.0.0.1:1234", "5.6.7.8", "", "5.6.7.8"},
+		{"xff with spoofed prefix", "10.0.0.1:1234", "9.9.9.9, 5.6.7.8, 10.0.0.2", "", "5.6.7.8"},
+		{"all trusted in xff", "192.168.1.1:1234", "10.0.0.3, 10.0.0.2", "", "10.0.0.3"},
+		{"x-real-ip from trusted proxy", "10.0.0.1:1234", "", "5.6.7.8", "5.6.7.8"},
+		{"trusted proxy without headers", "10.0.0.1:1234", "", "", "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if tt.xRealIP != "" {
+				r.Header.Set("X-Real-IP", tt.xRealIP)
+			}
+			assert.Equal(t, tt.want, o.clientIP(r))
+		})
+	}
+
+	t.Run("invalid proxy should panic", func(t *testing.T) {
+		assert.Panics(t, func() { WithTrustedProxies([]string{"invalid"}) })
+	})
+}
+
+func TestHTTPOptions_IgnorePaths(t *testing.T) {
+	exporter := setupTracingTest()
+	handler := func(opts ...HTTPOption) http.Handler {
+		return TraceHTTPMiddleware(opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("ok"))
+		}))
+	}
+
+	tests := []struct {
+		name   string
+		opts   []HTTPOption
+		path   string
+		traced bool
+	}{
+		{"default ignores metrics", nil, "/metrics", false},
+		{"default ignores heartbeat", nil, "/heartbeat", false},
+		{"default traces others", nil, "/hello", true},
+		{"exact match", []HTTPOption{WithIgnorePaths("/hello")}, "/hello", false},
+		{"exact match should not match sub path", []HTTPOption{WithIgnorePaths("/hello")}, "/hello/world", true},
+		{"prefix match", []HTTPOption{WithIgnorePaths("/debug/*")}, "/debug/pprof/", false},
+		{"override defaults", []HTTPOption{WithIgnorePaths()}, "/metrics", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter.Reset()
+			w := httptest.NewRecorder()
+			handler(tt.opts...).ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			assert.Equal(t, "ok", w.Body.String())
+			assert.Equal(t, tt.traced, len(exporter.GetSpans()) == 1)
+		})
+	}
+}
+
+func TestHTTPOptions_CaptureRequestBody(t *testing.T) {
+	exporter := setupTracingTest()
+	const body = `{"name":"goapm"}`
+
+	var received []byte
+	handler := TraceHTTPMiddleware(WithRequestBodyCapture(1024))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+	}))
+
+	compress := func(encoding string) []byte {
+		var buf bytes.Buffer
+		var w io.WriteCloser
+		switch encoding {
+		case "gzip":
+			w = gzip.NewWriter(&buf)
+		case "deflate":
+			w = zlib.NewWriter(&buf)
+		default:
+			return []byte(body)
+		}
+		_, _ = w.Write([]byte(body))
+		_ = w.Close()
+		return buf.Bytes()
+	}
+
+	for _, encoding := range []string{"", "gzip", "deflate"} {
+		t.Run("encoding "+encoding, func(t *testing.T) {
+			exporter.Reset()
+			raw := compress(encoding)
+			r := httptest.NewRequest(http.MethodPost, "/hello", bytes.NewReader(raw))
+			r.Header.Set("Content-Type", "application/json")
+			if encoding != "" {
+				r.Header.Set("Content-Encoding", encoding)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			assert.Equal(t, raw, received)
+			spans := exporter.GetSpans()
+			assert.Len(t, spans, 1)
+			assert.Contains(t, spans[0].Attributes, attribute.String("http.request.body", body))
+		})
+	}
+
+	t.Run("body should be truncated", func(t *testing.T) {
+		exporter.Reset()
+		handler := TraceHTTPMiddleware(WithRequestBodyCapture(4))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received, _ = io.ReadAll(r.Body)
+		}))
+		r := httptest.NewRequest(http.MethodPost, "/hello", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+
+		assert.Equal(t, body, string(received))
+		assert.Contains(t, exporter.GetSpans()[0].Attributes, attribute.String("http.request.body", body[:4]))
+	})
+}
+
+func TestHTTPOptions_AccessLog(t *testing.T) {
+	setupTracingTest()
+	buf := &bytes.Buffer{}
+	logrus.SetOutput(buf)
+	defer logrus.SetOutput(os.Stderr)
+
+	handler := TraceHTTPMiddleware(WithAccessLog(true))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	r.RemoteAddr = "1.2.3.4:1234"
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	var entry map[string]any
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "access", entry["msg"])
+	assert.Equal(t, "GET", entry["method"])
+	assert.Equal(t, "/hello", entry["path"])
+	assert.Equal(t, float64(http.StatusAccepted), entry["status"])
+	assert.Equal(t, "1.2.3.4", entry["client_ip"])
+	assert.NotEmpty(t, entry[traceID])
+}
+
+func TestHTTPOptions_SensitiveKeys(t *testing.T) {
+	exporter := setupTracingTest()
+	handler := TraceHTTPMiddleware(
+		WithRequestBodyCapture(1024),
+		WithSensitiveKeys("Password"),
+		WithSensitiveKeyRegex(regexp.MustCompile(`(?i)token`)),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		panic("boom")
+	}))
+
+	t.Run("json body", func(t *testing.T) {
+		exporter.Reset()
+		body := `{"name":"goapm","password":"password123","user":{"access_token":"abc","age":18},"pin":1234}`
+		r := httptest.NewRequest(http.MethodPost, "/hello", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+
+		assert.Contains(t, exporter.GetSpans()[0].Attributes, attribute.String("http.request.body",
+			`{"name":"goapm","password":"***","user":{"access_token":"***","age":18},"pin":1234}`))
+	})
+
+	t.Run("json body with compound values", func(t *testing.T) {
+		exporter.Reset()
+		body := `{"password": {"v":"x","n":[1,{"a":"}"}]}, "tokens":["a","b]"], "list":[{"token":1}],"name":"password"}`
+		r := httptest.NewRequest(http.MethodPost, "/hello", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+
+		assert.Contains(t, exporter.GetSpans()[0].Attributes, attribute.String("http.request.body",
+			`{"password": "***", "tokens":"***", "list":[{"token":"***"}],"name":"password"}`))
+	})
+
+	t.Run("truncated json body", func(t *testing.T) {
+		exporter.Reset()
+		handler := TraceHTTPMiddleware(WithRequestBodyCapture(24), WithSensitiveKeys("password"))(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		r := httptest.NewRequest(http.MethodPost, "/hello", strings.NewReader(`{"password":"password123"}`))
+		r.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+
+		assert.Contains(t, exporter.GetSpans()[0].Attributes, attribute.String("http.request.body", `{"password":"***"`))
+
+		exporter.Reset()
+		r = httptest.NewRequest(http.MethodPost, "/hello", strings.NewReader(`{"password":{"v":"password123"}}`))
+		r.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+
+		assert.Contains(t, exporter.GetSpans()[0].Attributes, attribute.String("http.request.body", `{"password":"***"`))
+	})
+
+	t.Run("form params", func(t *testing.T) {
+		exporter.Reset()
+		r := httptest.NewRequest(http.MethodGet, "/hello?name=goapm&password=password123&Token=abc", nil)
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+
+		assert.Contains(t, exporter.GetSpans()[0].Attributes, attribute.String("params", "Token=%2A%2A%2A&name=goapm&password=%2A%2A%2A"))
+	})
+}
+
+func TestHTTPOptions_CaptureHeaders(t *testing.T) {
+	exporter := setupTracingTest()
+	handler := TraceHTTPMiddleware(WithCaptureHeaders("x-tenant-id", "Accept-Language", "Authorization", "cookie"))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	r := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	r.Header.Set("X-Tenant-ID", "tenant-1")
+	r.Header.Add("Accept-Language", "en")
+	r.Header.Add("Accept-Language", "zh")
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("Cookie", "session=secret")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	attrs := exporter.GetSpans()[0].Attributes
+	assert.Contains(t, attrs, attribute.StringSlice("http.request.header.x-tenant-id", []string{"tenant-1"}))
+	assert.Contains(t, attrs, attribute.StringSlice("http.request.header.accept-language", []string{"en", "zh"}))
+	for _, attr := range attrs {
+		assert.NotContains(t, attr.Value.Emit(), "secret")
+	}
+}
+
+func TestHTTPOptions_TraceResponseHeader(t *testing.T) {
+	exporter := setupTracingTest()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	TraceHTTPMiddleware(WithTraceResponseHeader("X-Trace-Id"))(handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/hello", nil))
+	assert.Equal(t, exporter.GetSpans()[0].SpanContext.TraceID().String(), w.Header().Get("X-Trace-Id"))
+
+	// disabled by default
+	w = httptest.NewRecorder()
+	TraceHTTP(handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/hello", nil))
+	assert.Empty(t, w.Header().Get("X-Trace-Id"))
+}
+
+func TestHTTPOptions_Pin(t *testing.T) {
+	exporter := setupTracingTest()
+	handler := TraceHTTPMiddleware(WithPinErrors(true), WithPinSlowRequests(50*time.Millisecond))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/slow":
+				time.Sleep(60 * time.Millisecond)
+			case "/error":
+				w.WriteHeader(http.StatusBadGateway)
+			case "/panic":
+				panic("boom")
+			}
+		}))
+
+	for path, pinned := range map[string]bool{"/ok": false, "/slow": true, "/error": true, "/panic": true} {
+		t.Run(path, func(t *testing.T) {
+			exporter.Reset()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+			spans := exporter.GetSpans()
+			assert.Len(t, spans, 1)
+			if pinned {
+				assert.Contains(t, spans[0].Attributes, attribute.Bool("pinned", true))
+			} else {
+				assert.NotContains(t, spans[0].Attributes, attribute.Bool("pinned", true))
+			}
+		})
+	}
+}
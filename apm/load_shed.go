@@ -0,0 +1,76 @@
+package apm
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MaxInFlightOption is the option for MaxInFlight.
+type MaxInFlightOption func(m *maxInFlight)
+
+// WithQueueTimeout makes the request wait at most d for the capacity before it is shed, default is not to wait.
+func WithQueueTimeout(d time.Duration) MaxInFlightOption {
+	return func(m *maxInFlight) {
+		m.queueTimeout = d
+	}
+}
+
+type maxInFlight struct {
+	sem          chan struct{}
+	queueTimeout time.Duration
+}
+
+// MaxInFlight creates a Gin middleware which limits the number of the requests handled concurrently to n,
+// the requests exceeding the capacity are responded with 503, set with "load_shed" on the span
+// and counted by the "load_shed_total" metric.
+// It should be used after GinOtel so that the span has been started.
+// If n <= 0, there is no limit on the requests, like WithMaxOpenConns.
+func MaxInFlight(n int, opts ...MaxInFlightOption) gin.HandlerFunc {
+	if n <= 0 {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+	m := &maxInFlight{sem: make(chan struct{}, n)}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return func(c *gin.Context) {
+		if !m.acquire(c) {
+			trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.Bool("load_shed", true))
+			goapmVecs().loadShedCounter.WithLabelValues(MetricTypeHTTP, c.Request.Method+"."+c.FullPath()).Inc()
+			c.AbortWithStatus(http.StatusServiceUnavailable)
+			return
+		}
+		defer func() { <-m.sem }()
+		c.Next()
+	}
+}
+
+// acquire takes a slot of the capacity, waiting at most queueTimeout, it reports whether the slot is taken.
+func (m *maxInFlight) acquire(c *gin.Context) bool {
+	select {
+	case m.sem <- struct{}{}:
+		return true
+	default:
+	}
+	if m.queueTimeout <= 0 {
+		return false
+	}
+
+	timer := time.NewTimer(m.queueTimeout)
+	defer timer.Stop()
+	select {
+	case m.sem <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-c.Request.Context().Done():
+		return false
+	}
+}
@@ -0,0 +1,90 @@
+package apm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestMaxInFlight(t *testing.T) {
+	setup := func(opts ...MaxInFlightOption) (*gin.Engine, chan struct{}, chan struct{}) {
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		r.Use(GinOtel(), MaxInFlight(1, opts...))
+		entered, release := make(chan struct{}, 1), make(chan struct{})
+		r.GET("/shed", func(c *gin.Context) {
+			entered <- struct{}{}
+			<-release
+		})
+		return r, entered, release
+	}
+	serve := func(r *gin.Engine) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/shed", nil))
+		return w.Code
+	}
+
+	t.Run("shed when exceeding the capacity", func(t *testing.T) {
+		exporter := setupTracingTest()
+		before := testutil.ToFloat64(goapmVecs().loadShedCounter.WithLabelValues(MetricTypeHTTP, "GET./shed"))
+		r, entered, release := setup()
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, http.StatusOK, serve(r))
+		}()
+		<-entered
+
+		assert.Equal(t, http.StatusServiceUnavailable, serve(r))
+		assert.Equal(t, before+1, testutil.ToFloat64(goapmVecs().loadShedCounter.WithLabelValues(MetricTypeHTTP, "GET./shed")))
+		spans := exporter.GetSpans()
+		assert.Len(t, spans, 1)
+		assert.Contains(t, spans[0].Attributes, attribute.Bool("load_shed", true))
+
+		close(release)
+		wg.Wait()
+		// the capacity is released
+		go func() { <-entered }()
+		assert.Equal(t, http.StatusOK, serve(r))
+	})
+
+	t.Run("wait in the queue", func(t *testing.T) {
+		r, entered, release := setup(WithQueueTimeout(time.Second))
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, http.StatusOK, serve(r))
+		}()
+		<-entered
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			<-entered
+		}()
+		assert.Equal(t, http.StatusOK, serve(r))
+		wg.Wait()
+	})
+}
+
+func TestMaxInFlight_NonPositive(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, n := range []int{0, -1} {
+		r := gin.New()
+		r.Use(MaxInFlight(n))
+		r.GET("/unlimited", func(c *gin.Context) {})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/unlimited", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+}
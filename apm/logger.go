@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"time"
 
 	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel/attribute"
@@ -67,6 +66,9 @@ func (l *logrusHook) Levels() []logrus.Level {
 func (l *logrusHook) Fire(entry *logrus.Entry) error {
 	entry.Data["host"] = internal.BuildInfo.Hostname()
 	entry.Data["app"] = internal.BuildInfo.AppName()
+	if id := RequestIDFromContext(entry.Context); id != "" {
+		entry.Data[requestIDKey] = id
+	}
 	return nil
 }
 
@@ -80,7 +82,7 @@ func (l *logrusTracerHook) Fire(entry *logrus.Entry) error {
 	if span := trace.SpanFromContext(entry.Context); span != nil {
 		entry.Data[traceID] = span.SpanContext().TraceID().String()
 		span.SetAttributes(attribute.Bool("error", true))
-		span.RecordError(getEntryError(entry), trace.WithStackTrace(true), trace.WithTimestamp(time.Now()))
+		RecordError(span, getEntryError(entry))
 	}
 	return nil
 }
@@ -2,6 +2,9 @@ package apm
 
 import (
 	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
@@ -19,74 +22,392 @@ const (
 )
 
 func init() {
-	MetricsReg.MustRegister(serverHandleHistogram, serverHandleCounter, clientHandleCounter, clientHandleHistogram, libraryCounter)
-	MetricsReg.MustRegister(
+	recreateMetrics(func(*metricConfig) {})
+	registerRuntimeMetrics()
+}
+
+// registerRuntimeMetrics registers the go runtime and process metrics to MetricsReg.
+func registerRuntimeMetrics() {
+	MetricsReg.MustRegister(runtimeMetrics()...)
+}
+
+// runtimeMetrics creates the collectors of the go runtime and process metrics.
+func runtimeMetrics() []prometheus.Collector {
+	return []prometheus.Collector{
 		collectors.NewGoCollector(
 			collectors.WithGoCollectorRuntimeMetrics(collectors.GoRuntimeMetricsRule{
 				Matcher: regexp.MustCompile("/.*"),
 			}),
 		),
 		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
-	)
+	}
+}
+
+// ResetMetricRegistry replaces MetricsReg with a new registry which has only the goapm, go runtime and process metrics,
+// so that the collectors could be registered again, e.g. by the tests creating several infras.
+// The collectors registered before, including the db stats of the mysql clients and the global labels
+// added by AddGlobalMetricLabels, are dropped, and the goapm metrics start from zero.
+// It is not safe to call it while the metrics are being recorded or gathered.
+func ResetMetricRegistry() {
+	MetricsReg = newCustomMetricRegistry(defaultMetricLabels())
+	recreateMetrics(func(*metricConfig) {})
+	registerRuntimeMetrics()
 }
 
 var (
 	// MetricsReg is the global metric registry.
-	MetricsReg = newCustomMetricRegistry(map[string]string{
+	MetricsReg = newCustomMetricRegistry(defaultMetricLabels())
+)
+
+// defaultMetricLabels returns the constant labels of all the metrics,
+// the version and commit are added only if they are known.
+func defaultMetricLabels() map[string]string {
+	labels := map[string]string{
 		"host": internal.BuildInfo.Hostname(),
 		"app":  internal.BuildInfo.AppName(),
-	})
-)
+	}
+	if v := internal.BuildInfo.Version(); v != "" {
+		labels["version"] = v
+	}
+	if c := internal.BuildInfo.Commit(); c != "" {
+		labels["commit"] = c
+	}
+	return labels
+}
+
+// metricConfig is the configuration of the goapm metrics, see WithMetricNamespace and WithHandleSummaries.
+type metricConfig struct {
+	// namespace is the prefix of the goapm metric names.
+	namespace string
+	// summaryObjectives are the quantiles of the handle summaries, they are not created if it is nil.
+	summaryObjectives map[float64]float64
+}
+
+// metricVecs are the goapm metrics created together with the same configuration.
+type metricVecs struct {
+	metricConfig
+
+	serverHandleHistogram    *prometheus.HistogramVec
+	serverHandleCounter      *prometheus.CounterVec
+	clientHandleCounter      *prometheus.CounterVec
+	clientHandleHistogram    *prometheus.HistogramVec
+	libraryCounter           *prometheus.CounterVec
+	businessErrorCounter     *prometheus.CounterVec
+	panicRecoveredCounter    *prometheus.CounterVec
+	loadShedCounter          *prometheus.CounterVec
+	circuitBreakerStateGauge *prometheus.GaugeVec
+	clientNoDeadlineCounter  *prometheus.CounterVec
+	grpcClientConnStateGauge *prometheus.GaugeVec
+	sqlPrepareCounter        *prometheus.CounterVec
+	mysqlDeadlockCounter     *prometheus.CounterVec
+	mysqlSlowQueryCounter    *prometheus.CounterVec
+	mysqlQueryHistogram      *prometheus.HistogramVec
+
+	// the summaries are nil unless WithHandleSummaries is applied
+	serverHandleSummary *prometheus.SummaryVec
+	clientHandleSummary *prometheus.SummaryVec
+}
 
 var (
-	serverHandleHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
-		Name: "server_handle_seconds",
-		Help: "The duration of the server handle",
+	// currentMetricVecs are the goapm metrics registered to MetricsReg, which the instrumentations record to.
+	// They are replaced as a whole when the configuration changes, so that the recording never races with it.
+	currentMetricVecs atomic.Pointer[metricVecs]
+	// metricVecsMu serializes the replacements of currentMetricVecs.
+	metricVecsMu sync.Mutex
+)
+
+// goapmVecs returns the goapm metrics registered to MetricsReg.
+func goapmVecs() *metricVecs {
+	return currentMetricVecs.Load()
+}
+
+// defaultHandleSummaryObjectives are the p50, p90 and p99 with their allowed errors.
+var defaultHandleSummaryObjectives = map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001} //nolint:mnd
+
+// WithMetricNamespace prefixes the names of the goapm metrics with ns, e.g. "myorg" makes "myorg_server_handle_total".
+// The go and process runtime metrics keep their standard names.
+// The goapm metrics are recreated and the series recorded before are dropped,
+// e.g. the circuit_breaker_state set by NewCircuitBreaker, so it must be applied before any instrumentation is created,
+// i.e. WithAPM should be the first option of NewInfra.
+func WithMetricNamespace(ns string) ApmOption {
+	return func(_ *apmBuilder) {
+		setMetricNamespace(ns)
+	}
+}
+
+// WithHandleSummaries additionally exposes server_handle_summary and client_handle_summary alongside the histograms,
+// whose quantiles are computed per instance, objectives are the quantiles with their allowed errors,
+// default is p50, p90 and p99 if nil. It is opt-in since the summaries double the cardinality of the handle metrics.
+// Like WithMetricNamespace, it recreates the goapm metrics, so it must be applied before any instrumentation is created.
+func WithHandleSummaries(objectives map[float64]float64) ApmOption {
+	return func(_ *apmBuilder) {
+		if objectives == nil {
+			objectives = defaultHandleSummaryObjectives
+		}
+		recreateMetrics(func(cfg *metricConfig) {
+			cfg.summaryObjectives = objectives
+		})
+	}
+}
+
+func setMetricNamespace(ns string) {
+	ns = strings.TrimSuffix(ns, "_")
+	if ns == goapmVecs().namespace {
+		return
+	}
+	recreateMetrics(func(cfg *metricConfig) {
+		cfg.namespace = ns
+	})
+}
+
+// recreateMetrics creates the goapm metrics with the current configuration updated by update,
+// registers them to MetricsReg in place of the current ones, and then switches the instrumentations to them.
+func recreateMetrics(update func(cfg *metricConfig)) {
+	metricVecsMu.Lock()
+	defer metricVecsMu.Unlock()
+
+	var cfg metricConfig
+	if old := goapmVecs(); old != nil {
+		cfg = old.metricConfig
+		for _, c := range old.collectors() {
+			MetricsReg.Unregister(c)
+		}
+	}
+	update(&cfg)
+	m := newMetricVecs(cfg)
+	MetricsReg.MustRegister(m.collectors()...)
+	currentMetricVecs.Store(m)
+}
+
+// newMetricVecs creates the goapm metrics with the configuration.
+func newMetricVecs(cfg metricConfig) *metricVecs {
+	m := &metricVecs{metricConfig: cfg}
+
+	m.serverHandleHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: cfg.namespace,
+		Name:      "server_handle_seconds",
+		Help:      "The duration of the server handle",
 	}, []string{"type", "method", "status", "peer", "peer_host"})
 
-	serverHandleCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "server_handle_total",
-		Help: "The total number of server handle",
+	m.serverHandleCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: cfg.namespace,
+		Name:      "server_handle_total",
+		Help:      "The total number of server handle",
 	}, []string{"type", "method", "peer", "peer_host"})
 
-	clientHandleCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "client_handle_total",
-		Help: "The total number of client handle",
-	}, []string{"type", "method", "server"})
+	m.clientHandleCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: cfg.namespace,
+		Name:      "client_handle_total",
+		Help:      "The total number of client handle",
+	}, []string{"type", "method", "server", "status"})
 
-	clientHandleHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
-		Name: "client_handle_seconds",
-		Help: "The duration of the client handle",
-	}, []string{"type", "method", "server"})
+	m.clientHandleHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: cfg.namespace,
+		Name:      "client_handle_seconds",
+		Help:      "The duration of the client handle",
+	}, []string{"type", "method", "server", "status"})
 
-	libraryCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "lib_handle_total",
-		Help: "The total number of third party library handle",
+	m.libraryCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: cfg.namespace,
+		Name:      "lib_handle_total",
+		Help:      "The total number of third party library handle",
 	}, []string{"type", "method", "name", "server"})
-)
+
+	m.businessErrorCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: cfg.namespace,
+		Name:      "business_error_total",
+		Help:      "The total number of business errors",
+	}, []string{"code"})
+
+	m.panicRecoveredCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: cfg.namespace,
+		Name:      "panic_recovered_total",
+		Help:      "The total number of panics recovered",
+	}, []string{"type", "method"})
+
+	m.loadShedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: cfg.namespace,
+		Name:      "load_shed_total",
+		Help:      "The total number of requests shed because of exceeding the capacity",
+	}, []string{"type", "method"})
+
+	m.circuitBreakerStateGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: cfg.namespace,
+		Name:      "circuit_breaker_state",
+		Help:      "The state of the circuit breaker, 0 is closed, 1 is half-open and 2 is open",
+	}, []string{"name"})
+
+	m.clientNoDeadlineCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: cfg.namespace,
+		Name:      "client_no_deadline_total",
+		Help:      "The total number of client calls made without a context deadline",
+	}, []string{"type", "method", "server"})
+
+	m.grpcClientConnStateGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: cfg.namespace,
+		Name:      "grpc_client_connection_state",
+		Help: "The connectivity state of the grpc client, " +
+			"0 is idle, 1 is connecting, 2 is ready, 3 is transient failure and 4 is shutdown",
+	}, []string{"server"})
+
+	m.sqlPrepareCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: cfg.namespace,
+		Name:      "sql_prepare_total",
+		Help:      "The total number of the prepared sql statements",
+	}, []string{"table", "op"})
+
+	m.mysqlDeadlockCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: cfg.namespace,
+		Name:      "mysql_deadlock_total",
+		Help:      "The total number of the mysql deadlock errors",
+	}, []string{"table"})
+
+	m.mysqlSlowQueryCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: cfg.namespace,
+		Name:      "mysql_slow_query_total",
+		Help:      "The total number of the mysql queries slower than the slow sql threshold",
+	}, []string{"table", "op"})
+
+	m.mysqlQueryHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: cfg.namespace,
+		Name:      "mysql_query_duration_seconds",
+		Help:      "The duration of the succeeded mysql queries",
+	}, []string{"table", "op"})
+
+	if cfg.summaryObjectives != nil {
+		m.serverHandleSummary = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+			Namespace:  cfg.namespace,
+			Name:       "server_handle_summary",
+			Help:       "The duration quantiles of the server handle",
+			Objectives: cfg.summaryObjectives,
+		}, []string{"type", "method", "status", "peer", "peer_host"})
+
+		m.clientHandleSummary = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+			Namespace:  cfg.namespace,
+			Name:       "client_handle_summary",
+			Help:       "The duration quantiles of the client handle",
+			Objectives: cfg.summaryObjectives,
+		}, []string{"type", "method", "server", "status"})
+	}
+
+	return m
+}
+
+// collectors returns all the goapm metrics.
+func (m *metricVecs) collectors() []prometheus.Collector {
+	metrics := []prometheus.Collector{
+		m.serverHandleHistogram, m.serverHandleCounter, m.clientHandleCounter, m.clientHandleHistogram, m.libraryCounter,
+		m.businessErrorCounter, m.panicRecoveredCounter, m.loadShedCounter, m.circuitBreakerStateGauge,
+		m.clientNoDeadlineCounter, m.grpcClientConnStateGauge, m.sqlPrepareCounter, m.mysqlDeadlockCounter,
+		m.mysqlSlowQueryCounter, m.mysqlQueryHistogram,
+	}
+	if m.serverHandleSummary != nil {
+		metrics = append(metrics, m.serverHandleSummary, m.clientHandleSummary)
+	}
+	return metrics
+}
+
+// observeServerHandle observes the seconds of the server handle in the histogram, and the summary if enabled.
+// labels are type, method, status, peer and peer_host.
+func observeServerHandle(seconds float64, labels ...string) {
+	goapmVecs().observeServerHandle(seconds, labels...)
+}
+
+// observeServerHandle observes the seconds of the server handle in the metrics of m.
+func (m *metricVecs) observeServerHandle(seconds float64, labels ...string) {
+	m.serverHandleHistogram.WithLabelValues(labels...).Observe(seconds)
+	if m.serverHandleSummary != nil {
+		m.serverHandleSummary.WithLabelValues(labels...).Observe(seconds)
+	}
+}
+
+// observeClientHandle observes the seconds of the client handle in the histogram, and the summary if enabled.
+// labels are type, method, server and status.
+func observeClientHandle(seconds float64, labels ...string) {
+	m := goapmVecs()
+	m.clientHandleHistogram.WithLabelValues(labels...).Observe(seconds)
+	if m.clientHandleSummary != nil {
+		m.clientHandleSummary.WithLabelValues(labels...).Observe(seconds)
+	}
+}
+
+// MetricRegistry is the prometheus registry which the collectors are registered to and the metrics are gathered from.
+type MetricRegistry interface {
+	prometheus.Registerer
+	prometheus.Gatherer
+}
+
+// NewMetricRegistry creates a registry independent of MetricsReg, with the go runtime and process metrics,
+// its own goapm metrics, and the constant labels of MetricsReg plus labels.
+// Its goapm metrics are only recorded by the http servers and middlewares given the registry by WithMetricRegistry,
+// the other instrumentations, e.g. the grpc, sql and redis hooks, always record to MetricsReg.
+// It is useful to isolate the metrics of several infras in the same process, e.g. the tests run in parallel.
+func NewMetricRegistry(labels map[string]string) MetricRegistry {
+	reg := newCustomMetricRegistry(defaultMetricLabels())
+	reg.addCustomLabels(labels)
+	reg.MustRegister(runtimeMetrics()...)
+	reg.vecs = newMetricVecs(goapmVecs().metricConfig)
+	reg.MustRegister(reg.vecs.collectors()...)
+	return reg
+}
+
+// metricVecsOf returns the goapm metrics of the registry created by NewMetricRegistry, or nil for the other registries.
+func metricVecsOf(reg MetricRegistry) *metricVecs {
+	if c, ok := reg.(*customMetricRegistry); ok {
+		return c.vecs
+	}
+	return nil
+}
+
+// AddGlobalMetricLabels adds constant labels to all the metrics gathered from MetricsReg.
+// It is useful to distinguish the same binary deployed in different regions or clusters.
+// It should be called before the first scrape of /metrics,
+// labels added after scraping began may cause inconsistency in the time series.
+func AddGlobalMetricLabels(labels map[string]string) {
+	MetricsReg.addCustomLabels(labels)
+}
 
 // customMetricRegistry is a wrapper of prometheus.Registry.
 // it adds custom labels to the metrics
 type customMetricRegistry struct {
 	*prometheus.Registry
+	mu           sync.RWMutex
 	customLabels []*io_prometheus_client.LabelPair
+
+	// vecs are the goapm metrics of the registry created by NewMetricRegistry, it is nil for MetricsReg.
+	vecs *metricVecs
 }
 
 func newCustomMetricRegistry(labels map[string]string) *customMetricRegistry {
 	c := &customMetricRegistry{
 		Registry: prometheus.NewRegistry(),
 	}
+	c.addCustomLabels(labels)
+	return c
+}
+
+// addCustomLabels appends the labels to the custom labels,
+// the label with the same name would be overwritten.
+// The label pairs are never modified in place because they may still be referenced by gathered metrics.
+func (c *customMetricRegistry) addCustomLabels(labels map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
+	customLabels := make([]*io_prometheus_client.LabelPair, 0, len(c.customLabels)+len(labels))
+	for _, label := range c.customLabels {
+		if _, ok := labels[label.GetName()]; !ok {
+			customLabels = append(customLabels, label)
+		}
+	}
 	for k, v := range labels {
 		tmpK := k
 		tmpV := v
-		c.customLabels = append(c.customLabels, &io_prometheus_client.LabelPair{
+		customLabels = append(customLabels, &io_prometheus_client.LabelPair{
 			Name:  &tmpK,
 			Value: &tmpV,
 		})
 	}
-
-	return c
+	c.customLabels = customLabels
 }
 
 // Gather calls the Collect method of the registered Collectors and then
@@ -110,6 +431,9 @@ func newCustomMetricRegistry(labels map[string]string) *customMetricRegistry {
 // MetricFamily protobufs in case the returned error is non-nil.
 func (c *customMetricRegistry) Gather() ([]*io_prometheus_client.MetricFamily, error) {
 	metricFamilies, err := c.Registry.Gather()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	for _, mf := range metricFamilies {
 		metrics := mf.Metric
 		for _, metric := range metrics {
@@ -0,0 +1,105 @@
+package apm
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	io_prometheus_client "github.com/prometheus/client_model/go"
+)
+
+// pushGatewayGroupingLabels are the constant labels used as the grouping labels of the pushgateway,
+// so that the metrics of different instances of the job do not overwrite each other.
+var pushGatewayGroupingLabels = []string{"host", "app"}
+
+// GatherMetrics gathers the metrics from MetricsReg with the custom labels,
+// it is useful to report the metrics to a system other than prometheus.
+func GatherMetrics() ([]*io_prometheus_client.MetricFamily, error) {
+	return MetricsReg.Gather()
+}
+
+// RegisterMetricPusher gathers the metrics every interval and pushes them to fn in a new goroutine,
+// the metrics are skipped if the gathering fails.
+// The returned stop function stops the pusher after pushing the metrics for the last time,
+// so that the metrics of a short-lived process are not lost.
+// If interval <= 0, the metrics are only pushed when the pusher stops.
+func RegisterMetricPusher(interval time.Duration, fn func([]*io_prometheus_client.MetricFamily)) (stop func()) {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		// a nil channel never fires, so that only the final push is made
+		var tick <-chan time.Time
+		if interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			select {
+			case <-tick:
+				pushMetrics(fn)
+			case <-done:
+				pushMetrics(fn)
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-exited
+		})
+	}
+}
+
+func pushMetrics(fn func([]*io_prometheus_client.MetricFamily)) {
+	mfs, err := GatherMetrics()
+	if err != nil {
+		Logger.Warn(context.TODO(), "failed to gather goapm metrics", map[string]any{"err": err.Error()})
+		return
+	}
+	fn(mfs)
+}
+
+// PushToGateway pushes the metrics gathered from MetricsReg to the prometheus pushgateway at url,
+// it is for the short-lived jobs which exit before being scraped.
+// The host and app labels are used as the grouping labels, and the metrics of the group are replaced.
+func PushToGateway(url, job string) error {
+	mfs, err := GatherMetrics()
+	if err != nil {
+		return err
+	}
+	return PushMetricsToGateway(url, job, mfs)
+}
+
+// PushMetricsToGateway is like PushToGateway but pushes the metrics gathered already,
+// e.g. in the callback of RegisterMetricPusher.
+func PushMetricsToGateway(url, job string, mfs []*io_prometheus_client.MetricFamily) error {
+	grouping := make(map[string]string, len(pushGatewayGroupingLabels))
+	for _, mf := range mfs {
+		// the pushgateway rejects the metrics containing the grouping labels, so they are moved out
+		for _, m := range mf.Metric {
+			m.Label = slices.DeleteFunc(m.Label, func(l *io_prometheus_client.LabelPair) bool {
+				if !slices.Contains(pushGatewayGroupingLabels, l.GetName()) {
+					return false
+				}
+				grouping[l.GetName()] = l.GetValue()
+				return true
+			})
+		}
+	}
+
+	pusher := push.New(url, job).Gatherer(prometheus.GathererFunc(func() ([]*io_prometheus_client.MetricFamily, error) {
+		return mfs, nil
+	}))
+	for name, value := range grouping {
+		pusher.Grouping(name, value)
+	}
+	return pusher.Push()
+}
@@ -0,0 +1,73 @@
+package apm
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	io_prometheus_client "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hedon954/goapm/internal"
+)
+
+func TestRegisterMetricPusher(t *testing.T) {
+	var pushes atomic.Int32
+	var found atomic.Bool
+	stop := RegisterMetricPusher(10*time.Millisecond, func(mfs []*io_prometheus_client.MetricFamily) {
+		pushes.Add(1)
+		for _, mf := range mfs {
+			if mf.GetName() == "go_goroutines" {
+				found.Store(true)
+			}
+		}
+	})
+
+	assert.Eventually(t, func() bool { return pushes.Load() >= 2 }, time.Second, 5*time.Millisecond)
+	stop()
+	stop()
+	n := pushes.Load()
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, n, pushes.Load(), "no push after stop")
+	assert.True(t, found.Load())
+}
+
+func TestRegisterMetricPusher_NonPositiveInterval(t *testing.T) {
+	var pushes atomic.Int32
+	stop := RegisterMetricPusher(0, func(mfs []*io_prometheus_client.MetricFamily) {
+		pushes.Add(1)
+	})
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, int32(0), pushes.Load())
+	stop()
+	assert.Equal(t, int32(1), pushes.Load(), "push only on stop")
+}
+
+func TestPushToGateway(t *testing.T) {
+	var method, path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		method, path, body = r.Method, r.URL.Path, string(b)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	assert.Nil(t, PushToGateway(server.URL, "batch"))
+	assert.Equal(t, http.MethodPut, method)
+	assert.True(t, strings.HasPrefix(path, "/metrics/job/batch/"))
+	assert.Contains(t, path, "/host/"+internal.BuildInfo.Hostname())
+	assert.Contains(t, path, "/app/")
+	assert.NotEmpty(t, body)
+
+	t.Run("pushgateway error should be returned", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer server.Close()
+		assert.NotNil(t, PushToGateway(server.URL, "batch"))
+	})
+}
@@ -0,0 +1,217 @@
+package apm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCustomMetricRegistry_AddCustomLabels(t *testing.T) {
+	reg := newCustomMetricRegistry(map[string]string{"app": "test"})
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_custom_labels_total"})
+	reg.MustRegister(counter)
+	counter.Inc()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			reg.addCustomLabels(map[string]string{"region": "eu", "cluster": "c1"})
+		}()
+		go func() {
+			defer wg.Done()
+			_, _ = reg.Gather()
+		}()
+	}
+	wg.Wait()
+	reg.addCustomLabels(map[string]string{"app": "overwritten"})
+
+	mfs, err := reg.Gather()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(mfs))
+
+	labels := make(map[string]string)
+	for _, label := range mfs[0].Metric[0].Label {
+		labels[label.GetName()] = label.GetValue()
+	}
+	assert.Equal(t, map[string]string{"app": "overwritten", "region": "eu", "cluster": "c1"}, labels)
+}
+
+func TestWithMetricNamespace(t *testing.T) {
+	defer setMetricNamespace("")
+	WithMetricNamespace("myorg_")(&apmBuilder{})
+
+	goapmVecs().serverHandleCounter.WithLabelValues(MetricTypeHTTP, "GET /namespace", "", "").Inc()
+	mfs, err := MetricsReg.Gather()
+	assert.Nil(t, err)
+	names := make(map[string]bool, len(mfs))
+	for _, mf := range mfs {
+		names[mf.GetName()] = true
+	}
+	assert.True(t, names["myorg_server_handle_total"])
+	assert.False(t, names["server_handle_total"])
+	assert.True(t, names["go_goroutines"])
+
+	desc := make(chan *prometheus.Desc, 5)
+	NewDBStatsCollector("test", nil).Describe(desc)
+	assert.Contains(t, (<-desc).String(), `fqName: "myorg_db_open_connections"`)
+
+	setMetricNamespace("")
+	goapmVecs().serverHandleCounter.WithLabelValues(MetricTypeHTTP, "GET /namespace", "", "").Inc()
+	mfs, err = MetricsReg.Gather()
+	assert.Nil(t, err)
+	names = make(map[string]bool, len(mfs))
+	for _, mf := range mfs {
+		names[mf.GetName()] = true
+	}
+	assert.True(t, names["server_handle_total"])
+	assert.False(t, names["myorg_server_handle_total"])
+}
+
+func TestWithHandleSummaries(t *testing.T) {
+	defer recreateMetrics(func(cfg *metricConfig) {
+		cfg.summaryObjectives = nil
+	})
+	WithHandleSummaries(nil)(&apmBuilder{})
+
+	observeServerHandle(0.1, MetricTypeHTTP, "GET /summary", "200", "", "")
+	observeClientHandle(0.2, MetricTypeHTTP, "GET", "summary", "200")
+	mfs, err := MetricsReg.Gather()
+	assert.Nil(t, err)
+	summaries := make(map[string]int)
+	for _, mf := range mfs {
+		if mf.GetName() == "server_handle_summary" || mf.GetName() == "client_handle_summary" {
+			summaries[mf.GetName()] = len(mf.Metric[0].GetSummary().GetQuantile())
+		}
+	}
+	assert.Equal(t, map[string]int{"server_handle_summary": 3, "client_handle_summary": 3}, summaries)
+}
+
+func TestRecreateMetrics_Concurrent(t *testing.T) {
+	defer recreateMetrics(func(cfg *metricConfig) {
+		cfg.namespace, cfg.summaryObjectives = "", nil
+	})
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					goapmVecs().serverHandleCounter.WithLabelValues(MetricTypeHTTP, "GET /concurrent", "", "").Inc()
+					observeServerHandle(0.1, MetricTypeHTTP, "GET /concurrent", "200", "", "")
+				}
+			}
+		}()
+	}
+	WithMetricNamespace("concurrent")(&apmBuilder{})
+	WithHandleSummaries(nil)(&apmBuilder{})
+	close(done)
+	wg.Wait()
+
+	assert.Equal(t, "concurrent", goapmVecs().namespace)
+	assert.NotNil(t, goapmVecs().serverHandleSummary)
+}
+
+func TestDBStatsCollector(t *testing.T) {
+	db, err := NewMySQL("test", "root:root@tcp(127.0.0.1:3306)/goapm")
+	assert.Nil(t, err)
+	defer db.Close()
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewDBStatsCollector("test", db))
+	mfs, err := reg.Gather()
+	assert.Nil(t, err)
+
+	names := make([]string, 0, len(mfs))
+	for _, mf := range mfs {
+		names = append(names, mf.GetName())
+		assert.Equal(t, "name", mf.Metric[0].Label[0].GetName())
+		assert.Equal(t, "test", mf.Metric[0].Label[0].GetValue())
+	}
+	assert.ElementsMatch(t, []string{
+		"db_open_connections", "db_in_use", "db_idle", "db_wait_count", "db_wait_duration_seconds",
+	}, names)
+}
+
+func TestResetMetricRegistry(t *testing.T) {
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_reset_registry_total"})
+	ResetMetricRegistry()
+	assert.Nil(t, MetricsReg.Register(counter))
+	assert.IsType(t, prometheus.AlreadyRegisteredError{}, MetricsReg.Register(counter))
+
+	ResetMetricRegistry()
+	assert.Nil(t, MetricsReg.Register(counter))
+
+	goapmVecs().serverHandleCounter.WithLabelValues(MetricTypeHTTP, "GET /reset", "", "").Inc()
+	mfs, err := MetricsReg.Gather()
+	assert.Nil(t, err)
+	names := make(map[string]bool, len(mfs))
+	for _, mf := range mfs {
+		names[mf.GetName()] = true
+	}
+	assert.True(t, names["server_handle_total"])
+	assert.True(t, names["go_goroutines"])
+	assert.True(t, names["test_reset_registry_total"])
+}
+
+func TestNewMetricRegistry(t *testing.T) {
+	reg := NewMetricRegistry(map[string]string{"tenant": "t1"})
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_isolated_registry_total"})
+	assert.Nil(t, reg.Register(counter))
+	assert.Nil(t, NewMetricRegistry(nil).Register(counter))
+	counter.Inc()
+
+	mfs, err := reg.Gather()
+	assert.Nil(t, err)
+	labels := make(map[string]string)
+	names := make(map[string]bool, len(mfs))
+	for _, mf := range mfs {
+		names[mf.GetName()] = true
+		if mf.GetName() == "test_isolated_registry_total" {
+			for _, label := range mf.Metric[0].Label {
+				labels[label.GetName()] = label.GetValue()
+			}
+		}
+	}
+	assert.True(t, names["go_goroutines"])
+	assert.False(t, names["server_handle_total"])
+	assert.Equal(t, "t1", labels["tenant"])
+	assert.NotEmpty(t, labels["host"])
+
+	mfs, err = MetricsReg.Gather()
+	assert.Nil(t, err)
+	for _, mf := range mfs {
+		assert.NotEqual(t, "test_isolated_registry_total", mf.GetName())
+	}
+}
+
+func TestWithMetricRegistry_HTTPMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	reg := NewMetricRegistry(nil)
+	r := gin.New()
+	r.Use(GinOtel(WithMetricRegistry(reg)))
+	r.GET("/isolated", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	global := goapmVecs().serverHandleCounter.WithLabelValues(MetricTypeHTTP, "GET./isolated", "", "")
+	before := testutil.ToFloat64(global)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/isolated", nil))
+
+	isolated := metricVecsOf(reg).serverHandleCounter.WithLabelValues(MetricTypeHTTP, "GET./isolated", "", "")
+	assert.Equal(t, float64(1), testutil.ToFloat64(isolated))
+	assert.Equal(t, before, testutil.ToFloat64(global))
+}
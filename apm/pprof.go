@@ -1,15 +1,38 @@
 package apm
 
 import (
+	"bytes"
 	"context"
-	"errors"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"strings"
 	"time"
 
 	"github.com/google/gops/agent"
 	"mosn.io/holmes"
+
+	"github.com/hedon954/goapm/internal"
+)
+
+// the default trigger thresholds of holmes, see mosn.io/holmes/consts.go.
+const (
+	holmesDefaultCPUTriggerMin        = 10
+	holmesDefaultCPUTriggerDiff       = 25
+	holmesDefaultCPUTriggerAbs        = 70
+	holmesDefaultMemTriggerMin        = 10
+	holmesDefaultMemTriggerDiff       = 25
+	holmesDefaultMemTriggerAbs        = 80
+	holmesDefaultGoroutineTriggerMin  = 3000
+	holmesDefaultGoroutineTriggerDiff = 20
+	holmesDefaultGoroutineTriggerAbs  = 200000
+	holmesDefaultCooldown             = time.Minute
+	holmesDefaultGoroutineCooldown    = 10 * time.Minute
 )
 
 // AutoPProfOpt is the options for auto pprof.
+// The zero-valued thresholds mean using the holmes defaults.
 type AutoPProfOpt struct {
 	// EnableCPU enables cpu pprof.
 	EnableCPU bool
@@ -17,14 +40,135 @@ type AutoPProfOpt struct {
 	EnableMem bool
 	// EnableGoroutine enables goroutine pprof.
 	EnableGoroutine bool
+
+	// CPUTriggerPercent dumps the cpu profile when the cpu usage exceeds it, holmes default is 70.
+	CPUTriggerPercent int
+	// MemTriggerPercent dumps the mem profile when the memory usage exceeds it, holmes default is 80.
+	MemTriggerPercent int
+	// GoroutineTriggerNum dumps the goroutine profile when the number of goroutines exceeds it, holmes default is 200000.
+	GoroutineTriggerNum int
+	// CooldownSeconds is the minimum interval between two dumps of the same type,
+	// holmes default is 60 for cpu and mem, and 600 for goroutine.
+	CooldownSeconds int
+
+	// OnDump is called after a profile is dumped, e.g. to fire an alert since the process is probably in trouble.
+	OnDump ProfileDumpCallback
+	// Uploader uploads the dumped profiles, see WithProfileUploader for the key of the profile.
+	Uploader ProfileUploader
 }
 
-type autoPProfReporter struct{}
+// ProfileDumpCallback is called after holmes dumps a profile.
+type ProfileDumpCallback func(pType string, reason holmes.ReasonType, eventID string)
+
+// holmesOptions converts the thresholds to holmes options, the zero-valued thresholds are skipped.
+func (o *AutoPProfOpt) holmesOptions() []holmes.Option {
+	if o == nil {
+		return nil
+	}
+
+	cooldown := func(def time.Duration) time.Duration {
+		if o.CooldownSeconds > 0 {
+			return time.Duration(o.CooldownSeconds) * time.Second
+		}
+		return def
+	}
+	orDefault := func(v, def int) int {
+		if v > 0 {
+			return v
+		}
+		return def
+	}
+
+	var opts []holmes.Option
+	if o.CPUTriggerPercent > 0 || o.CooldownSeconds > 0 {
+		opts = append(opts, holmes.WithCPUDump(
+			holmesDefaultCPUTriggerMin, holmesDefaultCPUTriggerDiff,
+			orDefault(o.CPUTriggerPercent, holmesDefaultCPUTriggerAbs), cooldown(holmesDefaultCooldown),
+		))
+	}
+	if o.MemTriggerPercent > 0 || o.CooldownSeconds > 0 {
+		opts = append(opts, holmes.WithMemDump(
+			holmesDefaultMemTriggerMin, holmesDefaultMemTriggerDiff,
+			orDefault(o.MemTriggerPercent, holmesDefaultMemTriggerAbs), cooldown(holmesDefaultCooldown),
+		))
+	}
+	if o.GoroutineTriggerNum > 0 || o.CooldownSeconds > 0 {
+		opts = append(opts, holmes.WithGoroutineDump(
+			holmesDefaultGoroutineTriggerMin, holmesDefaultGoroutineTriggerDiff,
+			orDefault(o.GoroutineTriggerNum, holmesDefaultGoroutineTriggerAbs), 0,
+			cooldown(holmesDefaultGoroutineCooldown),
+		))
+	}
+	return opts
+}
+
+// profileUploadTimeout is the timeout of uploading a profile.
+const profileUploadTimeout = 30 * time.Second
+
+// ProfileUploader uploads the profiles dumped by holmes to somewhere out of the local disk,
+// so that the profiles would not be lost when the pod restarts.
+type ProfileUploader interface {
+	// Upload uploads the profile data with the given key.
+	Upload(ctx context.Context, key string, data []byte) error
+}
+
+// WithProfileUploader returns a holmes option which uploads the dumped profiles by the uploader,
+// the key of the profile is "<app>/<host>/<pType>/<timestamp>.pprof".
+// It replaces the reporter of NewHomes, so the OnDump of AutoPProfOpt is not called,
+// set AutoPProfOpt.Uploader instead to have both.
+func WithProfileUploader(u ProfileUploader) holmes.Option {
+	return holmes.WithProfileReporter(&autoPProfReporter{uploader: u})
+}
+
+// objectStorageUploader uploads the profiles to an object storage by http PUT.
+type objectStorageUploader struct {
+	endpoint string
+	headers  map[string]string
+	client   *http.Client
+}
+
+// NewObjectStorageUploader creates a ProfileUploader which puts the profile to "<endpoint>/<key>",
+// it works with the object storages providing S3/GCS-style PUT object api, e.g. a bucket url.
+// headers are set on each request, e.g. the Authorization header.
+func NewObjectStorageUploader(endpoint string, headers map[string]string) ProfileUploader {
+	return &objectStorageUploader{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		headers:  headers,
+		client:   &http.Client{Timeout: profileUploadTimeout},
+	}
+}
+
+// Upload puts the profile data to the object storage.
+func (u *objectStorageUploader) Upload(ctx context.Context, key string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.endpoint+"/"+key, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	for k, v := range u.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("upload profile failed, status: %s", resp.Status)
+	}
+	return nil
+}
+
+type autoPProfReporter struct {
+	uploader ProfileUploader
+	onDump   ProfileDumpCallback
+}
 
 func (a *autoPProfReporter) Report(
 	pType string, filename string, reason holmes.ReasonType, eventID string, sampleTime time.Time, pprofBytes []byte,
 	scene holmes.Scene) error {
-	Logger.Error(context.TODO(), "homesGen", errors.New("auto record running state failed"),
+	Logger.Warn(context.TODO(), "auto pprof profile dumped",
 		map[string]any{
 			"pType":       pType,
 			"filename":    filename,
@@ -34,9 +178,29 @@ func (a *autoPProfReporter) Report(
 			"scene":       scene,
 		},
 	)
+
+	if a.onDump != nil {
+		a.onDump(pType, reason, eventID)
+	}
+
+	if a.uploader != nil {
+		key := profileKey(pType, sampleTime)
+		ctx, cancel := context.WithTimeout(context.Background(), profileUploadTimeout)
+		defer cancel()
+		if err := a.uploader.Upload(ctx, key, pprofBytes); err != nil {
+			Logger.Error(ctx, "upload auto pprof profile failed", err, map[string]any{"key": key})
+			return err
+		}
+	}
 	return nil
 }
 
+// profileKey returns the key of the profile in the form of "<app>/<host>/<pType>/<timestamp>.pprof".
+func profileKey(pType string, sampleTime time.Time) string {
+	return fmt.Sprintf("%s/%s/%s/%s.pprof",
+		internal.BuildInfo.AppName(), internal.BuildInfo.Hostname(), pType, sampleTime.Format("20060102150405"))
+}
+
 // NewHomes creates a holmes dumper.
 func NewHomes(autoPProfOpts *AutoPProfOpt, opts ...holmes.Option) (*holmes.Holmes, error) {
 	if err := agent.Listen(agent.Options{
@@ -45,7 +209,15 @@ func NewHomes(autoPProfOpts *AutoPProfOpt, opts ...holmes.Option) (*holmes.Holme
 		return nil, err
 	}
 
-	h, err := holmes.New(append(opts, holmes.WithProfileReporter(&autoPProfReporter{}))...)
+	reporter := &autoPProfReporter{}
+	if autoPProfOpts != nil {
+		reporter.uploader, reporter.onDump = autoPProfOpts.Uploader, autoPProfOpts.OnDump
+	}
+
+	// the raw holmes options take precedence over the thresholds in autoPProfOpts and the default reporter,
+	// e.g. the reporter set by WithProfileUploader.
+	opts = append(append(autoPProfOpts.holmesOptions(), holmes.WithProfileReporter(reporter)), opts...)
+	h, err := holmes.New(opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -63,3 +235,38 @@ func NewHomes(autoPProfOpts *AutoPProfOpt, opts ...holmes.Option) (*holmes.Holme
 	}
 	return h, nil
 }
+
+// BasicAuth is the credentials of the http basic auth.
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+// wrap protects the handler with the basic auth, it returns the handler directly if auth is nil.
+func (auth *BasicAuth) wrap(handler http.Handler) http.Handler {
+	if auth == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(username), []byte(auth.Username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(auth.Password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="goapm"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// RegisterPprofHandlers registers the net/http/pprof handlers under /debug/pprof/ on the http server.
+// If auth is not nil, the handlers are protected by the basic auth.
+// The handlers are not traced since a profile request could last for a long time.
+func (s *HTTPServer) RegisterPprofHandlers(auth *BasicAuth) {
+	s.mux.Handle("/debug/pprof/", auth.wrap(http.HandlerFunc(pprof.Index)))
+	s.mux.Handle("/debug/pprof/cmdline", auth.wrap(http.HandlerFunc(pprof.Cmdline)))
+	s.mux.Handle("/debug/pprof/profile", auth.wrap(http.HandlerFunc(pprof.Profile)))
+	s.mux.Handle("/debug/pprof/symbol", auth.wrap(http.HandlerFunc(pprof.Symbol)))
+	s.mux.Handle("/debug/pprof/trace", auth.wrap(http.HandlerFunc(pprof.Trace)))
+}
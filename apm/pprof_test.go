@@ -0,0 +1,66 @@
+package apm
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"mosn.io/holmes"
+)
+
+func TestAutoPProfReporter_Upload(t *testing.T) {
+	var (
+		gotPath   string
+		gotBody   string
+		gotHeader string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		body, _ := io.ReadAll(r.Body)
+		gotPath, gotBody, gotHeader = r.URL.Path, string(body), r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	reporter := &autoPProfReporter{
+		uploader: NewObjectStorageUploader(server.URL+"/bucket/", map[string]string{"Authorization": "Bearer token"}),
+	}
+	sampleTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	err := reporter.Report("cpu", "cpu.bin", holmes.ReasonCurGreaterAbs, "", sampleTime, []byte("profile"), holmes.Scene{})
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(gotPath, "/bucket/"))
+	assert.True(t, strings.HasSuffix(gotPath, "/cpu/20240102030405.pprof"))
+	assert.Equal(t, "profile", gotBody)
+	assert.Equal(t, "Bearer token", gotHeader)
+}
+
+func TestObjectStorageUploader_Failed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	err := NewObjectStorageUploader(server.URL, nil).Upload(context.Background(), "key", []byte("profile"))
+	assert.NotNil(t, err)
+}
+
+func TestAutoPProfReporter_OnDump(t *testing.T) {
+	var (
+		gotType   string
+		gotReason holmes.ReasonType
+		gotID     string
+	)
+	reporter := &autoPProfReporter{onDump: func(pType string, reason holmes.ReasonType, eventID string) {
+		gotType, gotReason, gotID = pType, reason, eventID
+	}}
+	err := reporter.Report("mem", "mem.bin", holmes.ReasonCurGreaterAbs, "event", time.Now(), nil, holmes.Scene{})
+	assert.Nil(t, err)
+	assert.Equal(t, "mem", gotType)
+	assert.Equal(t, holmes.ReasonCurGreaterAbs, gotReason)
+	assert.Equal(t, "event", gotID)
+}
@@ -0,0 +1,145 @@
+package apm
+
+import (
+	"database/sql"
+	"errors"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	redisv6 "github.com/go-redis/redis"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultMaxStackTraceLines = 10
+
+	goapmPackagePrefix = "github.com/hedon954/goapm/"
+)
+
+// maxStackTraceLines is the max number of frames in the stack trace recorded with the errors.
+var maxStackTraceLines = defaultMaxStackTraceLines
+
+// stackTraceOnError reports whether the stack trace is recorded with the errors.
+var stackTraceOnError = true
+
+// SetMaxStackTraceLines sets the max number of frames in the stack trace recorded with the errors, default is 10.
+func SetMaxStackTraceLines(n int) {
+	maxStackTraceLines = n
+}
+
+// SetStackTraceOnError sets whether the stack trace is recorded with the errors, default is true.
+// Capturing the stack is expensive when the errors are frequent, e.g. a downstream is down,
+// disabling it keeps only the type and the message of the errors.
+func SetStackTraceOnError(enable bool) {
+	stackTraceOnError = enable
+}
+
+type recordErrorOptions struct {
+	maxStackTraceLines int
+	stackTrace         bool
+}
+
+// RecordErrorOption is the option for RecordError.
+type RecordErrorOption func(o *recordErrorOptions)
+
+// WithMaxStackTraceLines overrides the max number of frames set by SetMaxStackTraceLines for a single error.
+func WithMaxStackTraceLines(n int) RecordErrorOption {
+	return func(o *recordErrorOptions) {
+		o.maxStackTraceLines = n
+	}
+}
+
+// WithStackTraceOnError overrides whether the stack trace is recorded set by SetStackTraceOnError for a single error.
+func WithStackTraceOnError(enable bool) RecordErrorOption {
+	return func(o *recordErrorOptions) {
+		o.stackTrace = enable
+	}
+}
+
+// RecordError records the err as an exception event of the span with the stack trace.
+// Unlike trace.WithStackTrace, the goapm-internal and runtime frames are filtered out,
+// so that the stack trace starts at the business code.
+func RecordError(span trace.Span, err error, opts ...RecordErrorOption) {
+	o := &recordErrorOptions{maxStackTraceLines: maxStackTraceLines, stackTrace: stackTraceOnError}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if !o.stackTrace {
+		span.RecordError(err, trace.WithTimestamp(time.Now()))
+		return
+	}
+	span.RecordError(err,
+		trace.WithTimestamp(time.Now()),
+		trace.WithAttributes(semconv.ExceptionStacktrace(stackTrace(o.maxStackTraceLines))),
+	)
+}
+
+// stackTrace returns at most maxLines frames of the current goroutine without the goapm-internal and runtime frames.
+func stackTrace(maxLines int) string {
+	pcs := make([]uintptr, 64) //nolint:mnd
+	n := runtime.Callers(1, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var sb strings.Builder
+	lines := 0
+	for lines < maxLines {
+		frame, more := frames.Next()
+		if !isInternalFrame(frame.Function) {
+			sb.WriteString(frame.Function)
+			sb.WriteString("\n\t")
+			sb.WriteString(frame.File)
+			sb.WriteString(":")
+			sb.WriteString(strconv.Itoa(frame.Line))
+			sb.WriteString("\n")
+			lines++
+		}
+		if !more {
+			break
+		}
+	}
+	return sb.String()
+}
+
+func isInternalFrame(function string) bool {
+	return strings.HasPrefix(function, goapmPackagePrefix) ||
+		strings.HasPrefix(function, "runtime.") ||
+		strings.HasPrefix(function, "runtime/")
+}
+
+// spanErrorFilter reports whether the error is expected and should not mark the span as errored.
+var spanErrorFilter = DefaultSpanErrorFilter
+
+// SetSpanErrorFilter sets the filter consulted by the sql, gorm, redis and grpc instrumentations
+// before marking the span as errored, the filter should return true for the expected errors,
+// e.g. gRPC NotFound. It replaces DefaultSpanErrorFilter, so call it in the filter to keep the defaults.
+// A nil filter means recording all the errors.
+func SetSpanErrorFilter(filter func(err error) bool) {
+	spanErrorFilter = filter
+}
+
+// DefaultSpanErrorFilter ignores the errors of the expected business flows:
+// sql.ErrNoRows, gorm.ErrRecordNotFound and redis.Nil of both redis v6 and v9.
+func DefaultSpanErrorFilter(err error) bool {
+	return errors.Is(err, sql.ErrNoRows) ||
+		errors.Is(err, gorm.ErrRecordNotFound) ||
+		errors.Is(err, redis.Nil) ||
+		errors.Is(err, redisv6.Nil)
+}
+
+// setSpanError marks the span as errored and records the err, unless the err is expected.
+// It returns whether the span is marked.
+func setSpanError(span trace.Span, err error) bool {
+	if err == nil || (spanErrorFilter != nil && spanErrorFilter(err)) {
+		return false
+	}
+	span.SetAttributes(attribute.Bool("error", true))
+	RecordError(span, err)
+	return true
+}
@@ -0,0 +1,93 @@
+package apm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRecordError(t *testing.T) {
+	exporter := setupTracingTest()
+
+	stacktraceOf := func(opts ...RecordErrorOption) string {
+		exporter.Reset()
+		_, span := otel.Tracer("test").Start(context.Background(), "test")
+		RecordError(span, errors.New("test"), opts...)
+		span.End()
+
+		for _, attr := range exporter.GetSpans()[0].Events[0].Attributes {
+			if attr.Key == semconv.ExceptionStacktraceKey {
+				return attr.Value.AsString()
+			}
+		}
+		return ""
+	}
+
+	t.Run("internal frames should be filtered", func(t *testing.T) {
+		stack := stacktraceOf()
+		assert.Contains(t, stack, "testing.tRunner")
+		assert.NotContains(t, stack, goapmPackagePrefix)
+		assert.NotContains(t, stack, "runtime.")
+	})
+
+	t.Run("max lines should be configurable", func(t *testing.T) {
+		countFrames := func(stack string) int {
+			return strings.Count(stack, "\n\t")
+		}
+
+		SetMaxStackTraceLines(1)
+		defer SetMaxStackTraceLines(defaultMaxStackTraceLines)
+		assert.Equal(t, 1, countFrames(stacktraceOf()))
+		assert.Equal(t, 0, countFrames(stacktraceOf(WithMaxStackTraceLines(0))))
+	})
+
+	t.Run("stack trace could be disabled", func(t *testing.T) {
+		SetStackTraceOnError(false)
+		defer SetStackTraceOnError(true)
+		assert.Empty(t, stacktraceOf())
+		assert.NotEmpty(t, stacktraceOf(WithStackTraceOnError(true)))
+		assert.Empty(t, stacktraceOf(WithStackTraceOnError(false)))
+		assert.Contains(t, exporter.GetSpans()[0].Events[0].Attributes, semconv.ExceptionMessage("test"))
+	})
+}
+
+func TestSetSpanErrorFilter(t *testing.T) {
+	exporter := setupTracingTest()
+
+	isErrored := func(err error) bool {
+		exporter.Reset()
+		_, span := otel.Tracer("test").Start(context.Background(), "test")
+		setSpanError(span, err)
+		span.End()
+		return len(exporter.GetSpans()[0].Events) == 1
+	}
+
+	t.Run("expected errors should be ignored by default", func(t *testing.T) {
+		assert.False(t, isErrored(sql.ErrNoRows))
+		assert.False(t, isErrored(fmt.Errorf("wrapped: %w", redis.Nil)))
+		assert.True(t, isErrored(errors.New("test")))
+	})
+
+	t.Run("custom filter should be consulted", func(t *testing.T) {
+		defer SetSpanErrorFilter(DefaultSpanErrorFilter)
+		SetSpanErrorFilter(func(err error) bool {
+			return DefaultSpanErrorFilter(err) || status.Code(err) == codes.NotFound
+		})
+		assert.False(t, isErrored(status.Error(codes.NotFound, "not found")))
+		assert.False(t, isErrored(redis.Nil))
+		assert.True(t, isErrored(status.Error(codes.Internal, "internal")))
+
+		SetSpanErrorFilter(nil)
+		assert.True(t, isErrored(redis.Nil))
+	})
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -16,11 +18,61 @@ const (
 	redisTracerName = "goapm/redisV9"
 )
 
+// redisKeylessCommands are the commands whose first arg is not a key.
+var redisKeylessCommands = map[string]bool{
+	"ping": true, "echo": true, "auth": true, "hello": true, "select": true, "info": true, "config": true,
+	"client": true, "command": true, "cluster": true, "script": true, "eval": true, "evalsha": true,
+	"eval_ro": true, "evalsha_ro": true,
+	"publish": true, "subscribe": true, "psubscribe": true, "unsubscribe": true, "punsubscribe": true,
+	"scan": true, "keys": true, "multi": true, "exec": true, "discard": true,
+}
+
+// redisScriptCommands are the commands whose args carry the script body or the script args, which are never recorded.
+var redisScriptCommands = map[string]bool{
+	"script": true, "eval": true, "evalsha": true, "eval_ro": true, "evalsha_ro": true,
+}
+
+// redisOptions is the options of the redis clients.
+type redisOptions struct {
+	captureKeys bool
+	// disabled turns off the tracing of the client, see WithRedisDisabled.
+	disabled bool
+}
+
+// RedisOption is the option for NewRedisV6, NewRedisV9 and NewRedisClusterV9.
+type RedisOption func(o *redisOptions)
+
+// WithRedisKeyCapture records the key of the command as "redis.key" instead of the whole command,
+// the "cmd" attribute is then the command name only, so that the values are never recorded.
+// Only the first key is recorded for the multi-key commands.
+func WithRedisKeyCapture(enable bool) RedisOption {
+	return func(o *redisOptions) {
+		o.captureKeys = enable
+	}
+}
+
+// WithRedisDisabled turns off the tracing of the client like Disable, but only for this client.
+func WithRedisDisabled(disabled bool) RedisOption {
+	return func(o *redisOptions) {
+		o.disabled = disabled
+	}
+}
+
+func newRedisOptions(opts []RedisOption) *redisOptions {
+	o := &redisOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
 // NewRedisV9 creates a new redis client with tracing.
 // name is the business name of the redis client, it will be used in the span name.
-func NewRedisV9(name string, opts *redis.Options) (*redis.Client, error) {
+func NewRedisV9(name string, opts *redis.Options, redisOpts ...RedisOption) (*redis.Client, error) {
 	client := redis.NewClient(opts)
-	client.AddHook(&redisHook{name})
+	if o := newRedisOptions(redisOpts); !o.disabled {
+		client.AddHook(newRedisHook(name, o))
+	}
 
 	res, err := client.Ping(context.Background()).Result()
 	if err != nil {
@@ -34,8 +86,40 @@ func NewRedisV9(name string, opts *redis.Options) (*redis.Client, error) {
 	return client, nil
 }
 
+// NewRedisClusterV9 creates a new redis cluster client with tracing.
+// name is the business name of the redis cluster client, it will be used in the span name.
+func NewRedisClusterV9(name string, opts *redis.ClusterOptions, redisOpts ...RedisOption) (*redis.ClusterClient, error) {
+	client := redis.NewClusterClient(opts)
+	if o := newRedisOptions(redisOpts); !o.disabled {
+		client.AddHook(newRedisHook(name, o))
+	}
+
+	res, err := client.Ping(context.Background()).Result()
+	if err != nil {
+		return nil, err
+	}
+	if res != "PONG" {
+		return nil, fmt.Errorf("redis cluster ping failed: %s", res)
+	}
+
+	Logger.Info(context.TODO(), fmt.Sprintf("redis v9 cluster client[%s] connected", name), nil)
+	return client, nil
+}
+
 type redisHook struct {
-	name string
+	name             string
+	opts             *redisOptions
+	processSpanName  string
+	pipelineSpanName string
+}
+
+func newRedisHook(name string, opts *redisOptions) *redisHook {
+	return &redisHook{
+		name:             name,
+		opts:             opts,
+		processSpanName:  fmt.Sprintf("redis.v9.processCmd-[%s]", name),
+		pipelineSpanName: fmt.Sprintf("redis.pipeline-[%s]", name),
+	}
 }
 
 func (h *redisHook) DialHook(next redis.DialHook) redis.DialHook {
@@ -45,33 +129,178 @@ func (h *redisHook) DialHook(next redis.DialHook) redis.DialHook {
 func (h *redisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
 	tracer := otel.Tracer(redisTracerName)
 	return func(ctx context.Context, cmd redis.Cmder) error {
-		ctx, span := tracer.Start(ctx, fmt.Sprintf("redis.v9.processCmd-[%s]", h.name))
+		if Disabled() {
+			return next(ctx, cmd)
+		}
+		ctx, span := tracer.Start(ctx, h.processSpanName)
 		defer span.End()
 
-		span.SetAttributes(attribute.String("cmd", truncate(cmd.String())))
+		if span.IsRecording() {
+			span.SetAttributes(h.opts.cmdAttributes(cmd)...)
+		}
 
 		err := next(ctx, cmd)
-		if err != nil && !errors.Is(err, redis.Nil) {
-			span.SetAttributes(attribute.Bool("error", true))
-			span.RecordError(err, trace.WithStackTrace(true), trace.WithTimestamp(time.Now()))
-		}
+		setSpanError(span, err)
 		return err
 	}
 }
 
+// ProcessPipelineHook records the pipeline or the transaction as a span with an event per command,
+// the span is marked as error if any command failed except redis.Nil.
 func (h *redisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
 	tracer := otel.Tracer(redisTracerName)
 	return func(ctx context.Context, cmds []redis.Cmder) error {
-		ctx, span := tracer.Start(ctx, fmt.Sprintf("redis.v9.processPipelineCmd-[%s]", h.name))
+		if Disabled() {
+			return next(ctx, cmds)
+		}
+		ctx, span := tracer.Start(ctx, h.pipelineSpanName)
 		defer span.End()
 
-		span.SetAttributes(attribute.String("cmd", truncate(fmt.Sprintf("%v", cmds))))
-
+		start := time.Now()
 		err := next(ctx, cmds)
+		if !span.IsRecording() {
+			return err
+		}
+
+		// the transaction is wrapped with MULTI and EXEC by redis
+		tx := len(cmds) >= 2 && cmds[0].Name() == "multi" && cmds[len(cmds)-1].Name() == "exec"
+		if tx {
+			cmds = cmds[1 : len(cmds)-1]
+		}
+		span.SetAttributes(
+			attribute.Bool("redis.pipeline.tx", tx),
+			attribute.Int("redis.pipeline.length", len(cmds)),
+			attribute.Int64("redis.pipeline.duration_ms", time.Since(start).Milliseconds()),
+		)
+
+		// the error is found first, so that its exception event is reserved within the limit of the events
+		var spanErr error
 		if err != nil && !errors.Is(err, redis.Nil) {
-			span.SetAttributes(attribute.Bool("error", true))
-			span.RecordError(err, trace.WithStackTrace(true), trace.WithTimestamp(time.Now()))
+			spanErr = err
+		}
+		for _, cmd := range cmds {
+			if spanErr != nil {
+				break
+			}
+			if cmdErr := cmd.Err(); !errors.Is(cmdErr, redis.Nil) {
+				spanErr = cmdErr
+			}
+		}
+		reserved := 0
+		if spanErr != nil {
+			reserved = 1
+		}
+
+		recorded := spanEventsToRecord(len(cmds), reserved)
+		for i, cmd := range cmds[:recorded] {
+			attrs := append(h.opts.cmdAttributes(cmd), attribute.Int("redis.pipeline.index", i))
+			if cmdErr := cmd.Err(); cmdErr != nil && !errors.Is(cmdErr, redis.Nil) {
+				attrs = append(attrs, attribute.String("error", cmdErr.Error()))
+			}
+			span.AddEvent("redis.cmd", trace.WithAttributes(attrs...))
+		}
+		if omitted := len(cmds) - recorded; omitted > 0 {
+			span.AddEvent(fmt.Sprintf("%d more commands omitted", omitted))
 		}
+		setSpanError(span, spanErr)
 		return err
 	}
 }
+
+// redisCmder is the common part of the commands of redis v6 and v9.
+type redisCmder interface {
+	Name() string
+	Args() []any
+}
+
+// cmdAttributes returns the "cmd" attribute, and the "redis.key" attribute if the keys are captured.
+func (o *redisOptions) cmdAttributes(cmd redisCmder) []attribute.KeyValue {
+	if !o.captureKeys {
+		return []attribute.KeyValue{attribute.String("cmd", redisCmdStr(cmd))}
+	}
+	attrs := []attribute.KeyValue{attribute.String("cmd", cmd.Name())}
+	if key, ok := redisKey(cmd); ok {
+		attrs = append(attrs, attribute.String("redis.key", key))
+	}
+	return attrs
+}
+
+// redisKey returns the first key of the command.
+func redisKey(cmd redisCmder) (string, bool) {
+	args := cmd.Args()
+	if len(args) < 2 || redisKeylessCommands[cmd.Name()] {
+		return "", false
+	}
+	var b strings.Builder
+	writeRedisArg(&b, args[1])
+	return truncate(b.String()), true
+}
+
+// redisKeysStr formats the commands line by line like "get key", without the values.
+func redisKeysStr[C redisCmder](cmds ...C) string {
+	var b strings.Builder
+	for i, cmd := range cmds {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(cmd.Name())
+		if key, ok := redisKey(cmd); ok {
+			b.WriteByte(' ')
+			b.WriteString(key)
+		}
+		if b.Len() > maxAttributeLength {
+			break
+		}
+	}
+	return truncate(b.String())
+}
+
+// redisCmdStr formats the commands line by line like "get [get key]",
+// it stops formatting once the result is longer than maxAttributeLength.
+// The script commands are formatted by the name only, e.g. "evalsha".
+func redisCmdStr[C redisCmder](cmds ...C) string {
+	var b strings.Builder
+	for i, cmd := range cmds {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(cmd.Name())
+		if redisScriptCommands[cmd.Name()] {
+			continue
+		}
+		b.WriteString(" [")
+		for j, arg := range cmd.Args() {
+			if j > 0 {
+				b.WriteByte(' ')
+			}
+			writeRedisArg(&b, arg)
+			if b.Len() > maxAttributeLength {
+				return truncate(b.String())
+			}
+		}
+		b.WriteByte(']')
+	}
+	return truncate(b.String())
+}
+
+// writeRedisArg writes the arg without fmt for the common types to reduce the allocations.
+func writeRedisArg(b *strings.Builder, arg any) {
+	switch v := arg.(type) {
+	case string:
+		b.WriteString(v)
+	case []byte:
+		b.Write(v)
+	case int:
+		b.WriteString(strconv.Itoa(v))
+	case int64:
+		b.WriteString(strconv.FormatInt(v, 10)) //nolint:mnd
+	case uint64:
+		b.WriteString(strconv.FormatUint(v, 10)) //nolint:mnd
+	case float64:
+		b.WriteString(strconv.FormatFloat(v, 'g', -1, 64)) //nolint:mnd
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	default:
+		fmt.Fprint(b, v)
+	}
+}
@@ -6,6 +6,9 @@ import (
 
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 func TestRedisHook(t *testing.T) {
@@ -22,3 +25,177 @@ func TestRedisHook(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, "world", res)
 }
+
+func TestRedisClusterHook(t *testing.T) {
+	client, err := NewRedisClusterV9("test", &redis.ClusterOptions{
+		Addrs: []string{"127.0.0.1:6379"},
+	})
+	if err != nil {
+		t.Skipf("redis cluster is not available: %v", err)
+	}
+	defer client.Close()
+
+	_, err = client.Set(context.Background(), "cluster-haha", "world", 0).Result()
+	assert.Nil(t, err)
+
+	res, err := client.Get(context.Background(), "cluster-haha").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, "world", res)
+}
+
+func TestRedisHook_Pipeline(t *testing.T) {
+	client, err := NewRedisV9("test", &redis.Options{
+		Addr: "127.0.0.1:6379",
+	})
+	assert.Nil(t, err)
+	defer client.Close()
+	ctx := context.Background()
+
+	t.Run("pipeline should record an event per command", func(t *testing.T) {
+		exporter := setupTracingTest()
+		_, err := client.Pipelined(ctx, func(p redis.Pipeliner) error {
+			p.Set(ctx, "pipeline-haha", "world", 0)
+			p.Get(ctx, "pipeline-haha")
+			p.Get(ctx, "pipeline-not-exist")
+			return nil
+		})
+		assert.Equal(t, redis.Nil, err)
+
+		spans := exporter.GetSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "redis.pipeline-[test]", spans[0].Name)
+		assert.Contains(t, spans[0].Attributes, attribute.Bool("redis.pipeline.tx", false))
+		assert.Contains(t, spans[0].Attributes, attribute.Int("redis.pipeline.length", 3))
+		assert.NotContains(t, spans[0].Attributes, attribute.Bool("error", true))
+		assert.Len(t, spans[0].Events, 3)
+		assert.Contains(t, spans[0].Events[1].Attributes, attribute.String("cmd", "get [get pipeline-haha]"))
+	})
+
+	t.Run("failed command should mark the span as error", func(t *testing.T) {
+		exporter := setupTracingTest()
+		_, err := client.Pipelined(ctx, func(p redis.Pipeliner) error {
+			p.Set(ctx, "pipeline-haha", "world", 0)
+			p.Incr(ctx, "pipeline-haha")
+			return nil
+		})
+		assert.NotNil(t, err)
+
+		spans := exporter.GetSpans()
+		assert.Len(t, spans, 1)
+		assert.Contains(t, spans[0].Attributes, attribute.Bool("error", true))
+		assert.Len(t, spans[0].Events[1].Attributes, 3)
+	})
+
+	t.Run("events beyond the limit should be omitted", func(t *testing.T) {
+		SetMaxSpanEvents(3)
+		defer SetMaxSpanEvents(0)
+		exporter := setupTracingTest()
+		_, err := client.Pipelined(ctx, func(p redis.Pipeliner) error {
+			for range 5 {
+				p.Get(ctx, "pipeline-haha")
+			}
+			return nil
+		})
+		assert.Nil(t, err)
+
+		events := exporter.GetSpans()[0].Events
+		assert.Len(t, events, 3)
+		assert.Equal(t, "redis.cmd", events[1].Name)
+		assert.Equal(t, "3 more commands omitted", events[2].Name)
+	})
+
+	t.Run("exception event should be kept within the limit", func(t *testing.T) {
+		SetMaxSpanEvents(3)
+		defer SetMaxSpanEvents(0)
+		exporter := setupTracingTest()
+		_, err := client.Pipelined(ctx, func(p redis.Pipeliner) error {
+			p.Set(ctx, "pipeline-haha", "world", 0)
+			for range 4 {
+				p.Incr(ctx, "pipeline-haha")
+			}
+			return nil
+		})
+		assert.NotNil(t, err)
+
+		events := exporter.GetSpans()[0].Events
+		assert.Len(t, events, 3)
+		assert.Contains(t, events[0].Attributes, attribute.Int("redis.pipeline.index", 0))
+		assert.Equal(t, "4 more commands omitted", events[1].Name)
+		assert.Equal(t, "exception", events[2].Name)
+	})
+
+	t.Run("transaction should not record multi and exec", func(t *testing.T) {
+		exporter := setupTracingTest()
+		_, err := client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			p.Set(ctx, "pipeline-haha", "world", 0)
+			p.Get(ctx, "pipeline-haha")
+			return nil
+		})
+		assert.Nil(t, err)
+
+		spans := exporter.GetSpans()
+		assert.Len(t, spans, 1)
+		assert.Contains(t, spans[0].Attributes, attribute.Bool("redis.pipeline.tx", true))
+		assert.Contains(t, spans[0].Attributes, attribute.Int("redis.pipeline.length", 2))
+	})
+}
+
+func TestRedisHook_KeyCapture(t *testing.T) {
+	client, err := NewRedisV9("test", &redis.Options{
+		Addr: "127.0.0.1:6379",
+	}, WithRedisKeyCapture(true))
+	assert.Nil(t, err)
+	defer client.Close()
+	ctx := context.Background()
+
+	t.Run("key should be recorded without the value", func(t *testing.T) {
+		exporter := setupTracingTest()
+		assert.Nil(t, client.Set(ctx, "key-capture", "secret", 0).Err())
+
+		spans := exporter.GetSpans()
+		assert.Len(t, spans, 1)
+		assert.Contains(t, spans[0].Attributes, attribute.String("cmd", "set"))
+		assert.Contains(t, spans[0].Attributes, attribute.String("redis.key", "key-capture"))
+		for _, attr := range spans[0].Attributes {
+			assert.NotContains(t, attr.Value.Emit(), "secret")
+		}
+	})
+
+	t.Run("keyless command should not record the key", func(t *testing.T) {
+		exporter := setupTracingTest()
+		assert.Nil(t, client.Echo(ctx, "secret").Err())
+
+		spans := exporter.GetSpans()
+		assert.Len(t, spans, 1)
+		assert.Contains(t, spans[0].Attributes, attribute.String("cmd", "echo"))
+		for _, attr := range spans[0].Attributes {
+			assert.NotEqual(t, attribute.Key("redis.key"), attr.Key)
+		}
+	})
+}
+
+func BenchmarkRedisHook_Get(b *testing.B) {
+	for _, sampler := range []sdktrace.Sampler{sdktrace.AlwaysSample(), sdktrace.NeverSample()} {
+		b.Run(sampler.Description(), func(b *testing.B) {
+			tp := otel.GetTracerProvider()
+			defer otel.SetTracerProvider(tp)
+			otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSampler(sampler)))
+
+			client, err := NewRedisV9("bench", &redis.Options{
+				Addr: "127.0.0.1:6379",
+			})
+			if err != nil {
+				b.Skipf("redis is not available: %v", err)
+			}
+			defer client.Close()
+			ctx := context.Background()
+			_, _ = client.Set(ctx, "bench", "world", 0).Result()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				_, _ = client.Get(ctx, "bench").Result()
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package apm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	redisLockTracerName = "goapm/redisLock"
+
+	// redisLockRetryInterval is the interval between two attempts of RedisLock.Lock.
+	redisLockRetryInterval = 50 * time.Millisecond
+)
+
+// ErrLockNotHeld is returned by RedisLock.Unlock when the lock is not held by the caller,
+// e.g. it has expired or been acquired by others.
+var ErrLockNotHeld = errors.New("redis lock not held")
+
+// unlockScript deletes the key only if its value equals to the token,
+// so that a lock would never be released by others.
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+else
+	return 0
+end
+`)
+
+// RedisLock is a distributed lock based on redis v9 with otel tracing enabled.
+// It is not reentrant and should not be shared between goroutines that hold the lock at the same time.
+type RedisLock struct {
+	client *redis.Client
+	key    string
+	ttl    time.Duration
+	token  string
+	tracer trace.Tracer
+}
+
+// NewRedisLock creates a new distributed lock on the given key,
+// the lock would be released automatically after ttl if it is not unlocked.
+func NewRedisLock(client *redis.Client, key string, ttl time.Duration) *RedisLock {
+	return &RedisLock{
+		client: client,
+		key:    key,
+		ttl:    ttl,
+		token:  uuid.NewString(),
+		tracer: otel.Tracer(redisLockTracerName),
+	}
+}
+
+// TryLock tries to acquire the lock once, it returns false if the lock is held by others.
+func (l *RedisLock) TryLock(ctx context.Context) (bool, error) {
+	ctx, span := l.startSpan(ctx, "trylock")
+	defer span.End()
+
+	ok, err := l.tryLock(ctx)
+	if err != nil {
+		l.recordError(span, err)
+		return false, err
+	}
+	if !ok {
+		span.AddEvent("lock contention")
+	}
+	span.SetAttributes(attribute.Bool("redis.lock.acquired", ok))
+	return ok, nil
+}
+
+// Lock acquires the lock, it blocks and retries until the lock is acquired or the ctx is done.
+func (l *RedisLock) Lock(ctx context.Context) error {
+	ctx, span := l.startSpan(ctx, "lock")
+	defer span.End()
+
+	for attempt := 1; ; attempt++ {
+		ok, err := l.tryLock(ctx)
+		if err != nil {
+			l.recordError(span, err)
+			return err
+		}
+		if ok {
+			span.SetAttributes(attribute.Int("redis.lock.attempts", attempt))
+			return nil
+		}
+
+		// the attempts are counted in redis.lock.attempts, so the events beyond the limit are simply dropped
+		if maxSpanEvents <= 0 || attempt < maxSpanEvents {
+			span.AddEvent("lock contention", trace.WithAttributes(attribute.Int("attempt", attempt)))
+		}
+		select {
+		case <-ctx.Done():
+			span.SetAttributes(attribute.Int("redis.lock.attempts", attempt))
+			l.recordError(span, ctx.Err())
+			return ctx.Err()
+		case <-time.After(redisLockRetryInterval):
+		}
+	}
+}
+
+// Unlock releases the lock, it returns ErrLockNotHeld if the lock is not held by the caller.
+func (l *RedisLock) Unlock(ctx context.Context) error {
+	ctx, span := l.startSpan(ctx, "unlock")
+	defer span.End()
+
+	res, err := unlockScript.Run(ctx, l.client, []string{l.key}, l.token).Int64()
+	if err != nil {
+		l.recordError(span, err)
+		return err
+	}
+	if res == 0 {
+		span.AddEvent("lock not held")
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+func (l *RedisLock) tryLock(ctx context.Context) (bool, error) {
+	return l.client.SetNX(ctx, l.key, l.token, l.ttl).Result()
+}
+
+func (l *RedisLock) startSpan(ctx context.Context, op string) (context.Context, trace.Span) {
+	ctx, span := l.tracer.Start(ctx, fmt.Sprintf("redis.lock.%s", op))
+	span.SetAttributes(attribute.String("redis.lock.key", l.key))
+	return ctx, span
+}
+
+func (l *RedisLock) recordError(span trace.Span, err error) {
+	span.SetAttributes(attribute.Bool("error", true))
+	RecordError(span, err)
+}
@@ -0,0 +1,49 @@
+package apm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisLock(t *testing.T) {
+	client, err := NewRedisV9("test", &redis.Options{
+		Addr: "127.0.0.1:6379",
+	})
+	assert.Nil(t, err)
+	defer client.Close()
+
+	key := "goapm-lock-" + uuid.NewString()
+	lock1 := NewRedisLock(client, key, time.Second)
+	lock2 := NewRedisLock(client, key, time.Second)
+
+	t.Run("try lock should be exclusive", func(t *testing.T) {
+		ok, err := lock1.TryLock(context.Background())
+		assert.Nil(t, err)
+		assert.True(t, ok)
+
+		ok, err = lock2.TryLock(context.Background())
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("unlock by others should fail", func(t *testing.T) {
+		assert.Equal(t, ErrLockNotHeld, lock2.Unlock(context.Background()))
+	})
+
+	t.Run("lock should block until ctx is done", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		assert.Equal(t, context.DeadlineExceeded, lock2.Lock(ctx))
+	})
+
+	t.Run("lock should succeed after unlock", func(t *testing.T) {
+		assert.Nil(t, lock1.Unlock(context.Background()))
+		assert.Nil(t, lock2.Lock(context.Background()))
+		assert.Nil(t, lock2.Unlock(context.Background()))
+	})
+}
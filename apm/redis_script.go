@@ -0,0 +1,81 @@
+package apm
+
+import (
+	"context"
+	"errors"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	redisScriptTracerName = "goapm/redisScript"
+)
+
+// RedisScript is a wrapper of redis.Script with otel tracing enabled,
+// each run is recorded as a span named by the logical name of the script,
+// so that it is not only an anonymous EVALSHA in the traces.
+// The script body and the arg values are never recorded,
+// the EVALSHA and EVAL commands of the script are recorded by the redis hook with the command names only.
+type RedisScript struct {
+	name   string
+	client redis.Scripter
+	script *redis.Script
+	tracer trace.Tracer
+}
+
+// NewRedisScript creates a new lua script on the given redis v9 client,
+// name is the logical name of the script, it will be used in the span name.
+func NewRedisScript(client redis.Scripter, name, src string) *RedisScript {
+	return &RedisScript{
+		name:   name,
+		client: client,
+		script: redis.NewScript(src),
+		tracer: otel.Tracer(redisScriptTracerName),
+	}
+}
+
+// Hash returns the sha1 of the script.
+func (s *RedisScript) Hash() string {
+	return s.script.Hash()
+}
+
+// Run runs the script by EVALSHA, and falls back to EVAL if the script is not loaded.
+func (s *RedisScript) Run(ctx context.Context, keys []string, args ...any) *redis.Cmd {
+	ctx, span := s.startSpan(ctx, keys)
+	defer span.End()
+
+	cmd := s.script.Run(ctx, s.client, keys, args...)
+	s.recordError(span, cmd.Err())
+	return cmd
+}
+
+// RunRO is the read-only variant of Run, it runs the script by EVALSHA_RO and EVAL_RO.
+func (s *RedisScript) RunRO(ctx context.Context, keys []string, args ...any) *redis.Cmd {
+	ctx, span := s.startSpan(ctx, keys)
+	defer span.End()
+
+	cmd := s.script.RunRO(ctx, s.client, keys, args...)
+	s.recordError(span, cmd.Err())
+	return cmd
+}
+
+func (s *RedisScript) startSpan(ctx context.Context, keys []string) (context.Context, trace.Span) {
+	ctx, span := s.tracer.Start(ctx, "redis.script."+s.name)
+	span.SetAttributes(
+		attribute.String("redis.script.name", s.name),
+		attribute.String("redis.script.sha", s.script.Hash()),
+		attribute.Int("redis.script.keys", len(keys)),
+	)
+	return ctx, span
+}
+
+// recordError records the error of the script, except redis.Nil which is a nil reply of the script.
+func (s *RedisScript) recordError(span trace.Span, err error) {
+	if errors.Is(err, redis.Nil) {
+		return
+	}
+	setSpanError(span, err)
+}
@@ -0,0 +1,78 @@
+package apm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestRedisScript(t *testing.T) {
+	client, err := NewRedisV9("test", &redis.Options{
+		Addr: "127.0.0.1:6379",
+	})
+	assert.Nil(t, err)
+	defer client.Close()
+	ctx := context.Background()
+
+	script := NewRedisScript(client, "incr_by", `return redis.call("INCRBY", KEYS[1], ARGV[1])`)
+	assert.Nil(t, client.Del(ctx, "script-counter").Err())
+
+	t.Run("run should record the script span", func(t *testing.T) {
+		exporter := setupTracingTest()
+		res, err := script.Run(ctx, []string{"script-counter"}, 10).Int64()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(10), res)
+
+		spans := exporter.GetSpans()
+		var found bool
+		for _, span := range spans {
+			if span.Name != "redis.script.incr_by" {
+				continue
+			}
+			found = true
+			assert.Contains(t, span.Attributes, attribute.String("redis.script.name", "incr_by"))
+			assert.Contains(t, span.Attributes, attribute.String("redis.script.sha", script.Hash()))
+			assert.Contains(t, span.Attributes, attribute.Int("redis.script.keys", 1))
+			assert.NotContains(t, span.Attributes, attribute.Bool("error", true))
+		}
+		assert.True(t, found)
+		// the redis commands are recorded as the children of the script span
+		assert.Equal(t, spans[len(spans)-1].SpanContext.SpanID(), spans[0].Parent.SpanID())
+	})
+
+	t.Run("script body and args should not be recorded", func(t *testing.T) {
+		exporter := setupTracingTest()
+		// the script is not loaded, so that it falls back to EVAL with the body
+		src := `return ARGV[1] -- ` + time.Now().String()
+		_, err := NewRedisScript(client, "echo", src).Run(ctx, []string{"script-counter"}, "secret-arg").Result()
+		assert.Nil(t, err)
+
+		spans := exporter.GetSpans()
+		var cmds []string
+		for _, span := range spans {
+			for _, attr := range span.Attributes {
+				assert.NotContains(t, attr.Value.Emit(), "secret-arg")
+				assert.NotContains(t, attr.Value.Emit(), src)
+				if attr.Key == "cmd" {
+					cmds = append(cmds, attr.Value.AsString())
+				}
+			}
+		}
+		assert.Equal(t, []string{"evalsha", "eval"}, cmds)
+	})
+
+	t.Run("failed script should mark the span as error", func(t *testing.T) {
+		exporter := setupTracingTest()
+		err := script.Run(ctx, []string{"script-counter"}, "not a number").Err()
+		assert.NotNil(t, err)
+
+		spans := exporter.GetSpans()
+		scriptSpan := spans[len(spans)-1]
+		assert.Equal(t, "redis.script.incr_by", scriptSpan.Name)
+		assert.Contains(t, scriptSpan.Attributes, attribute.Bool("error", true))
+	})
+}
@@ -3,7 +3,6 @@ package apm
 import (
 	"context"
 	"fmt"
-	"time"
 
 	"github.com/go-redis/redis"
 	"go.opentelemetry.io/otel"
@@ -19,12 +18,15 @@ const (
 type RedisV6 struct {
 	name string
 	*redis.Client
-	tracer trace.Tracer
+	tracer           trace.Tracer
+	opts             *redisOptions
+	processSpanName  string
+	pipelineSpanName string
 }
 
 // NewRedisV6 creates a new redis client with otel tracing enabled.
 // name is the business name of the redis client, it will be used in the span name.
-func NewRedisV6(name string, opts *redis.Options) (*RedisV6, error) {
+func NewRedisV6(name string, opts *redis.Options, redisOpts ...RedisOption) (*RedisV6, error) {
 	rdb := redis.NewClient(opts)
 
 	if err := rdb.Ping().Err(); err != nil {
@@ -33,63 +35,62 @@ func NewRedisV6(name string, opts *redis.Options) (*RedisV6, error) {
 
 	Logger.Info(context.TODO(), fmt.Sprintf("redis v6 client[%s] connected", name), nil)
 	return &RedisV6{
-		name:   name,
-		Client: rdb,
-		tracer: otel.Tracer(redisV6TracerName),
+		name:             name,
+		Client:           rdb,
+		tracer:           otel.Tracer(redisV6TracerName),
+		opts:             newRedisOptions(redisOpts),
+		processSpanName:  fmt.Sprintf("redis.v6.processCmd-[%s]", name),
+		pipelineSpanName: fmt.Sprintf("redis.v6.processPipelineCmd-[%s]", name),
 	}, nil
 }
 
 // WithContext wraps client with context and wraps process and process pipeline with otel tracing.
+// The wrappers are always applied to a clone of the unwrapped client, so they never stack up,
+// and since redis v6 passes no context to the process, only the binding of ctx is created on each call.
 func (r *RedisV6) WithContext(ctx context.Context) *redis.Client {
 	client := r.Client.WithContext(ctx)
-	wrapProcess(r.tracer, r.name, client)
-	wrapProcessPipeline(r.tracer, r.name, client)
-	return client
-}
-
-func wrapProcess(tracer trace.Tracer, name string, client *redis.Client) {
+	if Disabled() || r.opts.disabled {
+		return client
+	}
 	client.WrapProcess(func(oldProcess func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
 		return func(cmd redis.Cmder) error {
-			_, span := tracer.Start(client.Context(), fmt.Sprintf("redis.v6.processCmd-[%s]", name))
-			defer span.End()
-
-			span.SetAttributes(attribute.String("cmd", cmdStr(cmd)))
-
-			err := oldProcess(cmd)
-			if err != nil {
-				span.SetAttributes(attribute.Bool("error", true))
-				span.RecordError(err, trace.WithStackTrace(true), trace.WithTimestamp(time.Now()))
-			}
-			return err
+			return r.process(ctx, oldProcess, cmd)
 		}
 	})
-}
-
-func wrapProcessPipeline(tracer trace.Tracer, name string, client *redis.Client) {
 	client.WrapProcessPipeline(func(oldProcess func([]redis.Cmder) error) func([]redis.Cmder) error {
 		return func(cmds []redis.Cmder) error {
-			_, span := tracer.Start(client.Context(), fmt.Sprintf("redis.v6.processPipelineCmd-[%s]", name))
-			defer span.End()
-
-			span.SetAttributes(attribute.String("cmd", cmdStr(cmds...)))
-
-			err := oldProcess(cmds)
-			if err != nil {
-				span.SetAttributes(attribute.Bool("error", true))
-				span.RecordError(err, trace.WithStackTrace(true), trace.WithTimestamp(time.Now()))
-			}
-			return err
+			return r.processPipeline(ctx, oldProcess, cmds)
 		}
 	})
+	return client
 }
 
-func cmdStr(cmds ...redis.Cmder) string {
-	var cmdStr string
-	for i, cmd := range cmds {
-		cmdStr += fmt.Sprintf("%s %v", cmd.Name(), cmd.Args())
-		if i != len(cmds)-1 {
-			cmdStr += "\n"
+func (r *RedisV6) process(ctx context.Context, oldProcess func(cmd redis.Cmder) error, cmd redis.Cmder) error {
+	_, span := r.tracer.Start(ctx, r.processSpanName)
+	defer span.End()
+
+	if span.IsRecording() {
+		span.SetAttributes(r.opts.cmdAttributes(cmd)...)
+	}
+
+	err := oldProcess(cmd)
+	setSpanError(span, err)
+	return err
+}
+
+func (r *RedisV6) processPipeline(ctx context.Context, oldProcess func([]redis.Cmder) error, cmds []redis.Cmder) error {
+	_, span := r.tracer.Start(ctx, r.pipelineSpanName)
+	defer span.End()
+
+	if span.IsRecording() {
+		if r.opts.captureKeys {
+			span.SetAttributes(attribute.String("cmd", redisKeysStr(cmds...)))
+		} else {
+			span.SetAttributes(attribute.String("cmd", redisCmdStr(cmds...)))
 		}
 	}
-	return truncate(cmdStr)
+
+	err := oldProcess(cmds)
+	setSpanError(span, err)
+	return err
 }
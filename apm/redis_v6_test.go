@@ -6,6 +6,8 @@ import (
 
 	"github.com/go-redis/redis"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 func TestRedisV6(t *testing.T) {
@@ -23,3 +25,30 @@ func TestRedisV6(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, "world", res)
 }
+
+func BenchmarkRedisV6_Get(b *testing.B) {
+	for _, sampler := range []sdktrace.Sampler{sdktrace.AlwaysSample(), sdktrace.NeverSample()} {
+		b.Run(sampler.Description(), func(b *testing.B) {
+			tp := otel.GetTracerProvider()
+			defer otel.SetTracerProvider(tp)
+			otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSampler(sampler)))
+
+			client, err := NewRedisV6("bench", &redis.Options{
+				Addr: "127.0.0.1:6379",
+				DB:   10,
+			})
+			if err != nil {
+				b.Skipf("redis is not available: %v", err)
+			}
+			defer client.Close()
+			ctx := context.Background()
+			_, _ = client.WithContext(ctx).Set("bench", "world", 0).Result()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				_, _ = client.WithContext(ctx).Get("bench").Result()
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package apm
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// HeaderRequestID is the header carrying the request id.
+	HeaderRequestID = "X-Request-ID"
+
+	requestIDKey = "request_id"
+
+	ctxRequestID ctxKey = "http.request_id"
+)
+
+// RequestID creates a Gin middleware which reads the request id from the X-Request-ID header,
+// or generates one if it is absent. The request id is echoed in the response header,
+// set on the span as "request_id" and attached to the logs of the goapm Logger.
+// It should be used after GinOtel so that the span has been started.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(HeaderRequestID)
+		if id == "" {
+			id = uuid.NewString()
+		}
+
+		ctx := ContextWithRequestID(c.Request.Context(), id)
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String(requestIDKey, id))
+		c.Request = c.Request.WithContext(ctx)
+		c.Header(HeaderRequestID, id)
+		c.Next()
+	}
+}
+
+// ContextWithRequestID returns a copy of ctx with the request id.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxRequestID, id)
+}
+
+// RequestIDFromContext returns the request id in the ctx, or an empty string if it does not exist.
+func RequestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(ctxRequestID).(string)
+	return id
+}
@@ -0,0 +1,74 @@
+package apm
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithRateLimitSampler caps the sampled root spans to spansPerSecond by a token bucket,
+// the spans with a parent follow the sampling decision of the parent.
+// It protects the collector from the traffic spikes, and overrides WithSampler.
+// If spansPerSecond <= 0, no root span is sampled, i.e. only the traces sampled by the upstreams are recorded.
+func WithRateLimitSampler(spansPerSecond float64) ApmOption {
+	return func(b *apmBuilder) {
+		if spansPerSecond <= 0 {
+			b.sampler = sdktrace.ParentBased(sdktrace.NeverSample())
+			return
+		}
+		b.sampler = sdktrace.ParentBased(newRateLimitSampler(spansPerSecond, time.Now))
+	}
+}
+
+// rateLimitSampler is a token bucket sampler, the bucket holds at most one second of tokens.
+type rateLimitSampler struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+func newRateLimitSampler(spansPerSecond float64, now func() time.Time) *rateLimitSampler {
+	burst := max(spansPerSecond, 1)
+	return &rateLimitSampler{
+		rate:   spansPerSecond,
+		burst:  burst,
+		tokens: burst,
+		last:   now(),
+		now:    now,
+	}
+}
+
+func (s *rateLimitSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	decision := sdktrace.Drop
+	if s.allow() {
+		decision = sdktrace.RecordAndSample
+	}
+	return sdktrace.SamplingResult{
+		Decision:   decision,
+		Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+	}
+}
+
+func (s *rateLimitSampler) allow() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.tokens = min(s.burst, s.tokens+now.Sub(s.last).Seconds()*s.rate)
+	s.last = now
+	if s.tokens < 1 {
+		return false
+	}
+	s.tokens--
+	return true
+}
+
+func (s *rateLimitSampler) Description() string {
+	return fmt.Sprintf("RateLimitSampler{%g}", s.rate)
+}
@@ -0,0 +1,60 @@
+package apm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestRateLimitSampler(t *testing.T) {
+	now := time.Unix(0, 0)
+	s := newRateLimitSampler(10, func() time.Time { return now })
+
+	sampled := func(n int) int {
+		count := 0
+		for range n {
+			if s.ShouldSample(sdktrace.SamplingParameters{ParentContext: context.Background()}).Decision ==
+				sdktrace.RecordAndSample {
+				count++
+			}
+		}
+		return count
+	}
+
+	// the burst is one second of tokens
+	assert.Equal(t, 10, sampled(100))
+	assert.Equal(t, 0, sampled(100))
+
+	// the tokens are refilled at the rate
+	now = now.Add(500 * time.Millisecond)
+	assert.Equal(t, 5, sampled(100))
+
+	// the tokens never exceed the burst
+	now = now.Add(time.Minute)
+	assert.Equal(t, 10, sampled(100))
+
+	// the rate is capped over a window
+	total := 0
+	for range 10 {
+		now = now.Add(100 * time.Millisecond)
+		total += sampled(100)
+	}
+	assert.Equal(t, 10, total)
+}
+
+func TestWithRateLimitSampler(t *testing.T) {
+	b := &apmBuilder{}
+	WithRateLimitSampler(1)(b)
+	assert.Contains(t, b.sampler.Description(), "RateLimitSampler{1}")
+
+	for _, rate := range []float64{0, -1} {
+		WithRateLimitSampler(rate)(b)
+		for range 3 {
+			res := b.sampler.ShouldSample(sdktrace.SamplingParameters{ParentContext: context.Background()})
+			assert.Equal(t, sdktrace.Drop, res.Decision)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package apm
+
+import (
+	"context"
+	"runtime"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	spanTracerName = "goapm/span"
+)
+
+// maxSpanEvents is the max number of the events of a span, 0 means the default limit of the otel sdk, i.e. 128.
+var maxSpanEvents int
+
+// SetMaxSpanEvents sets the max number of the events of a span, to bound the size of the spans
+// recording a lot of commands, e.g. the redis pipelines. The events beyond the limit are dropped,
+// and the goapm instrumentations summarize them with an event like "N more commands omitted".
+// It should be called before NewAPM, since the limit is applied to the tracer provider.
+func SetMaxSpanEvents(n int) {
+	maxSpanEvents = n
+}
+
+// spanEventsToRecord returns how many of the total events should be recorded within maxSpanEvents,
+// reserved is the number of the other events to be added, e.g. 1 for the exception event of setSpanError,
+// and one more event is reserved for the summary of the omitted events if they exceed the limit.
+func spanEventsToRecord(total, reserved int) int {
+	if maxSpanEvents <= 0 || total+reserved <= maxSpanEvents {
+		return total
+	}
+	return max(maxSpanEvents-reserved-1, 0)
+}
+
+// StartSpan starts a span named after the calling function, e.g. "service.(*User).Get",
+// it is a one-liner to instrument the internal functions:
+//
+//	ctx, span := apm.StartSpan(ctx)
+//	defer span.End()
+func StartSpan(ctx context.Context) (context.Context, trace.Span) {
+	return StartNamedSpan(ctx, callerName(2)) //nolint:mnd
+}
+
+// StartNamedSpan starts a span with the name by the goapm tracer.
+func StartNamedSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return otel.Tracer(spanTracerName).Start(ctx, name)
+}
+
+// callerName returns the function name of the caller skip frames above, without the package path.
+func callerName(skip int) string {
+	pcs := make([]uintptr, 1)
+	if runtime.Callers(skip+1, pcs) == 0 {
+		return "unknown"
+	}
+	// CallersFrames resolves the inlined functions correctly
+	frame, _ := runtime.CallersFrames(pcs).Next()
+	name := frame.Function
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// StartSpanWithLinks starts a span linked to the spans of other traces, e.g. a batch consumer span
+// processing messages from many traces, or the spans fanned out from a handler.
+// The span is still the child of the span in ctx if there is one, the links without a valid span context are dropped.
+func StartSpanWithLinks(ctx context.Context, name string, links ...trace.Link) (context.Context, trace.Span) {
+	valid := make([]trace.Link, 0, len(links))
+	for _, link := range links {
+		if link.SpanContext.IsValid() {
+			valid = append(valid, link)
+		}
+	}
+	return otel.Tracer(spanTracerName).Start(ctx, name, trace.WithLinks(valid...))
+}
+
+// LinkFromContext returns a link to the span in ctx, attrs describe the relationship,
+// e.g. the context extracted from the headers of a message by the otel propagator.
+func LinkFromContext(ctx context.Context, attrs ...attribute.KeyValue) trace.Link {
+	return trace.Link{
+		SpanContext: trace.SpanContextFromContext(ctx),
+		Attributes:  attrs,
+	}
+}
+
+// TraceIDFromContext returns the trace id of the span in ctx, or an empty string if there is none,
+// e.g. to include it in the error responses.
+func TraceIDFromContext(ctx context.Context) string {
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
+
+// SpanIDFromContext returns the span id of the span in ctx, or an empty string if there is none.
+func SpanIDFromContext(ctx context.Context) string {
+	if sc := trace.SpanContextFromContext(ctx); sc.HasSpanID() {
+		return sc.SpanID().String()
+	}
+	return ""
+}
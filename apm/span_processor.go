@@ -0,0 +1,34 @@
+package apm
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// attributesSpanProcessor is a span processor which sets the fixed attributes on every span.
+type attributesSpanProcessor struct {
+	attrs []attribute.KeyValue
+}
+
+// NewAttributesSpanProcessor creates a span processor which sets the attributes on every span when it starts,
+// e.g. tag the spans with the environment:
+//
+//	apm.WithSpanProcessor(apm.NewAttributesSpanProcessor(
+//		attribute.String("deployment.environment", os.Getenv("DEPLOY_ENV")),
+//	))
+func NewAttributesSpanProcessor(attrs ...attribute.KeyValue) sdktrace.SpanProcessor {
+	return &attributesSpanProcessor{attrs: attrs}
+}
+
+// OnStart sets the attributes on the span.
+func (p *attributesSpanProcessor) OnStart(_ context.Context, s sdktrace.ReadWriteSpan) {
+	s.SetAttributes(p.attrs...)
+}
+
+func (p *attributesSpanProcessor) OnEnd(sdktrace.ReadOnlySpan) {}
+
+func (p *attributesSpanProcessor) Shutdown(context.Context) error { return nil }
+
+func (p *attributesSpanProcessor) ForceFlush(context.Context) error { return nil }
@@ -0,0 +1,70 @@
+package apm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestStartSpanWithLinks(t *testing.T) {
+	exporter := setupTracingTest()
+	tracer := otel.Tracer("test")
+
+	ctx1, span1 := tracer.Start(context.Background(), "message1")
+	span1.End()
+	ctx2, span2 := tracer.Start(context.Background(), "message2")
+	span2.End()
+
+	_, batch := StartSpanWithLinks(context.Background(), "batch",
+		LinkFromContext(ctx1, attribute.String("messaging.message.id", "1")),
+		LinkFromContext(ctx2),
+		LinkFromContext(context.Background()),
+	)
+	batch.End()
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 3)
+	assert.Equal(t, "batch", spans[2].Name)
+	assert.False(t, spans[2].Parent.IsValid())
+	assert.Len(t, spans[2].Links, 2)
+	assert.Equal(t, span1.SpanContext().SpanID(), spans[2].Links[0].SpanContext.SpanID())
+	assert.Equal(t, span1.SpanContext().TraceID(), spans[2].Links[0].SpanContext.TraceID())
+	assert.Contains(t, spans[2].Links[0].Attributes, attribute.String("messaging.message.id", "1"))
+	assert.Equal(t, span2.SpanContext().SpanID(), spans[2].Links[1].SpanContext.SpanID())
+}
+
+func TestTraceIDFromContext(t *testing.T) {
+	ctx, span := otel.Tracer("test").Start(context.Background(), "test")
+	defer span.End()
+
+	assert.Equal(t, span.SpanContext().TraceID().String(), TraceIDFromContext(ctx))
+	assert.Equal(t, span.SpanContext().SpanID().String(), SpanIDFromContext(ctx))
+	assert.Empty(t, TraceIDFromContext(context.Background()))
+	assert.Empty(t, SpanIDFromContext(context.Background()))
+}
+
+type spanTestService struct{}
+
+func (s *spanTestService) Get(ctx context.Context) {
+	_, span := StartSpan(ctx)
+	span.End()
+}
+
+func TestStartSpan(t *testing.T) {
+	exporter := setupTracingTest()
+	(&spanTestService{}).Get(context.Background())
+	func() {
+		_, span := StartSpan(context.Background())
+		span.End()
+	}()
+	_, span := StartNamedSpan(context.Background(), "named")
+	span.End()
+
+	spans := exporter.GetSpans()
+	assert.Equal(t, "apm.(*spanTestService).Get", spans[0].Name)
+	assert.Equal(t, "apm.TestStartSpan.func1", spans[1].Name)
+	assert.Equal(t, "named", spans[2].Name)
+}
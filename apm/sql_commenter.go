@@ -0,0 +1,52 @@
+package apm
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/hedon954/goapm/internal"
+)
+
+// commentSQL appends a sqlcommenter comment with the application name and the traceparent of the span in ctx
+// to the query, so that the slow query logs of mysql could be joined with the traces,
+// see https://google.github.io/sqlcommenter/spec/.
+// The query is returned as is if it has a comment already or there is nothing to comment.
+func commentSQL(ctx context.Context, query string) string {
+	if strings.Contains(query, "/*") {
+		return query
+	}
+
+	tags := make(map[string]string, 2) //nolint:mnd
+	if app := internal.BuildInfo.AppName(); app != "" {
+		tags["application"] = app
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		tags["traceparent"] = fmt.Sprintf("00-%s-%s-%s", sc.TraceID(), sc.SpanID(), sc.TraceFlags())
+	}
+	if len(tags) == 0 {
+		return query
+	}
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf("%s='%s'", sqlCommentEscape(k), sqlCommentEscape(tags[k]))
+	}
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+	return query + " /*" + strings.Join(pairs, ",") + "*/"
+}
+
+// sqlCommentEscape url encodes s and escapes the single quotes as the sqlcommenter spec requires.
+func sqlCommentEscape(s string) string {
+	s = strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+	return strings.ReplaceAll(s, "'", `\'`)
+}
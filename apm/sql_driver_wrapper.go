@@ -3,11 +3,16 @@ package apm
 import (
 	"context"
 	"database/sql/driver"
+	"errors"
 	"fmt"
+	"io"
+	"reflect"
 	"time"
 
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
+
+	"github.com/xwb1989/sqlparser"
 )
 
 // Hooks is a set of hooks that can be invoked during the execution of a SQL query.
@@ -24,6 +29,14 @@ type Hooks struct {
 type Driver struct {
 	driver.Driver
 	hooks Hooks
+	// retryAttempts is the max attempts of the queries failed by deadlocks, see WithSQLRetryOnDeadlock.
+	retryAttempts int
+	// sqlCommenter appends the sqlcommenter comment to the queries, see WithSQLCommenter.
+	sqlCommenter bool
+	// explainer explains the slow queries, it is closed with the db, see WithExplainOnSlowQuery.
+	explainer *sqlExplainer
+	// disabled skips the metrics of the connections, see WithMySQLDisabled.
+	disabled bool
 }
 
 // Open returns a new connection to the database.
@@ -40,11 +53,68 @@ func (d *Driver) Open(name string) (driver.Conn, error) {
 	if err != nil {
 		return nil, err
 	}
+	return d.wrapConn(conn), nil
+}
 
+// OpenConnector returns a connector of the wrapped connections.
+// The db opened by the driver closes the connector when it is closed, so the resources of the driver are released.
+func (d *Driver) OpenConnector(name string) (driver.Connector, error) {
+	c := &Connector{driver: d, name: name}
+	if dc, ok := d.Driver.(driver.DriverContext); ok {
+		connector, err := dc.OpenConnector(name)
+		if err != nil {
+			return nil, err
+		}
+		c.connector = connector
+	}
+	return c, nil
+}
+
+func (d *Driver) wrapConn(conn driver.Conn) *Conn {
 	return &Conn{
-		Conn:  conn,
-		hooks: d.hooks,
-	}, nil
+		Conn:          conn,
+		hooks:         d.hooks,
+		retryAttempts: d.retryAttempts,
+		sqlCommenter:  d.sqlCommenter,
+		disabled:      d.disabled,
+	}
+}
+
+// Connector is a wrapper around the driver.Connector interface of the wrapped driver.
+// It opens the connections with the wrapped driver if the driver does not implement driver.DriverContext.
+type Connector struct {
+	driver    *Driver
+	name      string
+	connector driver.Connector
+}
+
+// Connect returns a new wrapped connection to the database.
+func (c *Connector) Connect(ctx context.Context) (driver.Conn, error) {
+	if c.connector == nil {
+		return c.driver.Open(c.name)
+	}
+	conn, err := c.connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.driver.wrapConn(conn), nil
+}
+
+// Driver returns the wrapping driver.
+func (c *Connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Close releases the resources of the driver, it is called when the db is closed.
+func (c *Connector) Close() error {
+	var errs []error
+	if closer, ok := c.connector.(io.Closer); ok {
+		errs = append(errs, closer.Close())
+	}
+	if c.driver.explainer != nil {
+		errs = append(errs, c.driver.explainer.close())
+	}
+	return errors.Join(errs...)
 }
 
 // Conn is a wrapper around the driver.Conn interface.
@@ -55,7 +125,28 @@ func (d *Driver) Open(name string) (driver.Conn, error) {
 // - driver.ConnPrepareContext
 type Conn struct {
 	driver.Conn
-	hooks Hooks
+	hooks         Hooks
+	retryAttempts int
+	sqlCommenter  bool
+	disabled      bool
+	// inTx is true between BeginTx and the end of the transaction, the queries are not retried then.
+	inTx bool
+}
+
+// maxAttempts returns the max attempts of the queries on the connection.
+func (conn *Conn) maxAttempts() int {
+	if conn.inTx {
+		return 1
+	}
+	return conn.retryAttempts
+}
+
+// driverQuery returns the query sent to the wrapped connection, the hooks always get the original query.
+func (conn *Conn) driverQuery(ctx context.Context, query string) string {
+	if !conn.sqlCommenter {
+		return query
+	}
+	return commentSQL(ctx, query)
 }
 
 //nolint:dupl
@@ -67,8 +158,12 @@ func (conn *Conn) ExecContext(ctx context.Context, query string, args []driver.N
 	if ctx, err = conn.hooks.Before(ctx, query, list...); err != nil {
 		return nil, err
 	}
+	defer cancelQuery(ctx)
 
-	results, err := conn.execContext(ctx, query, args)
+	driverQuery := conn.driverQuery(ctx, query)
+	results, err := retryOnLockError(ctx, conn, query, func() (driver.Result, error) {
+		return conn.execContext(ctx, driverQuery, args)
+	})
 	if err != nil {
 		return results, conn.hooks.OnError(ctx, err, query, list...)
 	}
@@ -99,16 +194,22 @@ func (conn *Conn) QueryContext(ctx context.Context, query string, args []driver.
 		return nil, err
 	}
 
-	rows, err := conn.queryContext(ctx, query, args)
+	driverQuery := conn.driverQuery(ctx, query)
+	rows, err := retryOnLockError(ctx, conn, query, func() (driver.Rows, error) {
+		return conn.queryContext(ctx, driverQuery, args)
+	})
 	if err != nil {
-		return rows, conn.hooks.OnError(ctx, err, query, list...)
+		err = conn.hooks.OnError(ctx, err, query, list...)
+		cancelQuery(ctx)
+		return rows, err
 	}
 
 	if _, err := conn.hooks.After(ctx, query, list...); err != nil {
+		cancelQuery(ctx)
 		return rows, err
 	}
 
-	return rows, nil
+	return wrapRows(ctx, rows), nil
 }
 
 func (conn *Conn) queryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
@@ -129,16 +230,43 @@ func (conn *Conn) PrepareContext(ctx context.Context, query string) (driver.Stmt
 		err  error
 	)
 
+	start := time.Now()
 	if c, ok := conn.Conn.(driver.ConnPrepareContext); ok {
-		stmt, err = c.PrepareContext(ctx, query)
+		stmt, err = c.PrepareContext(ctx, conn.driverQuery(ctx, query))
 	} else {
 		err = fmt.Errorf("Conn does not implement driver.ConnPrepareContext, got %T", conn.Conn)
 	}
+	if !conn.disabled {
+		recordPrepare(ctx, query, time.Since(start), err)
+	}
 
 	if err != nil {
 		return nil, err
 	}
-	return &Stmt{stmt, conn.hooks, query}, nil
+	return &Stmt{stmt, conn.hooks, query, conn}, nil
+}
+
+// recordPrepare records the preparation of the statement as a "sql.prepare" event on the span in ctx,
+// and increases the sql_prepare_total metric, so that the statements prepared repeatedly could be found.
+func recordPrepare(ctx context.Context, query string, elapsed time.Duration, err error) {
+	if Disabled() {
+		return
+	}
+	op := sqlparser.Preview(query)
+	var table string
+	if isTableStmt(op) {
+		table, _, _, _ = SQLParser.parseTable(query)
+	}
+	goapmVecs().sqlPrepareCounter.WithLabelValues(table, sqlparser.StmtType(op)).Inc()
+
+	attrs := []attribute.KeyValue{
+		attribute.String("sql", truncate(query)),
+		attribute.Int64("sql.prepare.duration_ms", elapsed.Milliseconds()),
+	}
+	if err != nil {
+		attrs = append(attrs, attribute.String("error", err.Error()))
+	}
+	trace.SpanFromContext(ctx).AddEvent("sql.prepare", trace.WithAttributes(attrs...))
 }
 
 // Stmt is a wrapper around the driver.Stmt interface.
@@ -150,6 +278,7 @@ type Stmt struct {
 	driver.Stmt
 	hooks Hooks
 	query string
+	conn  *Conn
 }
 
 // ExecContext executes a query that doesn't return rows, such
@@ -166,8 +295,11 @@ func (s *Stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (drive
 	if ctx, err = s.hooks.Before(ctx, s.query, list...); err != nil {
 		return nil, err
 	}
+	defer cancelQuery(ctx)
 
-	results, err := s.execContext(ctx, args)
+	results, err := retryOnLockError(ctx, s.conn, s.query, func() (driver.Result, error) {
+		return s.execContext(ctx, args)
+	})
 	if err != nil {
 		return results, s.hooks.OnError(ctx, err, s.query, list...)
 	}
@@ -203,15 +335,20 @@ func (s *Stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driv
 		return nil, err
 	}
 
-	rows, err := s.queryContext(ctx, args)
+	rows, err := retryOnLockError(ctx, s.conn, s.query, func() (driver.Rows, error) {
+		return s.queryContext(ctx, args)
+	})
 	if err != nil {
-		return rows, s.hooks.OnError(ctx, err, s.query, list...)
+		err = s.hooks.OnError(ctx, err, s.query, list...)
+		cancelQuery(ctx)
+		return rows, err
 	}
 
 	if _, err := s.hooks.After(ctx, s.query, list...); err != nil {
+		cancelQuery(ctx)
 		return rows, err
 	}
-	return rows, nil
+	return wrapRows(ctx, rows), nil
 }
 
 func (s *Stmt) queryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
@@ -223,6 +360,87 @@ func (s *Stmt) queryContext(ctx context.Context, args []driver.NamedValue) (driv
 	}
 }
 
+// Rows is a wrapper around the driver.Rows interface.
+// It releases the query timeout context when the rows are closed,
+// since the rows are still read from the connection after the query returns.
+// It forwards the optional column type interfaces to the wrapped rows.
+type Rows struct {
+	driver.Rows
+	ctx context.Context
+}
+
+// wrapRows wraps the rows only if the ctx holds a query timeout context to be released.
+func wrapRows(ctx context.Context, rows driver.Rows) driver.Rows {
+	if ctx.Value(ctxQueryCancel) == nil {
+		return rows
+	}
+	return &Rows{Rows: rows, ctx: ctx}
+}
+
+// Close closes the rows and releases the query timeout context.
+func (r *Rows) Close() error {
+	defer cancelQuery(r.ctx)
+	return r.Rows.Close()
+}
+
+// HasNextResultSet is called at the end of the current result set and
+// reports whether there is another result set after the current one.
+func (r *Rows) HasNextResultSet() bool {
+	if rs, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return rs.HasNextResultSet()
+	}
+	return false
+}
+
+// NextResultSet advances the driver to the next result set even
+// if there are remaining rows in the current result set.
+func (r *Rows) NextResultSet() error {
+	if rs, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return rs.NextResultSet()
+	}
+	return io.EOF
+}
+
+// ColumnTypeScanType returns the value type that can be used to scan types into.
+func (r *Rows) ColumnTypeScanType(index int) reflect.Type {
+	if rs, ok := r.Rows.(driver.RowsColumnTypeScanType); ok {
+		return rs.ColumnTypeScanType(index)
+	}
+	return reflect.TypeOf(new(any)).Elem()
+}
+
+// ColumnTypeDatabaseTypeName returns the database system type name without the length.
+func (r *Rows) ColumnTypeDatabaseTypeName(index int) string {
+	if rs, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return rs.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+// ColumnTypeLength returns the length of the column type if the column is a variable length type.
+func (r *Rows) ColumnTypeLength(index int) (length int64, ok bool) {
+	if rs, ok := r.Rows.(driver.RowsColumnTypeLength); ok {
+		return rs.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+// ColumnTypeNullable reports whether the column may be null.
+func (r *Rows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	if rs, ok := r.Rows.(driver.RowsColumnTypeNullable); ok {
+		return rs.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+// ColumnTypePrecisionScale returns the precision and scale for decimal types.
+func (r *Rows) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	if rs, ok := r.Rows.(driver.RowsColumnTypePrecisionScale); ok {
+		return rs.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
+
 // DriverTx is a wrapper around the driver.Tx interface.
 // It should implement the following interfaces:
 // - driver.Tx
@@ -232,6 +450,7 @@ type DriverTx struct {
 	start           time.Time
 	ctx             context.Context
 	longTxThreshold time.Duration
+	conn            *Conn
 }
 
 // BeginTx starts and returns a new transaction.
@@ -252,7 +471,8 @@ func (conn *Conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx
 		return nil, err
 	}
 
-	return &DriverTx{tx, time.Now(), ctx, longTxThreshold}, nil
+	conn.inTx = true
+	return &DriverTx{tx, time.Now(), ctx, longTxThreshold, conn}, nil
 }
 
 func (conn *Conn) beginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
@@ -266,6 +486,7 @@ func (conn *Conn) beginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx
 
 func (dt *DriverTx) Commit() error {
 	err := dt.Tx.Commit()
+	dt.conn.inTx = false
 	elapsed := time.Since(dt.start)
 	if elapsed >= dt.longTxThreshold {
 		if span := trace.SpanFromContext(dt.ctx); span != nil {
@@ -280,6 +501,7 @@ func (dt *DriverTx) Commit() error {
 
 func (dt *DriverTx) Rollback() error {
 	err := dt.Tx.Rollback()
+	dt.conn.inTx = false
 	elapsed := time.Since(dt.start)
 	if elapsed >= dt.longTxThreshold {
 		if span := trace.SpanFromContext(dt.ctx); span != nil {
@@ -3,12 +3,22 @@ package apm
 import (
 	"context"
 	"database/sql"
-	"strings"
+	"database/sql/driver"
+	"errors"
 	"testing"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
+	"github.com/hedon954/goapm/internal"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	io_prometheus_client "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type User struct {
@@ -124,7 +134,10 @@ func Test_SQLDriverWrapper_INSERT(t *testing.T) {
 		uid := "u001"
 		_, err := db.ExecContext(ctx, "INSERT INTO `t_user` (`uid`, `name`, `age`, `gender`, `address`, `phone`, `email`, `salary`)"+
 			"VALUES (?, ?, ?, ?, ?, ?, ?, ?)", uid, "John", 18, "male", "Beijing", "1234567890", "john@example.com", 10000)
-		assert.True(t, strings.Contains(err.Error(), "Duplicate entry"))
+		var mysqlErr *mysql.MySQLError
+		if assert.True(t, errors.As(err, &mysqlErr)) {
+			assert.Equal(t, uint16(1062), mysqlErr.Number) // ER_DUP_ENTRY
+		}
 	})
 }
 
@@ -281,10 +294,37 @@ func Test_SQLDriverWrapper_Prepare(t *testing.T) {
 
 		// insert again, should duplicate
 		_, err = stmt.ExecContext(ctx, uid, "Alice", 18, "female", "Shanghai", "0987654321", "alice@example.com", 20000)
-		assert.True(t, strings.Contains(err.Error(), "Duplicate entry"))
+		var mysqlErr *mysql.MySQLError
+		if assert.True(t, errors.As(err, &mysqlErr)) {
+			assert.Equal(t, uint16(1062), mysqlErr.Number) // ER_DUP_ENTRY
+		}
 	})
 }
 
+func Test_SQLDriverWrapper_PrepareEvent(t *testing.T) {
+	exporter := setupTracingTest()
+	db, err := NewMySQL("test", "root:root@tcp(127.0.0.1:3306)/goapm")
+	assert.Nil(t, err)
+	defer db.Close()
+
+	query := "SELECT `name` FROM `t_user` WHERE `uid` = ?"
+	counter := goapmVecs().sqlPrepareCounter.WithLabelValues("t_user", "SELECT")
+	before := testutil.ToFloat64(counter)
+
+	ctx, span := StartNamedSpan(context.Background(), "prepare")
+	stmt, err := db.PrepareContext(ctx, query)
+	assert.Nil(t, err)
+	_ = stmt.Close()
+	span.End()
+	assert.Equal(t, before+1, testutil.ToFloat64(counter))
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Len(t, spans[0].Events, 1)
+	assert.Equal(t, "sql.prepare", spans[0].Events[0].Name)
+	assert.Contains(t, spans[0].Events[0].Attributes, attribute.String("sql", query))
+}
+
 func Test_SQLDriverWrapper_Transaction(t *testing.T) {
 	db, err := NewMySQL("test", "root:root@tcp(127.0.0.1:3306)/goapm")
 	assert.Nil(t, err)
@@ -350,3 +390,467 @@ func Test_SQLDriverWrapper_Transaction(t *testing.T) {
 		assert.Equal(t, "", result)
 	})
 }
+
+func Test_NewMySQL_WithPoolOptions(t *testing.T) {
+	db, err := NewMySQL("test", "root:root@tcp(127.0.0.1:3306)/goapm",
+		WithMaxOpenConns(10), WithMaxIdleConns(5), WithConnMaxLifetime(time.Minute))
+	assert.Nil(t, err)
+	defer db.Close()
+
+	assert.Equal(t, 10, db.Stats().MaxOpenConnections)
+}
+
+func Test_NewMySQL_OrphanQueries(t *testing.T) {
+	db, err := NewMySQL("test", "root:root@tcp(127.0.0.1:3306)/goapm")
+	assert.Nil(t, err)
+	defer db.Close()
+
+	t.Run("orphan query should start a root span", func(t *testing.T) {
+		exporter := setupTracingTest()
+		_, err = db.ExecContext(context.Background(), "SELECT 1")
+		assert.Nil(t, err)
+		spans := exporter.GetSpans()
+		assert.Equal(t, 1, len(spans))
+		assert.Equal(t, "sqltrace", spans[0].Name)
+		assert.False(t, spans[0].Parent.IsValid())
+	})
+
+	t.Run("query with a sampled out parent should not start a root span", func(t *testing.T) {
+		// a valid but not sampled remote parent, e.g. an unsampled upstream request
+		parent := trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: trace.TraceID{1},
+			SpanID:  trace.SpanID{1},
+			Remote:  true,
+		})
+		ctx := trace.ContextWithSpanContext(context.Background(), parent)
+
+		exporter := setupTracingTest()
+		_, err = db.ExecContext(ctx, "SELECT 1")
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(exporter.GetSpans()))
+	})
+}
+
+type tenantKey struct{}
+
+func Test_AddSQLSpanAttributer(t *testing.T) {
+	AddSQLSpanAttributer(func(ctx context.Context) []attribute.KeyValue {
+		if tenant, ok := ctx.Value(tenantKey{}).(string); ok {
+			return []attribute.KeyValue{attribute.String("tenant", tenant)}
+		}
+		return nil
+	})
+	t.Cleanup(func() { sqlSpanAttributers = nil })
+
+	db, err := NewMySQL("test", "root:root@tcp(127.0.0.1:3306)/goapm")
+	assert.Nil(t, err)
+	defer db.Close()
+
+	exporter := setupTracingTest()
+	ctx, span := StartNamedSpan(context.WithValue(context.Background(), tenantKey{}, "t1"), "tenant")
+	_, err = db.ExecContext(ctx, "SELECT 1")
+	assert.Nil(t, err)
+	span.End()
+
+	var found bool
+	for _, s := range exporter.GetSpans() {
+		if s.Name == "sqltrace" {
+			found = true
+			assert.Contains(t, s.Attributes, attribute.String("tenant", "t1"))
+		}
+	}
+	assert.True(t, found)
+}
+
+func Test_NewMySQL_WithQueryTimeout(t *testing.T) {
+	db, err := NewMySQL("test", "root:root@tcp(127.0.0.1:3306)/goapm", WithQueryTimeout(100*time.Millisecond))
+	assert.Nil(t, err)
+	defer db.Close()
+
+	t.Run("query within timeout should work and rows could be read", func(t *testing.T) {
+		rows, err := db.QueryContext(context.Background(), "SELECT `uid` FROM `t_user` LIMIT 2")
+		assert.Nil(t, err)
+		defer rows.Close()
+		count := 0
+		for rows.Next() {
+			count++
+		}
+		assert.Nil(t, rows.Err())
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("query exceeding timeout should be canceled", func(t *testing.T) {
+		exporter := setupTracingTest()
+		_, err := db.ExecContext(context.Background(), "SELECT SLEEP(1)")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		spans := exporter.GetSpans()
+		assert.Equal(t, 1, len(spans))
+		assert.Contains(t, spans[0].Attributes, attribute.Bool("query_timeout", true))
+	})
+}
+
+func Test_WithTransaction(t *testing.T) {
+	db, err := NewMySQL("test", "root:root@tcp(127.0.0.1:3306)/goapm")
+	assert.Nil(t, err)
+	defer db.Close()
+
+	insert := func(tx *sql.Tx, uid string) error {
+		_, err := tx.Exec("INSERT INTO `t_user` (`uid`, `name`, `age`, `gender`, `address`, `phone`, `email`, `salary`)"+
+			"VALUES (?, ?, ?, ?, ?, ?, ?, ?)", uid, "John", 18, "male", "Beijing", "1234567890", "john@example.com", 10000)
+		return err
+	}
+	exists := func(uid string) bool {
+		var result string
+		return db.QueryRow("SELECT `uid` FROM `t_user` WHERE `uid` = ?", uid).Scan(&result) == nil
+	}
+
+	t.Run("nil error should commit", func(t *testing.T) {
+		uid := uuid.NewString()
+		err := WithTransaction(context.Background(), db, func(tx *sql.Tx) error {
+			return insert(tx, uid)
+		})
+		assert.Nil(t, err)
+		assert.True(t, exists(uid))
+	})
+
+	t.Run("error should rollback", func(t *testing.T) {
+		uid := uuid.NewString()
+		expectedErr := errors.New("business error")
+		err := WithTransaction(context.Background(), db, func(tx *sql.Tx) error {
+			assert.Nil(t, insert(tx, uid))
+			return expectedErr
+		})
+		assert.ErrorIs(t, err, expectedErr)
+		assert.False(t, exists(uid))
+	})
+
+	t.Run("panic should rollback and re-panic", func(t *testing.T) {
+		uid := uuid.NewString()
+		assert.PanicsWithValue(t, "boom", func() {
+			_ = WithTransaction(context.Background(), db, func(tx *sql.Tx) error {
+				assert.Nil(t, insert(tx, uid))
+				panic("boom")
+			})
+		})
+		assert.False(t, exists(uid))
+	})
+}
+
+func Test_NewMySQL_WithExplainOnSlowQuery(t *testing.T) {
+	threshold := slowSqlThreshold
+	SetSlowSqlThreshold(0)
+	defer SetSlowSqlThreshold(threshold)
+
+	db, err := NewMySQL("test", "root:root@tcp(127.0.0.1:3306)/goapm", WithExplainOnSlowQuery(true))
+	assert.Nil(t, err)
+	explainer := db.Driver().(*Driver).explainer
+	assert.NotNil(t, explainer)
+
+	exporter := setupTracingTest()
+	ctx, span := StartNamedSpan(context.Background(), "explain")
+	var name string
+	query := "SELECT `name` FROM `t_user` WHERE `uid` = ?"
+	err = db.QueryRowContext(ctx, query, "u001").Scan(&name)
+	assert.Nil(t, err)
+	_, err = db.ExecContext(ctx, "INSERT INTO `t_not_exists` VALUES (1)")
+	assert.NotNil(t, err)
+	span.End()
+
+	// the query is explained in the background, in a child span of the span of the query
+	findSpans := func(name string) []sdktrace.ReadOnlySpan {
+		var res []sdktrace.ReadOnlySpan
+		for _, s := range exporter.GetSpans().Snapshots() {
+			if s.Name() == name {
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+	assert.Eventually(t, func() bool {
+		return len(findSpans("sqlexplain")) > 0
+	}, time.Second, 10*time.Millisecond)
+	explains := findSpans("sqlexplain")
+	assert.Len(t, explains, 1)
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, attr := range explains[0].Attributes() {
+		attrs[attr.Key] = attr.Value
+	}
+	assert.Equal(t, query, attrs["sql"].AsString())
+	// the plan or the error of EXPLAIN is set, the fake mysql server in the tests may fail to EXPLAIN
+	_, hasPlan := attrs["db.explain"]
+	_, hasErr := attrs["db.explain.error"]
+	assert.True(t, hasPlan || hasErr)
+	var parentSQL string
+	for _, s := range findSpans("sqltrace") {
+		if s.SpanContext().SpanID() == explains[0].Parent().SpanID() {
+			for _, attr := range s.Attributes() {
+				if attr.Key == "sql" {
+					parentSQL = attr.Value.AsString()
+				}
+			}
+		}
+	}
+	assert.Equal(t, query, parentSQL)
+
+	// the explainer is closed with the db
+	assert.Nil(t, db.Close())
+	assert.False(t, explainer.enqueue(context.Background(), query, nil))
+}
+
+func Test_sqlExplainer_enqueue(t *testing.T) {
+	explainer := newSQLExplainer("root:root@tcp(127.0.0.1:3306)/goapm", otel.Tracer(mysqlTracerName))
+	// the worker is not started, so that the queue is not consumed
+	explainer.started = true
+	for i := 0; i < explainQueueSize; i++ {
+		assert.True(t, explainer.enqueue(context.Background(), "SELECT 1", nil))
+	}
+	assert.False(t, explainer.enqueue(context.Background(), "SELECT 1", nil))
+	assert.Nil(t, explainer.close())
+	assert.False(t, explainer.enqueue(context.Background(), "SELECT 1", nil))
+}
+
+func Test_sqlExplainer_explainMultiStmt(t *testing.T) {
+	explainer := newSQLExplainer("root:root@tcp(127.0.0.1:3306)/goapm?multiStatements=true", otel.Tracer(mysqlTracerName))
+	defer explainer.close()
+
+	_, err := explainer.explain("UPDATE `t_user` SET `age` = 1; DELETE FROM `t_user`", nil)
+	assert.ErrorIs(t, err, errExplainMultiStmt)
+	// the statement is not sent to the db at all
+	assert.Nil(t, explainer.db)
+}
+
+func Test_recordMySQLError(t *testing.T) {
+	exporter := setupTracingTest()
+	counter := goapmVecs().mysqlDeadlockCounter.WithLabelValues("t_user")
+	before := testutil.ToFloat64(counter)
+
+	tests := []struct {
+		name string
+		err  error
+		key  attribute.Key
+	}{
+		{
+			"deadlock",
+			&mysql.MySQLError{Number: mysqlErrDeadlock, SQLState: [5]byte{'4', '0', '0', '0', '1'}, Message: "Deadlock found"},
+			"deadlock",
+		},
+		{"lock wait timeout", &mysql.MySQLError{Number: mysqlErrLockWaitTimeout, Message: "Lock wait timeout"}, "lock_wait_timeout"},
+		{"other mysql error", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}, ""},
+		{"not a mysql error", errors.New("some error"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter.Reset()
+			_, span := StartNamedSpan(context.Background(), tt.name)
+			recordMySQLError(span, "UPDATE `t_user` SET `age` = 1 WHERE `uid` = ?", tt.err)
+			span.End()
+
+			spans := exporter.GetSpans()
+			assert.Len(t, spans, 1)
+			var keys []attribute.Key
+			for _, attr := range spans[0].Attributes {
+				keys = append(keys, attr.Key)
+			}
+			var mysqlErr *mysql.MySQLError
+			if errors.As(tt.err, &mysqlErr) {
+				assert.Contains(t, spans[0].Attributes, attribute.Int("mysql.error_code", int(mysqlErr.Number)))
+			} else {
+				assert.NotContains(t, keys, attribute.Key("mysql.error_code"))
+			}
+			if tt.key == "" {
+				assert.NotContains(t, keys, attribute.Key("deadlock"))
+				assert.NotContains(t, keys, attribute.Key("lock_wait_timeout"))
+			} else {
+				assert.Contains(t, keys, tt.key)
+			}
+		})
+	}
+	assert.Equal(t, before+1, testutil.ToFloat64(counter))
+}
+
+func Test_retryOnLockError(t *testing.T) {
+	deadlock := &mysql.MySQLError{Number: mysqlErrDeadlock, Message: "Deadlock found"}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name        string
+		ctx         context.Context
+		maxAttempts int
+		errs        []error
+		wantCalls   int
+		wantErr     error
+	}{
+		{"succeed after retries", context.Background(), 3, []error{deadlock, deadlock, nil}, 3, nil},
+		{"give up after max attempts", context.Background(), 2, []error{deadlock, deadlock, nil}, 2, deadlock},
+		{"no retry by default", context.Background(), 0, []error{deadlock, nil}, 1, deadlock},
+		{"no retry for other errors", context.Background(), 3, []error{sql.ErrNoRows, nil}, 1, sql.ErrNoRows},
+		{"stop when context is done", canceled, 3, []error{deadlock, nil}, 1, deadlock},
+	}
+	// every call after the first one is a retry recorded as an event, and its deadlock is counted
+	counter := goapmVecs().mysqlDeadlockCounter.WithLabelValues("t_user")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter := setupTracingTest()
+			before := testutil.ToFloat64(counter)
+			ctx, span := StartNamedSpan(tt.ctx, tt.name)
+			calls := 0
+			conn := &Conn{retryAttempts: tt.maxAttempts}
+			res, err := retryOnLockError(ctx, conn, "UPDATE `t_user` SET `age` = 1", func() (int, error) {
+				calls++
+				return calls, tt.errs[calls-1]
+			})
+			span.End()
+
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, tt.wantCalls, calls)
+			assert.Equal(t, tt.wantCalls, res)
+			spans := exporter.GetSpans()
+			assert.Len(t, spans, 1)
+			retries := 0
+			for _, event := range spans[0].Events {
+				if event.Name == "sql.retry" {
+					retries++
+				}
+			}
+			assert.Equal(t, tt.wantCalls-1, retries)
+			assert.Equal(t, before+float64(retries), testutil.ToFloat64(counter))
+			if retries > 0 {
+				assert.Contains(t, spans[0].Attributes, attribute.Bool("deadlock", true))
+			}
+		})
+	}
+}
+
+func Test_Conn_maxAttempts(t *testing.T) {
+	conn := &Conn{retryAttempts: 3}
+	assert.Equal(t, 3, conn.maxAttempts())
+	conn.inTx = true
+	assert.Equal(t, 1, conn.maxAttempts())
+}
+
+func Test_NewMySQL_WithSQLRetryOnDeadlock(t *testing.T) {
+	db, err := NewMySQL("test", "root:root@tcp(127.0.0.1:3306)/goapm", WithSQLRetryOnDeadlock(3))
+	assert.Nil(t, err)
+	defer db.Close()
+
+	err = WithTransaction(context.Background(), db, func(tx *sql.Tx) error {
+		var name string
+		return tx.QueryRow("SELECT `name` FROM `t_user` WHERE `uid` = ?", "u001").Scan(&name)
+	})
+	assert.Nil(t, err)
+	var name string
+	err = db.QueryRow("SELECT `name` FROM `t_user` WHERE `uid` = ?", "u001").Scan(&name)
+	assert.Nil(t, err)
+	assert.Equal(t, "Alice", name)
+}
+
+func Test_commentSQL(t *testing.T) {
+	appName := internal.BuildInfo.AppName()
+	defer internal.BuildInfo.SetAppName(appName)
+	internal.BuildInfo.SetAppName("my app")
+
+	_ = setupTracingTest()
+	ctx, span := StartNamedSpan(context.Background(), "comment")
+	defer span.End()
+	sc := span.SpanContext()
+	traceparent := "00-" + sc.TraceID().String() + "-" + sc.SpanID().String() + "-01"
+
+	assert.Equal(t, "SELECT 1 /*application='my%20app',traceparent='"+traceparent+"'*/", commentSQL(ctx, "SELECT 1;"))
+	assert.Equal(t, "SELECT 1 /*application='my%20app'*/", commentSQL(context.Background(), "SELECT 1"))
+	assert.Equal(t, "SELECT /* hint */ 1", commentSQL(ctx, "SELECT /* hint */ 1"))
+	internal.BuildInfo.SetAppName("")
+	assert.Equal(t, "SELECT 1", commentSQL(context.Background(), "SELECT 1"))
+}
+
+func Test_NewMySQL_WithSQLCommenter(t *testing.T) {
+	db, err := NewMySQL("test", "root:root@tcp(127.0.0.1:3306)/goapm", WithSQLCommenter(true))
+	assert.Nil(t, err)
+	defer db.Close()
+
+	exporter := setupTracingTest()
+	ctx, span := StartNamedSpan(context.Background(), "commenter")
+	var name string
+	err = db.QueryRowContext(ctx, "SELECT `name` FROM `t_user` WHERE `uid` = 'u001'").Scan(&name)
+	assert.Nil(t, err)
+	assert.Equal(t, "Alice", name)
+	err = db.QueryRowContext(ctx, "SELECT `name` FROM `t_user` WHERE `uid` = ?", "u002").Scan(&name)
+	assert.Nil(t, err)
+	assert.Equal(t, "Bob", name)
+	span.End()
+
+	// the queries sent to the wrapped connection are commented, the spans keep the query without the comment
+	captured := &capturingConn{}
+	conn := &Conn{Conn: captured, hooks: wrap(&mysql.MySQLDriver{}, "test",
+		"root:root@tcp(127.0.0.1:3306)/goapm", newMySQLOptions()).(*Driver).hooks, sqlCommenter: true}
+	_, err = conn.ExecContext(ctx, "DELETE FROM `t_user` WHERE `uid` = 'u999'", nil)
+	assert.Nil(t, err)
+	assert.Contains(t, captured.query, "/*")
+	assert.Contains(t, captured.query, "traceparent='00-"+span.SpanContext().TraceID().String())
+	for _, s := range exporter.GetSpans() {
+		for _, attr := range s.Attributes {
+			if attr.Key == "sql" {
+				assert.NotContains(t, attr.Value.AsString(), "traceparent")
+			}
+		}
+	}
+}
+
+// capturingConn is a driver.Conn which records the last query executed.
+type capturingConn struct {
+	driver.Conn
+	query string
+}
+
+func (c *capturingConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	c.query = query
+	return driver.RowsAffected(0), nil
+}
+
+func Test_NewMySQL_QueryMetrics(t *testing.T) {
+	db, err := NewMySQL("test", "root:root@tcp(127.0.0.1:3306)/goapm")
+	assert.Nil(t, err)
+	defer db.Close()
+
+	querySamples := func() uint64 {
+		var m io_prometheus_client.Metric
+		assert.Nil(t, goapmVecs().mysqlQueryHistogram.WithLabelValues("t_user", "SELECT").(prometheus.Metric).Write(&m))
+		return m.GetHistogram().GetSampleCount()
+	}
+	slowCounter := goapmVecs().mysqlSlowQueryCounter.WithLabelValues("t_user", "SELECT")
+	beforeSamples, beforeSlow := querySamples(), testutil.ToFloat64(slowCounter)
+
+	var name string
+	query := "SELECT `name` FROM `t_user` WHERE `uid` = 'u001'"
+	assert.Nil(t, db.QueryRow(query).Scan(&name))
+	assert.Equal(t, beforeSamples+1, querySamples())
+	assert.Equal(t, beforeSlow, testutil.ToFloat64(slowCounter))
+
+	threshold := slowSqlThreshold
+	SetSlowSqlThreshold(0)
+	defer SetSlowSqlThreshold(threshold)
+	assert.Nil(t, db.QueryRow(query).Scan(&name))
+	assert.Equal(t, beforeSamples+2, querySamples())
+	assert.Equal(t, beforeSlow+1, testutil.ToFloat64(slowCounter))
+}
+
+func Test_NewMySQL_WithTableMetrics(t *testing.T) {
+	db, err := NewMySQL("test", "root:root@tcp(127.0.0.1:3306)/goapm", WithTableMetrics(false))
+	assert.Nil(t, err)
+	defer db.Close()
+
+	querySamples := func(table string) uint64 {
+		var m io_prometheus_client.Metric
+		assert.Nil(t, goapmVecs().mysqlQueryHistogram.WithLabelValues(table, "SELECT").(prometheus.Metric).Write(&m))
+		return m.GetHistogram().GetSampleCount()
+	}
+	libCounter := goapmVecs().libraryCounter.WithLabelValues(LibraryTypeMySQL, "SELECT", "t_user", "goapm.127.0.0.1:3306")
+	beforeLib, beforeTable, beforeEmpty := testutil.ToFloat64(libCounter), querySamples("t_user"), querySamples("")
+
+	var name string
+	assert.Nil(t, db.QueryRow("SELECT `name` FROM `t_user` WHERE `uid` = 'u001'").Scan(&name))
+	assert.Equal(t, beforeLib, testutil.ToFloat64(libCounter))
+	assert.Equal(t, beforeTable, querySamples("t_user"))
+	assert.Equal(t, beforeEmpty+1, querySamples(""))
+}
@@ -0,0 +1,164 @@
+package apm
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// explainTimeout is the max duration of the EXPLAIN of a slow query.
+	explainTimeout = time.Second
+	// explainQueueSize is the max number of the slow queries waiting to be explained,
+	// the slow queries are dropped if the queue is full.
+	explainQueueSize = 16
+)
+
+// errExplainMultiStmt is the error of explaining a query of multiple statements, which is skipped.
+var errExplainMultiStmt = errors.New("multiple statements are not explained")
+
+// explainJob is a slow query to be explained, ctx carries the span of the query.
+type explainJob struct {
+	ctx   context.Context
+	query string
+	args  []any
+}
+
+// sqlExplainer explains the slow queries in a background goroutine on a separate db with the raw mysql driver,
+// so that the callers of the slow queries are not blocked, and the EXPLAIN queries are neither traced nor explained again.
+type sqlExplainer struct {
+	connectURL string
+	tracer     trace.Tracer
+	jobs       chan explainJob
+
+	mu      sync.Mutex
+	started bool
+	closed  bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+
+	// db is only used by the worker goroutine
+	db *sql.DB
+}
+
+func newSQLExplainer(connectURL string, tracer trace.Tracer) *sqlExplainer {
+	return &sqlExplainer{
+		connectURL: connectURL,
+		tracer:     tracer,
+		jobs:       make(chan explainJob, explainQueueSize),
+		done:       make(chan struct{}),
+	}
+}
+
+// enqueue queues the query to be explained without blocking, and reports whether it is queued.
+// The worker goroutine is started by the first query.
+func (e *sqlExplainer) enqueue(ctx context.Context, query string, args []any) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return false
+	}
+	if !e.started {
+		e.started = true
+		e.wg.Add(1)
+		go e.run()
+	}
+	// the span context is all the job needs, the context of the query may be canceled when the query finishes
+	job := explainJob{
+		ctx:   trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx)),
+		query: query,
+		args:  args,
+	}
+	select {
+	case e.jobs <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops the worker goroutine and closes the db, the queued queries are not explained.
+func (e *sqlExplainer) close() error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	close(e.done)
+	e.mu.Unlock()
+
+	e.wg.Wait()
+	if e.db != nil {
+		return e.db.Close()
+	}
+	return nil
+}
+
+func (e *sqlExplainer) run() {
+	defer e.wg.Done()
+	for {
+		select {
+		case <-e.done:
+			return
+		case job := <-e.jobs:
+			e.record(job)
+		}
+	}
+}
+
+// record explains the query and records the plan in a "sqlexplain" span, a child of the span of the query.
+func (e *sqlExplainer) record(job explainJob) {
+	_, span := e.tracer.Start(job.ctx, "sqlexplain")
+	defer span.End()
+	span.SetAttributes(attribute.String("sql", truncate(job.query)))
+	if plan, err := e.explain(job.query, job.args); err != nil {
+		span.SetAttributes(attribute.String("db.explain.error", err.Error()))
+	} else {
+		span.SetAttributes(attribute.String("db.explain", truncate(plan)))
+	}
+}
+
+// explain returns the execution plan of the query in json, the rows of EXPLAIN are mapped by DBUtils.
+func (e *sqlExplainer) explain(query string, args []any) (string, error) {
+	// only the first statement would be explained, and the rest would be executed again
+	if isMultiStmt(query) {
+		return "", errExplainMultiStmt
+	}
+	if e.db == nil {
+		cfg, err := mysql.ParseDSN(e.connectURL)
+		if err != nil {
+			return "", redactDSNError(err, e.connectURL)
+		}
+		// the args are interpolated by the driver, so that EXPLAIN is not prepared on the server
+		cfg.InterpolateParams = true
+		// never run the statements following EXPLAIN, even if the query is not recognized as a multi statement one
+		cfg.MultiStatements = false
+		connector, err := mysql.NewConnector(cfg)
+		if err != nil {
+			return "", err
+		}
+		e.db = sql.OpenDB(connector)
+		// the queries are explained one by one, one connection is enough and bounds the load on the db
+		e.db.SetMaxOpenConns(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), explainTimeout)
+	defer cancel()
+	plan, err := DBUtils.WithStringValues().QueryMap(ctx, e.db, "EXPLAIN "+query, args...)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(plan)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
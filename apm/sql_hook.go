@@ -6,6 +6,7 @@ import (
 	"database/sql/driver"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
@@ -14,12 +15,14 @@ import (
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
+	gormlogger "gorm.io/gorm/logger"
 )
 
 type ctxKey string
 
 const (
-	ctxBeginTime ctxKey = "sqldb.begin"
+	ctxBeginTime   ctxKey = "sqldb.begin"
+	ctxQueryCancel ctxKey = "sqldb.cancel"
 
 	mysqlTracerName string = "goapm/mysql"
 )
@@ -39,33 +42,213 @@ func SetLongTxThreshold(d time.Duration) {
 	longTxThreshold = d
 }
 
+// SQLSpanAttributer returns the attributes to set on the sql span from the context of the query,
+// e.g. the tenant id stored in the context.
+type SQLSpanAttributer func(ctx context.Context) []attribute.KeyValue
+
+var (
+	sqlSpanAttributersMu sync.RWMutex
+	sqlSpanAttributers   []SQLSpanAttributer
+)
+
+// AddSQLSpanAttributer adds the attributer consulted for each query traced by the db created by NewMySQL and NewGorm,
+// the attributes returned are set on the sql span, so that they need not be passed to every query.
+func AddSQLSpanAttributer(fn SQLSpanAttributer) {
+	sqlSpanAttributersMu.Lock()
+	defer sqlSpanAttributersMu.Unlock()
+	sqlSpanAttributers = append(sqlSpanAttributers, fn)
+}
+
+// setSQLSpanAttributes sets the attributes of all the attributers on the span.
+func setSQLSpanAttributes(ctx context.Context, span trace.Span) {
+	sqlSpanAttributersMu.RLock()
+	defer sqlSpanAttributersMu.RUnlock()
+	for _, fn := range sqlSpanAttributers {
+		span.SetAttributes(fn(ctx)...)
+	}
+}
+
+// mysqlOptions is the options for the mysql db created by NewMySQL and NewGorm.
+type mysqlOptions struct {
+	// maxOpenConns is the maximum number of open connections, 0 means using the default value.
+	maxOpenConns int
+	// maxIdleConns is the maximum number of idle connections, only set when maxIdleConnsSet is true.
+	maxIdleConns    int
+	maxIdleConnsSet bool
+	// connMaxLifetime is the maximum amount of time a connection may be reused, 0 means using the default value.
+	connMaxLifetime time.Duration
+
+	// queryTimeout is the max duration of a query, 0 means no limit.
+	queryTimeout time.Duration
+	// disableTableMetrics skips parsing the table of the queries for the lib_handle_total metric.
+	disableTableMetrics bool
+	// explainOnSlowQuery sets the execution plan of the slow queries on the span.
+	explainOnSlowQuery bool
+	// retryAttempts is the max attempts of the queries failed by deadlocks, 0 or 1 means no retry.
+	retryAttempts int
+	// sqlCommenter appends the sqlcommenter comment to the queries sent to mysql.
+	sqlCommenter bool
+	// disabled turns off the tracing, metrics and logging of the db, the query timeout is kept.
+	disabled bool
+
+	// gormLogger is the logger for gorm, only used by NewGorm.
+	gormLogger gormlogger.Interface
+}
+
+// MySQLOption is the option for the mysql db created by NewMySQL and NewGorm.
+type MySQLOption func(o *mysqlOptions)
+
+// WithMySQLDisabled turns off the tracing, metrics and logging of the db like Disable, but only for this db.
+func WithMySQLDisabled(disabled bool) MySQLOption {
+	return func(o *mysqlOptions) {
+		o.disabled = disabled
+	}
+}
+
+// WithMaxOpenConns sets the maximum number of open connections to the database.
+// If it is not set, there is no limit on the number of open connections.
+func WithMaxOpenConns(n int) MySQLOption {
+	return func(o *mysqlOptions) {
+		o.maxOpenConns = n
+	}
+}
+
+// WithMaxIdleConns sets the maximum number of connections in the idle connection pool.
+// If n <= 0, no idle connections are retained.
+func WithMaxIdleConns(n int) MySQLOption {
+	return func(o *mysqlOptions) {
+		o.maxIdleConns = n
+		o.maxIdleConnsSet = true
+	}
+}
+
+// WithConnMaxLifetime sets the maximum amount of time a connection may be reused.
+// If it is not set, connections are not closed due to a connection's age.
+func WithConnMaxLifetime(d time.Duration) MySQLOption {
+	return func(o *mysqlOptions) {
+		o.connMaxLifetime = d
+	}
+}
+
+// WithQueryTimeout sets the max duration of a query, the query exceeding it would be canceled.
+// It only takes effect if the incoming context has no earlier deadline,
+// and the span of the canceled query would be tagged with query_timeout=true.
+func WithQueryTimeout(d time.Duration) MySQLOption {
+	return func(o *mysqlOptions) {
+		o.queryTimeout = d
+	}
+}
+
+// WithTableMetrics enables the lib_handle_total metric of the queries labeled by the table, default is true.
+// Parsing the table of the query is the most expensive part of the instrumentation,
+// it could be disabled for the write-heavy services which do not need the metric,
+// then mysql_query_duration_seconds and mysql_slow_query_total are labeled by an empty table.
+func WithTableMetrics(enable bool) MySQLOption {
+	return func(o *mysqlOptions) {
+		o.disableTableMetrics = !enable
+	}
+}
+
+// WithExplainOnSlowQuery runs EXPLAIN for the SELECT, UPDATE and DELETE queries slower than the slow sql threshold,
+// and records the execution plan in json as the "db.explain" attribute of a "sqlexplain" child span, default is false.
+// The queries are explained one by one in a background goroutine on a separate connection, so the callers are not blocked.
+// The slow queries are not explained but tagged with db.explain.dropped=true if too many are waiting,
+// and the goroutine and the connection are released when the db is closed.
+func WithExplainOnSlowQuery(enable bool) MySQLOption {
+	return func(o *mysqlOptions) {
+		o.explainOnSlowQuery = enable
+	}
+}
+
+// WithSQLRetryOnDeadlock retries the queries failed by a deadlock or lock wait timeout, with a small linear backoff,
+// until they succeed or maxAttempts attempts are made, each retry is recorded as a "sql.retry" event on the span.
+// The queries in a transaction begun by the db are never retried, since the transaction should be retried as a whole.
+func WithSQLRetryOnDeadlock(maxAttempts int) MySQLOption {
+	return func(o *mysqlOptions) {
+		o.retryAttempts = maxAttempts
+	}
+}
+
+// WithSQLCommenter appends a sqlcommenter comment, e.g. /*application='app',traceparent='00-...-...-01'*/,
+// to the queries sent to mysql, so that the slow query logs of mysql could be joined with the traces, default is false.
+// The traceparent is of the sql span, or of the span in the context for the prepared statements,
+// so a prepared statement reused by several requests carries the trace where it is prepared.
+// The traces, metrics and logs of goapm still use the query without the comment.
+func WithSQLCommenter(enable bool) MySQLOption {
+	return func(o *mysqlOptions) {
+		o.sqlCommenter = enable
+	}
+}
+
+// WithGormLogger sets the logger for gorm, it only takes effect on NewGorm.
+// If it is not set, the logger created by NewGormLogger would be used.
+func WithGormLogger(l gormlogger.Interface) MySQLOption {
+	return func(o *mysqlOptions) {
+		o.gormLogger = l
+	}
+}
+
+func newMySQLOptions(opts ...MySQLOption) *mysqlOptions {
+	o := &mysqlOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// configurePool applies the connection pool options to the db.
+func (o *mysqlOptions) configurePool(db *sql.DB) {
+	if o.maxOpenConns > 0 {
+		db.SetMaxOpenConns(o.maxOpenConns)
+	}
+	if o.maxIdleConnsSet {
+		db.SetMaxIdleConns(o.maxIdleConns)
+	}
+	if o.connMaxLifetime > 0 {
+		db.SetConnMaxLifetime(o.connMaxLifetime)
+	}
+}
+
 // NewMySQL returns a new MySQL driver with hooks.
-func NewMySQL(name, connectURL string) (*sql.DB, error) {
+func NewMySQL(name, connectURL string, opts ...MySQLOption) (*sql.DB, error) {
+	o := newMySQLOptions(opts...)
 	driverName := fmt.Sprintf("%s-%s", "mysql-wrapper", uuid.NewString())
-	sql.Register(driverName, wrap(&mysql.MySQLDriver{}, name, connectURL))
+	sql.Register(driverName, wrap(&mysql.MySQLDriver{}, name, connectURL, o))
 
 	db, err := sql.Open(driverName, connectURL)
 	if err != nil {
-		return nil, err
+		return nil, redactDSNError(err, connectURL)
 	}
+	o.configurePool(db)
 	err = db.Ping()
 	if err != nil {
-		return nil, err
+		return nil, redactDSNError(err, connectURL)
 	}
 
 	Logger.Info(context.TODO(), fmt.Sprintf("mysql sql.DB client[%s] connected", name), nil)
 	return db, nil
 }
 
-func wrap(d driver.Driver, name, connectURL string) driver.Driver {
+func wrap(d driver.Driver, name, connectURL string, o *mysqlOptions) driver.Driver {
 	tracer := otel.Tracer(mysqlTracerName)
 	dsn, err := mysql.ParseDSN(connectURL)
 	if err != nil {
-		panic("invalid mysql connect url: " + err.Error())
+		panic("invalid mysql connect url " + redactDSN(connectURL) + ": " + redactDSNError(err, connectURL).Error())
+	}
+	var explainer *sqlExplainer
+	if o.explainOnSlowQuery && !o.disabled {
+		explainer = newSQLExplainer(connectURL, tracer)
 	}
 	return &Driver{d, Hooks{
 		Before: func(ctx context.Context, query string, args ...any) (context.Context, error) {
-			// trace
+			// timeout
+			ctx = withQueryTimeout(ctx, o.queryTimeout)
+			if Disabled() || o.disabled {
+				return ctx, nil
+			}
+
+			// trace, the queries without a parent span, e.g. of the cron jobs and consumers, start a root span,
+			// while the ones with a sampled out parent follow its sampling decision
 			ctx = context.WithValue(ctx, ctxBeginTime, time.Now())
 			if ctx, span := tracer.Start(ctx, "sqltrace"); span != nil {
 				span.SetAttributes(
@@ -73,30 +256,50 @@ func wrap(d driver.Driver, name, connectURL string) driver.Driver {
 					attribute.String("sql", truncate(query)),
 					attribute.String("args", truncate(sliceToString(args))),
 				)
+				setSQLSpanAttributes(ctx, span)
 				return ctx, nil
 			}
 			return ctx, nil
 		},
 		After: func(ctx context.Context, query string, args ...any) (context.Context, error) {
-			// metric
-			table, op, multiTable, err := SQLParser.parseTable(query)
-			if !multiTable && err == nil {
-				libraryCounter.WithLabelValues(LibraryTypeMySQL, sqlparser.StmtType(op), table, dsn.DBName+"."+dsn.Addr).Inc()
+			if Disabled() || o.disabled {
+				return ctx, nil
 			}
 
-			// trace
+			// the statement type is all the audit log needs, and Preview is much cheaper than a full parse
+			op := sqlparser.Preview(query)
+
 			beginTime := time.Now()
 			if begin := ctx.Value(ctxBeginTime); begin != nil {
 				beginTime = begin.(time.Time)
 			}
+			elapsed := time.Since(beginTime)
+
+			// metric
+			var table string
+			if !o.disableTableMetrics && isTableStmt(op) {
+				parsed, _, multiTable, err := SQLParser.parseTable(query)
+				if !multiTable && err == nil {
+					table = parsed
+					goapmVecs().libraryCounter.WithLabelValues(LibraryTypeMySQL, sqlparser.StmtType(op), table, dsn.DBName+"."+dsn.Addr).Inc()
+				}
+			}
+			goapmVecs().mysqlQueryHistogram.WithLabelValues(table, sqlparser.StmtType(op)).Observe(elapsed.Seconds())
+			if elapsed > slowSqlThreshold {
+				goapmVecs().mysqlSlowQueryCounter.WithLabelValues(table, sqlparser.StmtType(op)).Inc()
+			}
+
+			// trace
 			span := trace.SpanFromContext(ctx)
 			defer span.End()
-			elapsed := time.Since(beginTime)
 			if elapsed > slowSqlThreshold {
 				span.SetAttributes(
 					attribute.Bool("slowsql", true),
 					attribute.Int64("sql_duration_ms", elapsed.Milliseconds()),
 				)
+				if explainer != nil && span.IsRecording() && isExplainableStmt(op) && !explainer.enqueue(ctx, query, args) {
+					span.SetAttributes(attribute.Bool("db.explain.dropped", true))
+				}
 			}
 
 			// log
@@ -111,24 +314,84 @@ func wrap(d driver.Driver, name, connectURL string) driver.Driver {
 			return ctx, nil
 		},
 		OnError: func(ctx context.Context, err error, query string, args ...any) error {
+			if Disabled() || o.disabled {
+				return err
+			}
+
 			// trace
 			span := trace.SpanFromContext(ctx)
 			defer span.End()
 			if !errors.Is(err, driver.ErrSkip) {
-				span.SetAttributes(attribute.Bool("error", true))
-				span.RecordError(err, trace.WithStackTrace(true), trace.WithTimestamp(time.Now()))
+				if ctx.Value(ctxQueryCancel) != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					span.SetAttributes(attribute.Bool("query_timeout", true))
+				}
+				setSpanError(span, err)
+				recordMySQLError(span, query, err)
 				return err
 			}
 			span.SetAttributes(attribute.Bool("drop", true))
 			return err
 		},
-	}}
+	}, o.retryAttempts, o.sqlCommenter, explainer, o.disabled}
+}
+
+const (
+	// mysqlErrLockWaitTimeout is the mysql error number of ER_LOCK_WAIT_TIMEOUT.
+	mysqlErrLockWaitTimeout = 1205
+	// mysqlErrDeadlock is the mysql error number of ER_LOCK_DEADLOCK.
+	mysqlErrDeadlock = 1213
+)
+
+// recordMySQLError sets the error code and sql state on the span if the err is a mysql error,
+// tags the deadlocks and lock wait timeouts, and counts the deadlocks by the table of the query.
+func recordMySQLError(span trace.Span, query string, err error) {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return
+	}
+	span.SetAttributes(attribute.Int("mysql.error_code", int(mysqlErr.Number)))
+	if mysqlErr.SQLState != [5]byte{} {
+		span.SetAttributes(attribute.String("mysql.sql_state", string(mysqlErr.SQLState[:])))
+	}
+	switch mysqlErr.Number {
+	case mysqlErrDeadlock:
+		span.SetAttributes(attribute.Bool("deadlock", true))
+		var table string
+		if isTableStmt(sqlparser.Preview(query)) {
+			table, _, _, _ = SQLParser.parseTable(query)
+		}
+		goapmVecs().mysqlDeadlockCounter.WithLabelValues(table).Inc()
+	case mysqlErrLockWaitTimeout:
+		span.SetAttributes(attribute.Bool("lock_wait_timeout", true))
+	}
 }
 
+// withQueryTimeout derives a context with the timeout if the ctx has no earlier deadline.
+// The cancel function is stored in the context and should be released by cancelQuery after the query finishes.
+func withQueryTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	if timeout <= 0 {
+		return ctx
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= timeout {
+		return ctx
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return context.WithValue(ctx, ctxQueryCancel, cancel)
+}
+
+// cancelQuery releases the context derived by withQueryTimeout, it does nothing if there is no such context.
+func cancelQuery(ctx context.Context) {
+	if cancel, ok := ctx.Value(ctxQueryCancel).(context.CancelFunc); ok {
+		cancel()
+	}
+}
+
+// maxAttributeLength is the max length of the long attributes, e.g. the sql and the redis commands.
+const maxAttributeLength = 1024
+
 func truncate(query string) string {
-	const maxLength = 1024
-	if len(query) > maxLength {
-		return query[:maxLength]
+	if len(query) > maxAttributeLength {
+		return query[:maxAttributeLength]
 	}
 	return query
 }
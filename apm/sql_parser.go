@@ -4,16 +4,77 @@ import (
 	"fmt"
 
 	"github.com/xwb1989/sqlparser"
+
+	"github.com/hedon954/goapm/internal"
 )
 
+const defaultSQLParseCacheSize = 1024
+
 // sqlParser is a parser for sql statements.
-type sqlParser struct{}
+type sqlParser struct {
+	// cache holds the parse results of the recent queries, so that the hot queries are not parsed repeatedly.
+	cache *internal.LRU[string, sqlParseResult]
+}
+
+// sqlParseResult is the result of parseTable.
+type sqlParseResult struct {
+	tableName  string
+	queryType  int
+	multiTable bool
+	err        error
+}
 
-var SQLParser = &sqlParser{}
+var SQLParser = &sqlParser{
+	cache: internal.NewLRU[string, sqlParseResult](defaultSQLParseCacheSize),
+}
+
+// SetSQLParseCacheSize sets the number of the queries whose parse results are cached, default is 1024.
+// The cache is disabled if size <= 0.
+func SetSQLParseCacheSize(size int) {
+	SQLParser.cache.Resize(size)
+}
 
-// parseTable parses the table name from the sql statement.
+// parseTable parses the table name from the sql statement, the result is cached by the sql.
 // If the sql statement is a multi-table statement, it returns true and we would ignore it in the following metrics.
 func (p *sqlParser) parseTable(sql string) (tableName string, queryType int, multiTable bool, err error) {
+	if r, ok := p.cache.Get(sql); ok {
+		return r.tableName, r.queryType, r.multiTable, r.err
+	}
+	tableName, queryType, multiTable, err = p.parse(sql)
+	p.cache.Add(sql, sqlParseResult{tableName: tableName, queryType: queryType, multiTable: multiTable, err: err})
+	return tableName, queryType, multiTable, err
+}
+
+// isTableStmt reports whether the table of the statement type could be parsed by parseTable.
+func isTableStmt(stmtType int) bool {
+	switch stmtType {
+	case sqlparser.StmtSelect, sqlparser.StmtInsert, sqlparser.StmtUpdate, sqlparser.StmtDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// isExplainableStmt reports whether the statement type is explained by WithExplainOnSlowQuery.
+func isExplainableStmt(stmtType int) bool {
+	switch stmtType {
+	case sqlparser.StmtSelect, sqlparser.StmtUpdate, sqlparser.StmtDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// isMultiStmt reports whether the sql contains more than one statement, the semicolons in the literals are ignored.
+// The sql which could not be split is treated as a multi statement one.
+func isMultiStmt(sql string) bool {
+	pieces, err := sqlparser.SplitStatementToPieces(sql)
+	return err != nil || len(pieces) > 1
+}
+
+// parse parses the table name from the sql statement without the cache.
+// The queryType is the statement type returned by sqlparser.Preview, e.g. sqlparser.StmtSelect.
+func (p *sqlParser) parse(sql string) (tableName string, queryType int, multiTable bool, err error) {
 	queryType = sqlparser.Preview(sql)
 	stmt, err := sqlparser.Parse(sql)
 	if err != nil {
@@ -23,28 +84,28 @@ func (p *sqlParser) parseTable(sql string) (tableName string, queryType int, mul
 	switch queryType {
 	case sqlparser.StmtInsert:
 		t := stmt.(*sqlparser.Insert).Table.Name
-		return t.CompliantName(), sqlparser.INSERT, false, nil
+		return t.CompliantName(), queryType, false, nil
 	case sqlparser.StmtDelete:
 		tExprs := stmt.(*sqlparser.Delete).TableExprs
 		if len(tExprs) > 1 {
 			return "", 0, true, nil
 		}
 		t := sqlparser.GetTableName(tExprs[0].(*sqlparser.AliasedTableExpr).Expr)
-		return t.CompliantName(), sqlparser.DELETE, false, nil
+		return t.CompliantName(), queryType, false, nil
 	case sqlparser.StmtUpdate:
 		tExprs := stmt.(*sqlparser.Update).TableExprs
 		if len(tExprs) > 1 {
 			return "", 0, true, nil
 		}
 		t := sqlparser.GetTableName(tExprs[0].(*sqlparser.AliasedTableExpr).Expr)
-		return t.CompliantName(), sqlparser.UPDATE, false, nil
+		return t.CompliantName(), queryType, false, nil
 	case sqlparser.StmtSelect:
 		tExprs := stmt.(*sqlparser.Select).From
 		if len(tExprs) > 1 {
 			return "", 0, true, nil
 		}
 		t := sqlparser.GetTableName(tExprs[0].(*sqlparser.AliasedTableExpr).Expr)
-		return t.CompliantName(), sqlparser.SELECT, false, nil
+		return t.CompliantName(), queryType, false, nil
 	}
 
 	return "", 0, false, fmt.Errorf("unsupported sql type: %d, sql: %s", queryType, sql)
@@ -0,0 +1,68 @@
+package apm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xwb1989/sqlparser"
+
+	"github.com/hedon954/goapm/internal"
+)
+
+func TestSQLParser_Cache(t *testing.T) {
+	p := &sqlParser{cache: internal.NewLRU[string, sqlParseResult](2)}
+
+	for range 2 {
+		table, op, multiTable, err := p.parseTable("SELECT * FROM users WHERE id = ?")
+		assert.Nil(t, err)
+		assert.Equal(t, "users", table)
+		assert.Equal(t, sqlparser.StmtSelect, op)
+		assert.False(t, multiTable)
+	}
+	assert.Equal(t, 1, p.cache.Len())
+
+	// the errors are cached too
+	_, _, _, err := p.parseTable("invalid sql")
+	assert.NotNil(t, err)
+	_, _, _, err = p.parseTable("invalid sql")
+	assert.NotNil(t, err)
+
+	// the cache is bounded
+	_, _, _, _ = p.parseTable("DELETE FROM users WHERE id = ?")
+	assert.Equal(t, 2, p.cache.Len())
+	_, ok := p.cache.Get("SELECT * FROM users WHERE id = ?")
+	assert.False(t, ok)
+}
+
+var benchmarkQueries = func() []string {
+	queries := make([]string, 8)
+	for i := range queries {
+		queries[i] = fmt.Sprintf("SELECT id, name, age FROM users_%d WHERE id = ? AND status IN (?, ?) ORDER BY id LIMIT 10", i)
+	}
+	return queries
+}()
+
+func BenchmarkSQLParser_ParseTable(b *testing.B) {
+	b.Run("no cache", func(b *testing.B) {
+		p := &sqlParser{cache: internal.NewLRU[string, sqlParseResult](0)}
+		b.ReportAllocs()
+		for i := range b.N {
+			_, _, _, _ = p.parseTable(benchmarkQueries[i%len(benchmarkQueries)])
+		}
+	})
+	b.Run("cache", func(b *testing.B) {
+		p := &sqlParser{cache: internal.NewLRU[string, sqlParseResult](defaultSQLParseCacheSize)}
+		b.ReportAllocs()
+		for i := range b.N {
+			_, _, _, _ = p.parseTable(benchmarkQueries[i%len(benchmarkQueries)])
+		}
+	})
+}
+
+func Test_isMultiStmt(t *testing.T) {
+	assert.False(t, isMultiStmt("SELECT * FROM `t_user`"))
+	assert.False(t, isMultiStmt("SELECT * FROM `t_user`;"))
+	assert.False(t, isMultiStmt("SELECT * FROM `t_user` WHERE `name` = 'a;b'"))
+	assert.True(t, isMultiStmt("UPDATE `t_user` SET `age` = 1; DELETE FROM `t_user`"))
+}
@@ -0,0 +1,51 @@
+package apm
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// sqlRetryBackoff is the backoff before the first retry, it grows linearly with the attempts.
+const sqlRetryBackoff = 10 * time.Millisecond
+
+// isRetryableMySQLError reports whether the err is a mysql deadlock or lock wait timeout,
+// the statement is rolled back by mysql in both cases, so it is safe to retry outside a transaction.
+func isRetryableMySQLError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return false
+	}
+	return mysqlErr.Number == mysqlErrDeadlock || mysqlErr.Number == mysqlErrLockWaitTimeout
+}
+
+// retryOnLockError calls fn of the query at most conn.maxAttempts() times until it does not fail with a retryable
+// mysql error, each retry is recorded as a "sql.retry" event on the span in ctx.
+// The retried errors never reach the OnError hook, so they are recorded here, e.g. counted as deadlocks.
+// It stops waiting for the next attempt and returns the last error once the ctx is done.
+func retryOnLockError[T any](ctx context.Context, conn *Conn, query string, fn func() (T, error)) (T, error) {
+	res, err := fn()
+	for attempt := 2; attempt <= conn.maxAttempts() && isRetryableMySQLError(err); attempt++ {
+		timer := time.NewTimer(time.Duration(attempt-1) * sqlRetryBackoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return res, err
+		case <-timer.C:
+		}
+		span := trace.SpanFromContext(ctx)
+		if !Disabled() && !conn.disabled {
+			recordMySQLError(span, query, err)
+		}
+		span.AddEvent("sql.retry", trace.WithAttributes(
+			attribute.Int("sql.retry.attempt", attempt),
+			attribute.String("error", err.Error()),
+		))
+		res, err = fn()
+	}
+	return res, err
+}
@@ -0,0 +1,55 @@
+package apm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithTransaction runs fn in a transaction and records it under a span named "sql.transaction".
+// The transaction would be committed if fn returns nil, otherwise it would be rolled back.
+// If fn panics, the transaction would be rolled back and then the panic would be re-thrown.
+func WithTransaction(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) (err error) {
+	ctx, span := otel.Tracer(mysqlTracerName).Start(ctx, "sql.transaction")
+	defer span.End()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		recordTxError(span, fmt.Errorf("begin transaction failed: %w", err))
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			span.SetAttributes(attribute.Bool("sql.rollback", true))
+			recordTxError(span, fmt.Errorf("panic in transaction: %v", p))
+			panic(p)
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		span.SetAttributes(attribute.Bool("sql.rollback", true))
+		if rbErr := tx.Rollback(); rbErr != nil {
+			err = errors.Join(err, fmt.Errorf("rollback transaction failed: %w", rbErr))
+		}
+		recordTxError(span, err)
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		recordTxError(span, fmt.Errorf("commit transaction failed: %w", err))
+		return err
+	}
+	return nil
+}
+
+func recordTxError(span trace.Span, err error) {
+	span.SetAttributes(attribute.Bool("error", true))
+	RecordError(span, err)
+}
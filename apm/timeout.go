@@ -0,0 +1,176 @@
+package apm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Timeout creates a Gin middleware which bounds the execution time of the rest handlers to d,
+// the request is responded with 503 once the deadline is exceeded, and the span is set with "timeout".
+// The rest handlers are canceled by the request context, and what they write after the deadline is discarded.
+// The 503 is complete on the deadline even if they ignore the context, but they still hold the connection
+// until they return, so they should return as soon as the context is done.
+// It should be used after GinOtel so that the span has been started.
+func Timeout(d time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+		defer cancel()
+
+		tw := &timeoutWriter{ResponseWriter: c.Writer, ctx: ctx, header: make(http.Header)}
+		// the handlers are run in the current goroutine, since gin.Context is not safe for concurrent use,
+		// and the 503 is written in the goroutine of the deadline, the writes are serialized by the timeoutWriter.
+		stop := context.AfterFunc(ctx, tw.timeout)
+		defer stop()
+
+		c.Writer = tw
+		c.Request = c.Request.WithContext(ctx)
+		defer func() {
+			c.Writer = tw.ResponseWriter
+			// discard the buffered response if the handlers panicked, so that the recovery could respond
+			p := recover()
+			tw.finish(p == nil)
+			if p != nil {
+				panic(p)
+			}
+		}()
+		c.Next()
+	}
+}
+
+// timeoutWriter buffers the response of the handlers, and writes it to the underlying writer when they finish,
+// unless the timeout response has been written.
+type timeoutWriter struct {
+	gin.ResponseWriter
+	ctx context.Context
+
+	mu       sync.Mutex
+	header   http.Header
+	body     bytes.Buffer
+	status   int
+	timedOut bool
+	done     bool
+}
+
+// timeout writes the 503 response if the handlers have not finished.
+func (w *timeoutWriter) timeout() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.done {
+		w.timeoutLocked()
+	}
+}
+
+func (w *timeoutWriter) timeoutLocked() {
+	if w.timedOut || !errors.Is(w.ctx.Err(), context.DeadlineExceeded) {
+		return
+	}
+	w.timedOut = true
+	trace.SpanFromContext(w.ctx).SetAttributes(attribute.Bool("timeout", true))
+
+	// the Content-Length makes the flushed response complete for the client,
+	// otherwise the chunked response would not be terminated until the handlers return
+	body := http.StatusText(http.StatusServiceUnavailable)
+	h := w.ResponseWriter.Header()
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.ResponseWriter.WriteHeader(http.StatusServiceUnavailable)
+	_, _ = w.ResponseWriter.WriteString(body)
+	w.ResponseWriter.Flush()
+}
+
+// finish writes the buffered response to the underlying writer if flush is true and the timeout response has not been written.
+func (w *timeoutWriter) finish(flush bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.done = true
+	if !flush {
+		return
+	}
+	// the handlers may return on the deadline before the timeout response is written
+	if w.timeoutLocked(); w.timedOut {
+		return
+	}
+	dst := w.ResponseWriter.Header()
+	for k, vs := range w.header {
+		dst[k] = vs
+	}
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if w.body.Len() > 0 {
+		_, _ = w.ResponseWriter.Write(w.body.Bytes())
+	}
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *timeoutWriter) Status() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *timeoutWriter) Size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == 0 {
+		return -1
+	}
+	return w.body.Len()
+}
+
+func (w *timeoutWriter) Written() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status != 0
+}
+
+// Flush is a no-op, the response is buffered until the handlers finish.
+func (w *timeoutWriter) Flush() {}
@@ -0,0 +1,92 @@
+package apm
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestTimeout(t *testing.T) {
+	exporter := setupTracingTest()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(GinOtel(), Timeout(50*time.Millisecond))
+	r.GET("/fast", func(c *gin.Context) {
+		c.Header("X-Fast", "1")
+		c.String(http.StatusCreated, "fast")
+	})
+	handled := make(chan struct{})
+	r.GET("/slow", func(c *gin.Context) {
+		defer close(handled)
+		<-c.Request.Context().Done()
+		c.String(http.StatusOK, "too late")
+	})
+	sleep := 500 * time.Millisecond
+	slept := make(chan struct{})
+	r.GET("/sleep", func(c *gin.Context) {
+		defer close(slept)
+		time.Sleep(sleep)
+		c.String(http.StatusOK, "too late")
+	})
+	r.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+
+	t.Run("fast handler", func(t *testing.T) {
+		exporter.Reset()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fast", nil))
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "fast", w.Body.String())
+		assert.Equal(t, "1", w.Header().Get("X-Fast"))
+		assert.NotContains(t, exporter.GetSpans()[0].Attributes, attribute.Bool("timeout", true))
+	})
+
+	t.Run("slow handler", func(t *testing.T) {
+		exporter.Reset()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		<-handled
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.NotContains(t, w.Body.String(), "too late")
+		spans := exporter.GetSpans()
+		assert.Len(t, spans, 1)
+		assert.Contains(t, spans[0].Attributes, attribute.Bool("timeout", true))
+		assert.Contains(t, spans[0].Attributes, attribute.Int("http.response.code", http.StatusServiceUnavailable))
+	})
+
+	t.Run("handler ignoring the context", func(t *testing.T) {
+		exporter.Reset()
+		server := httptest.NewServer(r)
+		defer server.Close()
+
+		start := time.Now()
+		resp, err := http.Get(server.URL + "/sleep")
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		assert.Nil(t, err)
+		assert.Less(t, time.Since(start), sleep)
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		assert.Equal(t, http.StatusText(http.StatusServiceUnavailable), string(body))
+		select {
+		case <-slept:
+			t.Fatal("the 503 should be received before the handler returns")
+		default:
+		}
+		<-slept
+	})
+
+	t.Run("panic handler", func(t *testing.T) {
+		exporter.Reset()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+}
@@ -0,0 +1,40 @@
+package apm
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SetTracestate adds or updates the vendor entry of the W3C tracestate of the current span,
+// the entry is inherited by the child spans and injected into the outbound grpc and http requests
+// by the otel propagator, e.g. to carry a sampling priority across the services.
+// The ctx is returned unchanged if there is no valid span in it, or the vendor or value is invalid.
+func SetTracestate(ctx context.Context, vendor, value string) context.Context {
+	span := trace.SpanFromContext(ctx)
+	sc := span.SpanContext()
+	if !sc.IsValid() {
+		return ctx
+	}
+	ts, err := sc.TraceState().Insert(vendor, value)
+	if err != nil {
+		return ctx
+	}
+	return trace.ContextWithSpan(ctx, &tracestateSpan{Span: span, sc: sc.WithTraceState(ts)})
+}
+
+// GetTracestate returns the vendor entry of the W3C tracestate of the current span.
+func GetTracestate(ctx context.Context, vendor string) string {
+	return trace.SpanContextFromContext(ctx).TraceState().Get(vendor)
+}
+
+// tracestateSpan overrides the span context of the span with the updated tracestate,
+// the rest methods are delegated to the span, so that it could still be recorded and ended by the ctx.
+type tracestateSpan struct {
+	trace.Span
+	sc trace.SpanContext
+}
+
+func (s *tracestateSpan) SpanContext() trace.SpanContext {
+	return s.sc
+}
@@ -0,0 +1,60 @@
+package apm
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestSetTracestate(t *testing.T) {
+	exporter := setupTracingTest()
+	propagator := otel.GetTextMapPropagator()
+	t.Cleanup(func() { otel.SetTextMapPropagator(propagator) })
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	t.Run("no span", func(t *testing.T) {
+		ctx := SetTracestate(context.Background(), "goapm", "p1")
+		assert.Equal(t, "", GetTracestate(ctx, "goapm"))
+	})
+
+	t.Run("invalid vendor", func(t *testing.T) {
+		ctx, span := otel.Tracer("test").Start(context.Background(), "test")
+		defer span.End()
+		assert.Equal(t, ctx, SetTracestate(ctx, "Invalid Vendor", "p1"))
+	})
+
+	ctx, span := otel.Tracer("test").Start(context.Background(), "test")
+	ctx = SetTracestate(ctx, "goapm", "p1")
+	ctx = SetTracestate(ctx, "other", "v")
+	assert.Equal(t, "p1", GetTracestate(ctx, "goapm"))
+
+	t.Run("inherited by the child spans", func(t *testing.T) {
+		childCtx, child := otel.Tracer("test").Start(ctx, "child")
+		defer child.End()
+		assert.Equal(t, "p1", GetTracestate(childCtx, "goapm"))
+	})
+
+	t.Run("round trip through grpc metadata", func(t *testing.T) {
+		md := metadata.MD{}
+		otel.GetTextMapPropagator().Inject(ctx, &metadataSupplier{metadata: &md})
+		extracted := otel.GetTextMapPropagator().Extract(context.Background(), &metadataSupplier{metadata: &md})
+		assert.Equal(t, "p1", GetTracestate(extracted, "goapm"))
+		assert.Equal(t, "v", GetTracestate(extracted, "other"))
+	})
+
+	t.Run("round trip through http headers", func(t *testing.T) {
+		h := http.Header{}
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(h))
+		extracted := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(h))
+		assert.Equal(t, "p1", GetTracestate(extracted, "goapm"))
+	})
+
+	// the span is still recorded by the ctx
+	span.End()
+	assert.Len(t, exporter.GetSpans(), 3)
+}
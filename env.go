@@ -0,0 +1,99 @@
+package goapm
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/hedon954/goapm/apm"
+)
+
+// The environment variables read by FromEnv.
+const (
+	// EnvOtelEndpoint is the otel collector endpoint, the apm is enabled only if it is set.
+	EnvOtelEndpoint = "GOAPM_OTEL_ENDPOINT"
+	// EnvOtelAuthToken is the grpc auth token for the otel collector.
+	EnvOtelAuthToken = "GOAPM_OTEL_AUTH_TOKEN"
+	// EnvFailOpen makes the apm not fail when the otel collector is unreachable, e.g. "true".
+	EnvFailOpen = "GOAPM_FAIL_OPEN"
+	// EnvSamplingRatio is the ratio of the root traces to be sampled, in [0, 1].
+	EnvSamplingRatio = "GOAPM_SAMPLING_RATIO"
+	// EnvLogLevel is the logrus level, e.g. "debug", "info", "warn".
+	EnvLogLevel = "GOAPM_LOG_LEVEL"
+	// EnvLogPath is the path of the rotate log.
+	EnvLogPath = "GOAPM_LOG_PATH"
+	// EnvSlowSQLMs is the threshold of the slow sql in milliseconds.
+	EnvSlowSQLMs = "GOAPM_SLOW_SQL_MS"
+	// EnvLongTxMs is the threshold of the long transaction in milliseconds.
+	EnvLongTxMs = "GOAPM_LONG_TX_MS"
+)
+
+// FromEnv returns the infra options configured by the GOAPM_* environment variables,
+// the unset variables are ignored, and it panics if any of the set ones is invalid.
+// The apm options are applied to the apm created by GOAPM_OTEL_ENDPOINT.
+func FromEnv(apmOpts ...apm.ApmOption) []InfraOption {
+	var opts []InfraOption
+
+	if level, ok := os.LookupEnv(EnvLogLevel); ok {
+		lvl, err := logrus.ParseLevel(level)
+		if err != nil {
+			panic(invalidEnvError(EnvLogLevel, err))
+		}
+		opts = append(opts, func(*Infra) { logrus.SetLevel(lvl) })
+	}
+	if path, ok := os.LookupEnv(EnvLogPath); ok {
+		opts = append(opts, WithRotateLog(path))
+	}
+	if d, ok := lookupEnvMs(EnvSlowSQLMs); ok {
+		opts = append(opts, func(*Infra) { apm.SetSlowSqlThreshold(d) })
+	}
+	if d, ok := lookupEnvMs(EnvLongTxMs); ok {
+		opts = append(opts, func(*Infra) { apm.SetLongTxThreshold(d) })
+	}
+
+	endpoint, ok := os.LookupEnv(EnvOtelEndpoint)
+	if !ok {
+		return opts
+	}
+	var envApmOpts []apm.ApmOption
+	if token, ok := os.LookupEnv(EnvOtelAuthToken); ok {
+		envApmOpts = append(envApmOpts, apm.WithGRPCAuthToken(token))
+	}
+	if v, ok := os.LookupEnv(EnvFailOpen); ok {
+		failOpen, err := strconv.ParseBool(v)
+		if err != nil {
+			panic(invalidEnvError(EnvFailOpen, err))
+		}
+		envApmOpts = append(envApmOpts, apm.WithFailOpen(failOpen))
+	}
+	if v, ok := os.LookupEnv(EnvSamplingRatio); ok {
+		ratio, err := strconv.ParseFloat(v, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			panic(invalidEnvError(EnvSamplingRatio, fmt.Errorf("%q is not in [0, 1]", v)))
+		}
+		envApmOpts = append(envApmOpts, apm.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))))
+	}
+	// the explicit options take precedence over the environment variables
+	return append(opts, WithAPM(endpoint, append(envApmOpts, apmOpts...)...))
+}
+
+// lookupEnvMs looks up the environment variable as a duration in milliseconds.
+func lookupEnvMs(key string) (time.Duration, bool) {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return 0, false
+	}
+	ms, err := strconv.Atoi(v)
+	if err != nil || ms < 0 {
+		panic(invalidEnvError(key, fmt.Errorf("%q is not a non-negative integer", v)))
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+func invalidEnvError(key string, err error) error {
+	return fmt.Errorf("invalid goapm environment variable %s: %w", key, err)
+}
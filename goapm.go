@@ -3,22 +3,32 @@ package goapm
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"os"
 	"os/signal"
+	"slices"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/cloudflare/tableflip"
 	"github.com/gin-gonic/gin"
 	redisv6 "github.com/go-redis/redis"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	io_prometheus_client "github.com/prometheus/client_model/go"
 	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"gorm.io/gorm"
 	"mosn.io/holmes"
 
@@ -32,27 +42,52 @@ import (
 
 // Infra is an infrastructure manager for goapm.
 // It is recommended to create a single instance of Infra and share it across the application.
-// TODO: add a print function to print the infra's components and closers.
+// The components could be registered and accessed concurrently.
 type Infra struct {
 	// Name is the business name of the infra.
 	Name string
-	// Tracer is the tracer for the infra,
+	// Tracer is the tracer for the infra, it is created by TracerProvider.
 	Tracer trace.Tracer
+	// TracerProvider is the tracer provider of the infra, it is the global one unless WithIsolation is applied.
+	TracerProvider trace.TracerProvider
+	// MetricsReg is the metrics registry of the infra, it is apm.MetricsReg unless WithIsolation is applied.
+	MetricsReg apm.MetricRegistry
+	// isolated reports whether WithIsolation is applied.
+	isolated bool
+	// disabled reports whether WithDisabled is applied.
+	disabled bool
 	// Upgrader is the tableflip for the infra,
 	upg *tableflip.Upgrader
+	// shutdownSignals are the signals triggering the graceful shutdown in Run, default is SIGINT and SIGTERM.
+	shutdownSignals []os.Signal
+
+	// mu guards the components and closers below,
+	// since they may be registered lazily while being ranged by other goroutines.
+	mu sync.RWMutex
 
 	// redisV6 holds the redis v6 clients created by WithRedisV6.
 	redisV6s map[string]*apm.RedisV6
 	// redisV9 holds the redis v9 clients created by WithRedisV9.
 	redisV9s map[string]*redis.Client
+	// redisClusterV9s holds the redis v9 cluster clients created by WithRedisClusterV9.
+	redisClusterV9s map[string]*redis.ClusterClient
 	// mysqls holds the mysql db clients created by WithMySQL.
 	mysqls map[string]*sql.DB
 	// gorms holds the gorm db clients created by WithGorm.
 	gorms map[string]*gorm.DB
+	// components holds the third-party resources registered by RegisterComponent.
+	components map[string]io.Closer
+	// grpcServers holds the grpc servers created by NewGRPCServer.
+	grpcServers []*apm.GrpcServer
 
 	// deferFuncs holds the functions to close the infra.
 	// It should be closed in the reverse order of the creation.
 	deferFuncs []func()
+	// serverStops holds the functions to stop the servers created by the infra,
+	// they are called first in Stop so that the servers' final spans could be captured.
+	serverStops []func()
+	// apmCloseFunc closes the apm created by WithAPM, it is called last in Stop to flush all the spans.
+	apmCloseFunc func()
 }
 
 // InfraOption is the option for Infra.
@@ -63,17 +98,22 @@ func NewInfra(name string, opts ...InfraOption) *Infra {
 	internal.BuildInfo.SetAppName(name)
 
 	infra := &Infra{
-		Name:       name,
-		Tracer:     otel.Tracer(fmt.Sprintf("goapm/service/%s", name)),
-		redisV6s:   make(map[string]*apm.RedisV6),
-		redisV9s:   make(map[string]*redis.Client),
-		mysqls:     make(map[string]*sql.DB),
-		gorms:      make(map[string]*gorm.DB),
-		deferFuncs: make([]func(), 0),
+		Name:            name,
+		TracerProvider:  otel.GetTracerProvider(),
+		MetricsReg:      apm.MetricsReg,
+		redisV6s:        make(map[string]*apm.RedisV6),
+		redisV9s:        make(map[string]*redis.Client),
+		redisClusterV9s: make(map[string]*redis.ClusterClient),
+		mysqls:          make(map[string]*sql.DB),
+		gorms:           make(map[string]*gorm.DB),
+		components:      make(map[string]io.Closer),
+		deferFuncs:      make([]func(), 0),
+		shutdownSignals: defaultShutdownSignals,
 	}
 	for _, opt := range opts {
 		opt(infra)
 	}
+	infra.Tracer = infra.TracerProvider.Tracer(fmt.Sprintf("goapm/service/%s", name))
 	return infra
 }
 
@@ -87,6 +127,30 @@ func (infra *Infra) FullName() string {
 	return fmt.Sprintf("[%s][%s]", infra.Name, internal.BuildInfo.Hostname())
 }
 
+// Version returns the version of the application injected by ldflags or read from the build info, it may be empty.
+func (infra *Infra) Version() string {
+	return internal.BuildInfo.Version()
+}
+
+// Commit returns the git commit of the application injected by ldflags or read from the build info, it may be empty.
+func (infra *Infra) Commit() string {
+	return internal.BuildInfo.Commit()
+}
+
+// defaultShutdownSignals are the signals triggering the graceful shutdown in Run by default.
+var defaultShutdownSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+
+// WithShutdownSignals sets the signals triggering the graceful shutdown in Run, default is SIGINT and SIGTERM.
+// The default is kept if no signal is given, since signal.Notify would relay all the signals then, e.g. SIGURG of the runtime.
+func WithShutdownSignals(sigs ...os.Signal) InfraOption {
+	return func(infra *Infra) {
+		if len(sigs) == 0 {
+			sigs = defaultShutdownSignals
+		}
+		infra.shutdownSignals = sigs
+	}
+}
+
 // WithTableflip creates a new tableflip and adds it to the infra.
 // The tableflip is used to support graceful restart.
 // If the tableflip is created, the infra will listen the ports with it for http and rpc servers.
@@ -116,6 +180,9 @@ func WithTableflip(opts tableflip.Options, sigs ...os.Signal) InfraOption {
 	}()
 
 	return func(infra *Infra) {
+		infra.mu.Lock()
+		defer infra.mu.Unlock()
+
 		infra.upg = upg
 		infra.deferFuncs = append([]func(){
 			func() {
@@ -128,102 +195,260 @@ func WithTableflip(opts tableflip.Options, sigs ...os.Signal) InfraOption {
 
 // WithMySQL creates a new mysql db and adds it to the infra.
 // name is the business name of the db, and addr is the address of the db.
-func WithMySQL(name, addr string) InfraOption {
+// opts can be used to tune the connection pool, e.g. apm.WithMaxOpenConns.
+func WithMySQL(name, addr string, opts ...apm.MySQLOption) InfraOption {
 	return func(infra *Infra) {
-		if infra.mysqls[name] != nil {
-			panic(fmt.Errorf("goapm mysql db already exists: %s", name))
-		}
-		db, err := apm.NewMySQL(name, addr)
+		// the db is created without the lock because it dials the server, which would block the readers of the infra
+		db, err := apm.NewMySQL(name, addr, append([]apm.MySQLOption{apm.WithMySQLDisabled(infra.disabled)}, opts...)...)
 		if err != nil {
 			panic(fmt.Errorf("failed to create goapm mysql db[%s]: %w", name, err))
 		}
+
+		infra.mu.Lock()
+		if infra.mysqls[name] != nil {
+			infra.mu.Unlock()
+			_ = db.Close()
+			panic(fmt.Errorf("goapm mysql db already exists: %s", name))
+		}
 		infra.mysqls[name] = db
+		infra.mu.Unlock()
+		infra.registerDBStatsCollector(name, db)
 	}
 }
 
 // WithGorm creates a new gorm db and adds it to the infra.
 // name is the business name of the db, and addr is the address of the db.
-func WithGorm(name, addr string) InfraOption {
+// opts can be used to tune the connection pool, e.g. apm.WithMaxOpenConns.
+func WithGorm(name, addr string, opts ...apm.MySQLOption) InfraOption {
 	return func(infra *Infra) {
-		if infra.gorms[name] != nil {
-			panic(fmt.Errorf("goapm gorm db already exists: %s", name))
-		}
-		db, err := apm.NewGorm(name, addr)
+		// the db is created without the lock like WithMySQL
+		db, err := apm.NewGorm(name, addr, append([]apm.MySQLOption{apm.WithMySQLDisabled(infra.disabled)}, opts...)...)
 		if err != nil {
 			panic(fmt.Errorf("failed to create goapm gorm db[%s]: %w", name, err))
 		}
+		sqlDB, sqlErr := db.DB()
+
+		infra.mu.Lock()
+		if infra.gorms[name] != nil {
+			infra.mu.Unlock()
+			if sqlErr == nil {
+				_ = sqlDB.Close()
+			}
+			panic(fmt.Errorf("goapm gorm db already exists: %s", name))
+		}
 		infra.gorms[name] = db
+		infra.mu.Unlock()
+		if sqlErr == nil {
+			infra.registerDBStatsCollector(name, sqlDB)
+		}
+	}
+}
+
+// registerDBStatsCollector registers the connection pool stats collector of the db to the metrics registry of the infra.
+// It only logs a warning if the registration fails, e.g. a mysql db and a gorm db share the same name.
+func (infra *Infra) registerDBStatsCollector(name string, db *sql.DB) {
+	if err := infra.MetricsReg.Register(apm.NewDBStatsCollector(name, db)); err != nil {
+		apm.Logger.Warn(context.TODO(), "failed to register goapm db stats collector", map[string]any{
+			"name": name,
+			"err":  err.Error(),
+		})
 	}
 }
 
 // WithRedisV6 creates a new redis v6 client and adds it to the infra.
 // name is the business name of the redis, and opts is the options of the redis.
+// redisOpts can be used to tune the tracing, e.g. apm.WithRedisKeyCapture.
 // nolint:dupl
-func WithRedisV6(name string, opts *redisv6.Options) InfraOption {
+func WithRedisV6(name string, opts *redisv6.Options, redisOpts ...apm.RedisOption) InfraOption {
 	return func(infra *Infra) {
-		if infra.redisV6s[name] != nil {
-			panic(fmt.Errorf("goapm redis v6 client already exists: %s", name))
-		}
-		client, err := apm.NewRedisV6(name, opts)
+		// the client is created without the lock because it pings the server, which would block the readers of the infra
+		client, err := apm.NewRedisV6(name, opts, append([]apm.RedisOption{apm.WithRedisDisabled(infra.disabled)}, redisOpts...)...)
 		if err != nil {
 			panic(fmt.Errorf("failed to create goapm redis v6 client[%s]: %w", name, err))
 		}
+
+		infra.mu.Lock()
+		defer infra.mu.Unlock()
+		if infra.redisV6s[name] != nil {
+			_ = client.Close()
+			panic(fmt.Errorf("goapm redis v6 client already exists: %s", name))
+		}
 		infra.redisV6s[name] = client
 	}
 }
 
 // WithRedisV9 creates a new redis v9 client and adds it to the infra.
 // name is the business name of the redis, and opts is the options of the redis.
+// redisOpts can be used to tune the tracing, e.g. apm.WithRedisKeyCapture.
 // nolint:dupl
-func WithRedisV9(name string, opts *redis.Options) InfraOption {
+func WithRedisV9(name string, opts *redis.Options, redisOpts ...apm.RedisOption) InfraOption {
 	return func(infra *Infra) {
+		// the client is created without the lock like WithRedisV6
+		client, err := apm.NewRedisV9(name, opts, append([]apm.RedisOption{apm.WithRedisDisabled(infra.disabled)}, redisOpts...)...)
+		if err != nil {
+			panic(fmt.Errorf("failed to create goapm redis v9 client[%s]: %w", name, err))
+		}
+
+		infra.mu.Lock()
+		defer infra.mu.Unlock()
 		if infra.redisV9s[name] != nil {
+			_ = client.Close()
 			panic(fmt.Errorf("goapm redis v9 client already exists: %s", name))
 		}
-		client, err := apm.NewRedisV9(name, opts)
+		infra.redisV9s[name] = client
+	}
+}
+
+// WithRedisClusterV9 creates a new redis v9 cluster client and adds it to the infra.
+// name is the business name of the redis cluster, and opts is the options of the redis cluster.
+// redisOpts can be used to tune the tracing, e.g. apm.WithRedisKeyCapture.
+// nolint:dupl
+func WithRedisClusterV9(name string, opts *redis.ClusterOptions, redisOpts ...apm.RedisOption) InfraOption {
+	return func(infra *Infra) {
+		// the client is created without the lock like WithRedisV6
+		client, err := apm.NewRedisClusterV9(name, opts, append([]apm.RedisOption{apm.WithRedisDisabled(infra.disabled)}, redisOpts...)...)
 		if err != nil {
-			panic(fmt.Errorf("failed to create goapm redis v9 client[%s]: %w", name, err))
+			panic(fmt.Errorf("failed to create goapm redis v9 cluster client[%s]: %w", name, err))
 		}
-		infra.redisV9s[name] = client
+
+		infra.mu.Lock()
+		defer infra.mu.Unlock()
+		if infra.redisClusterV9s[name] != nil {
+			_ = client.Close()
+			panic(fmt.Errorf("goapm redis v9 cluster client already exists: %s", name))
+		}
+		infra.redisClusterV9s[name] = client
 	}
 }
 
-// WithMetrics registers the given collectors to the goapm metrics registry.
+// WithMetrics registers the given collectors to the metrics registry of the infra.
 // It default provides some collectors defined in goapm/metric.go.
+// The collectors already registered, e.g. by another infra in the tests, are skipped with a warning,
+// see also apm.ResetMetricRegistry.
 func WithMetrics(collectors ...prometheus.Collector) InfraOption {
 	return func(infra *Infra) {
-		apm.MetricsReg.MustRegister(collectors...)
+		for _, c := range collectors {
+			err := infra.MetricsReg.Register(c)
+			if err == nil {
+				continue
+			}
+			var are prometheus.AlreadyRegisteredError
+			if !errors.As(err, &are) {
+				panic(fmt.Errorf("failed to register goapm metrics: %w", err))
+			}
+			apm.Logger.Warn(context.TODO(), "goapm metrics collector already registered", map[string]any{
+				"err": err.Error(),
+			})
+		}
+	}
+}
+
+// WithPushGateway pushes the goapm metrics to the prometheus pushgateway at url every interval,
+// and for the last time when the infra stops, so that the metrics of the short-lived jobs are not lost.
+// If interval <= 0, the metrics are only pushed when the infra stops.
+// It does nothing if goapm or the infra is disabled.
+func WithPushGateway(url, job string, interval time.Duration) InfraOption {
+	return func(infra *Infra) {
+		if infra.isDisabled() {
+			return
+		}
+		stop := apm.RegisterMetricPusher(interval, func(mfs []*io_prometheus_client.MetricFamily) {
+			if err := apm.PushMetricsToGateway(url, job, mfs); err != nil {
+				apm.Logger.Warn(context.TODO(), "failed to push goapm metrics to the pushgateway", map[string]any{
+					"url": url,
+					"job": job,
+					"err": err.Error(),
+				})
+			}
+		})
+		// prepended so that it runs after the other defer functions and pushes the final metrics
+		infra.PrependDefer(stop)
 	}
 }
 
 // WithAutoPProf starts a holmes dumper to automatically record the running state of the program.
+// It does nothing if goapm or the infra is disabled.
 func WithAutoPProf(autoPProfOpts *apm.AutoPProfOpt, opts ...holmes.Option) InfraOption {
 	return func(infra *Infra) {
+		if infra.isDisabled() {
+			return
+		}
 		h, err := apm.NewHomes(autoPProfOpts, opts...)
 		if err != nil {
 			panic(fmt.Errorf("failed to create goapm homes: %w", err))
 		}
 		h.Start()
 		apm.Logger.Info(context.TODO(), "auto pprof started", map[string]any{
-			"enable_cpu":       autoPProfOpts.EnableCPU,
-			"enable_mem":       autoPProfOpts.EnableMem,
-			"enable_goroutine": autoPProfOpts.EnableGoroutine,
+			"enable_cpu":            autoPProfOpts.EnableCPU,
+			"enable_mem":            autoPProfOpts.EnableMem,
+			"enable_goroutine":      autoPProfOpts.EnableGoroutine,
+			"cpu_trigger_percent":   autoPProfOpts.CPUTriggerPercent,
+			"mem_trigger_percent":   autoPProfOpts.MemTriggerPercent,
+			"goroutine_trigger_num": autoPProfOpts.GoroutineTriggerNum,
+			"cooldown_seconds":      autoPProfOpts.CooldownSeconds,
 		})
-		infra.deferFuncs = append(infra.deferFuncs, func() {
+		infra.Defer(func() {
 			h.Stop()
 			apm.Logger.Info(context.TODO(), "auto pprof stopped", nil)
 		})
 	}
 }
 
+// WithDisabled disables the goapm instrumentation of the infra like apm.Disable, but the other infras are not affected.
+// It should be the first option, so that WithAPM, WithAutoPProf and WithPushGateway become no-ops,
+// and the clients and servers created by the infra are not instrumented, e.g. by apm.WithMySQLDisabled.
+func WithDisabled() InfraOption {
+	return func(infra *Infra) {
+		infra.disabled = true
+		infra.TracerProvider = noop.NewTracerProvider()
+	}
+}
+
+// isDisabled reports whether the infra is disabled by WithDisabled or goapm is disabled by apm.Disable.
+func (infra *Infra) isDisabled() bool {
+	return infra.disabled || apm.Disabled()
+}
+
+// WithIsolation makes the tracer provider and the metrics registry of the infra independent of the global ones,
+// so that several infras could be used in the same process, e.g. the integration tests run in parallel.
+// It should be applied before WithAPM, which then creates the tracer provider of the infra only,
+// and the metrics registry is created by apm.NewMetricRegistry.
+// The spans and the http handle metrics of the http servers, gin engines and grpc-gateway muxes created by the infra
+// are isolated, and served on the /metrics of the infra along with the collectors registered by the infra,
+// while the other instrumentations, e.g. the grpc, sql and redis hooks and the gin load shedding, still use the global ones.
+// WithPushGateway and apm.GatherMetrics are not isolated either, they gather the global apm.MetricsReg only.
+func WithIsolation() InfraOption {
+	return func(infra *Infra) {
+		infra.isolated = true
+		infra.TracerProvider = noop.NewTracerProvider()
+		infra.MetricsReg = apm.NewMetricRegistry(nil)
+	}
+}
+
 // WithAPM creates a new apm and adds it to the infra.
+// If WithIsolation is applied, the tracer provider is only used by the infra instead of being set as the global one.
 func WithAPM(otelEndpoint string, opts ...apm.ApmOption) InfraOption {
 	return func(infra *Infra) {
-		closeFunc, err := apm.NewAPM(otelEndpoint, opts...)
+		if infra.disabled {
+			return
+		}
+		var closeFunc func()
+		var err error
+		if infra.isolated {
+			infra.TracerProvider, closeFunc, err = apm.NewTracerProvider(otelEndpoint, opts...)
+		} else {
+			closeFunc, err = apm.NewAPM(otelEndpoint, opts...)
+		}
 		if err != nil {
 			panic(fmt.Errorf("failed to create goapm apm: %w", err))
 		}
-		infra.deferFuncs = append(infra.deferFuncs, closeFunc)
+		if !infra.isolated {
+			// the field holds the global provider read by NewInfra, which is replaced by NewAPM
+			infra.TracerProvider = otel.GetTracerProvider()
+		}
+		infra.mu.Lock()
+		infra.apmCloseFunc = closeFunc
+		infra.mu.Unlock()
 	}
 }
 
@@ -247,98 +472,207 @@ func WithRotateLog(path string, opts ...rotatelogs.Option) InfraOption {
 // WithCloser adds a closer to the infra.
 func WithCloser(fn func()) InfraOption {
 	return func(infra *Infra) {
-		infra.deferFuncs = append(infra.deferFuncs, fn)
+		infra.Defer(fn)
+	}
+}
+
+// WithComponent registers a third-party resource to the infra, see RegisterComponent.
+func WithComponent(name string, closer io.Closer) InfraOption {
+	return func(infra *Infra) {
+		infra.RegisterComponent(name, closer)
 	}
 }
 
+// RegisterComponent registers a third-party resource which goapm does not support natively, e.g. a nats connection,
+// it could be got by Component and would be closed in the defer chain when the infra stops.
+func (infra *Infra) RegisterComponent(name string, closer io.Closer) {
+	infra.mu.Lock()
+	defer infra.mu.Unlock()
+
+	if infra.components[name] != nil {
+		panic(fmt.Errorf("goapm component already exists: %s", name))
+	}
+	infra.components[name] = closer
+	infra.deferFuncs = append(infra.deferFuncs, func() {
+		if err := closer.Close(); err != nil {
+			apm.Logger.Error(context.TODO(), fmt.Sprintf("failed to close goapm component[%s]", name), err, nil)
+			return
+		}
+		apm.Logger.Info(context.TODO(), fmt.Sprintf("goapm component[%s] closed", name), nil)
+	})
+}
+
+// Component returns the component registered by RegisterComponent with the given name.
+func (infra *Infra) Component(name string) io.Closer {
+	infra.mu.RLock()
+	defer infra.mu.RUnlock()
+	return infra.components[name]
+}
+
 // MySQL returns the mysql db client with the given name.
 func (infra *Infra) MySQL(name string) *sql.DB {
+	infra.mu.RLock()
+	defer infra.mu.RUnlock()
 	return infra.mysqls[name]
 }
 
 // Gorm returns the gorm db client with the given name.
 func (infra *Infra) Gorm(name string) *gorm.DB {
+	infra.mu.RLock()
+	defer infra.mu.RUnlock()
 	return infra.gorms[name]
 }
 
 // RedisV6 returns the redis v6 client with the given name.
 func (infra *Infra) RedisV6(name string) *apm.RedisV6 {
+	infra.mu.RLock()
+	defer infra.mu.RUnlock()
 	return infra.redisV6s[name]
 }
 
 // RedisV9 returns the redis v9 client with the given name.
 func (infra *Infra) RedisV9(name string) *redis.Client {
+	infra.mu.RLock()
+	defer infra.mu.RUnlock()
 	return infra.redisV9s[name]
 }
 
+// RedisClusterV9 returns the redis v9 cluster client with the given name.
+func (infra *Infra) RedisClusterV9(name string) *redis.ClusterClient {
+	infra.mu.RLock()
+	defer infra.mu.RUnlock()
+	return infra.redisClusterV9s[name]
+}
+
 // Defer appends a defer function to the infra.
 func (infra *Infra) Defer(fn func()) {
+	infra.mu.Lock()
+	defer infra.mu.Unlock()
 	infra.deferFuncs = append(infra.deferFuncs, fn)
 }
 
 // PrependDefer prepends a defer function to the infra.
 func (infra *Infra) PrependDefer(fn func()) {
+	infra.mu.Lock()
+	defer infra.mu.Unlock()
 	infra.deferFuncs = append([]func(){fn}, infra.deferFuncs...)
 }
 
 // RangeSqlDB ranges the sql.DB of the infra.
 func (infra *Infra) RangeSqlDB(fn func(name string, db *sql.DB)) {
-	for name, db := range infra.mysqls {
+	for name, db := range snapshot(&infra.mu, infra.mysqls) {
 		fn(name, db)
 	}
 }
 
 // RangeGormDB ranges the gorm.DB of the infra.
 func (infra *Infra) RangeGormDB(fn func(name string, db *gorm.DB)) {
-	for name, db := range infra.gorms {
+	for name, db := range snapshot(&infra.mu, infra.gorms) {
 		fn(name, db)
 	}
 }
 
 // RangeRedisV6 ranges the redis v6 client of the infra.
 func (infra *Infra) RangeRedisV6(fn func(name string, client *apm.RedisV6)) {
-	for name, client := range infra.redisV6s {
+	for name, client := range snapshot(&infra.mu, infra.redisV6s) {
 		fn(name, client)
 	}
 }
 
 // RangeRedisV9 ranges the redis v9 client of the infra.
 func (infra *Infra) RangeRedisV9(fn func(name string, client *redis.Client)) {
-	for name, client := range infra.redisV9s {
+	for name, client := range snapshot(&infra.mu, infra.redisV9s) {
 		fn(name, client)
 	}
 }
 
+// RangeRedisClusterV9 ranges the redis v9 cluster client of the infra.
+func (infra *Infra) RangeRedisClusterV9(fn func(name string, client *redis.ClusterClient)) {
+	for name, client := range snapshot(&infra.mu, infra.redisClusterV9s) {
+		fn(name, client)
+	}
+}
+
+// httpOptions returns the options of the http instrumentations created by the infra,
+// they use the tracer provider and the metrics registry of the infra, and are disabled with the infra.
+func (infra *Infra) httpOptions() []apm.HTTPOption {
+	return []apm.HTTPOption{
+		apm.WithTracerProvider(infra.TracerProvider),
+		apm.WithMetricRegistry(infra.MetricsReg),
+		apm.WithHTTPDisabled(infra.disabled),
+	}
+}
+
 // NewHTTPServer creates a new http server with the given address.
 // If the tableflip is created, the server will listen on the address with the tableflip.
 // Otherwise, it will listen on the address directly.
-func (infra *Infra) NewHTTPServer(addr string) *apm.HTTPServer {
+func (infra *Infra) NewHTTPServer(addr string, opts ...apm.HTTPOption) *apm.HTTPServer {
+	opts = append(infra.httpOptions(), opts...)
+	var server *apm.HTTPServer
 	if infra.upg == nil {
-		return apm.NewHTTPServer(addr)
-	}
-	listener, err := infra.upg.Listen("tcp", addr)
-	if err != nil {
-		panic(fmt.Errorf("failed to listen goapm http server with tableflip: %w", err))
+		server = apm.NewHTTPServer(addr, opts...)
+	} else {
+		listener, err := infra.upg.Listen("tcp", addr)
+		if err != nil {
+			panic(fmt.Errorf("failed to listen goapm http server with tableflip: %w", err))
+		}
+		server = apm.NewHTTPServer2(listener, opts...)
 	}
-	return apm.NewHTTPServer2(listener)
+	infra.mu.Lock()
+	infra.serverStops = append(infra.serverStops, server.Close)
+	infra.mu.Unlock()
+	return server
+}
+
+// EnablePprofEndpoints mounts the net/http/pprof handlers under /debug/pprof/ on the http server,
+// so that the live profiles could be pulled on demand over http.
+// If auth is not nil, the endpoints are protected by the basic auth.
+func (infra *Infra) EnablePprofEndpoints(server *apm.HTTPServer, auth *apm.BasicAuth) {
+	server.RegisterPprofHandlers(auth)
+}
+
+// GinOpts is the options for creating a gin engine by NewGinWithOptions.
+type GinOpts struct {
+	// DisableMetrics skips registering the /metrics route on the engine,
+	// e.g. when the metrics are served on a separate admin port.
+	DisableMetrics bool
+	// MetricsAuth is the auth middleware of the /metrics route, it is optional.
+	MetricsAuth gin.HandlerFunc
+	// GinOptions is the options for gin.New.
+	GinOptions []gin.OptionFunc
+	// OtelOptions is the options for the otel tracing and metrics middleware.
+	OtelOptions []apm.GinOtelOption
 }
 
 // NewGin creates a new gin engine with otel tracing and metrics.
 // It will automatically add the otel tracing and metrics middleware to the engine.
 // If metricsAuth is not nil, it will add a metrics handler with the given auth middleware.
 func (infra *Infra) NewGin(metricsAuth gin.HandlerFunc, opts ...gin.OptionFunc) *gin.Engine {
-	res := gin.New(opts...)
-	res.Use(apm.GinOtel())
+	return infra.NewGinWithOptions(GinOpts{
+		MetricsAuth: metricsAuth,
+		GinOptions:  opts,
+	})
+}
+
+// NewGinWithOptions creates a new gin engine with otel tracing and metrics.
+// The /metrics route is registered unless opts.DisableMetrics is true.
+func (infra *Infra) NewGinWithOptions(opts GinOpts) *gin.Engine {
+	res := gin.New(opts.GinOptions...)
+	res.Use(apm.GinOtel(append(infra.httpOptions(), opts.OtelOptions...)...))
+
+	if opts.DisableMetrics {
+		return res
+	}
 
 	metricsHandler := gin.WrapH(
 		promhttp.HandlerFor(
-			apm.MetricsReg,
-			promhttp.HandlerOpts{Registry: apm.MetricsReg},
+			infra.MetricsReg,
+			promhttp.HandlerOpts{Registry: infra.MetricsReg},
 		),
 	)
 
-	if metricsAuth != nil {
-		res.GET("/metrics", metricsAuth, metricsHandler)
+	if opts.MetricsAuth != nil {
+		res.GET("/metrics", opts.MetricsAuth, metricsHandler)
 	} else {
 		res.GET("/metrics", metricsHandler)
 	}
@@ -348,15 +682,42 @@ func (infra *Infra) NewGin(metricsAuth gin.HandlerFunc, opts ...gin.OptionFunc)
 
 // NewGRPCServer creates a new grpc server with the given address.
 // If the tableflip is created, the server will listen on the address with the tableflip.
-func (infra *Infra) NewGRPCServer(addr string) *apm.GrpcServer {
+// opts are passed to apm.NewGrpcServer, e.g. apm.WithoutGrpcHealth.
+func (infra *Infra) NewGRPCServer(addr string, opts ...grpc.ServerOption) *apm.GrpcServer {
+	opts = append([]grpc.ServerOption{apm.WithGrpcDisabled(infra.disabled)}, opts...)
+	var server *apm.GrpcServer
 	if infra.upg == nil {
-		return apm.NewGrpcServer(addr)
+		server = apm.NewGrpcServer(addr, opts...)
+	} else {
+		listener, err := infra.upg.Listen("tcp", addr)
+		if err != nil {
+			panic(fmt.Errorf("failed to listen goapm grpc server with tableflip: %w", err))
+		}
+		server = apm.NewGrpcServer2(listener, opts...)
 	}
-	listener, err := infra.upg.Listen("tcp", addr)
-	if err != nil {
-		panic(fmt.Errorf("failed to listen goapm grpc server with tableflip: %w", err))
+	infra.mu.Lock()
+	infra.serverStops = append(infra.serverStops, server.Stop)
+	infra.grpcServers = append(infra.grpcServers, server)
+	infra.mu.Unlock()
+	return server
+}
+
+// SetServingStatus sets the serving status of the service on the health services of all the grpc servers
+// created by NewGRPCServer, e.g. to flip the readiness, the empty service is the status of the whole server.
+func (infra *Infra) SetServingStatus(service string, status healthpb.HealthCheckResponse_ServingStatus) {
+	infra.mu.RLock()
+	defer infra.mu.RUnlock()
+	for _, server := range infra.grpcServers {
+		server.SetServingStatus(service, status)
 	}
-	return apm.NewGrpcServer2(listener)
+}
+
+// NewGRPCGatewayMux creates a grpc-gateway mux whose REST requests are traced by the tracer provider of the infra,
+// see apm.NewGRPCGatewayMux. Register the handlers with the connection of apm.NewGrpcClient to keep the traces connected.
+func (infra *Infra) NewGRPCGatewayMux(opts ...runtime.ServeMuxOption) *runtime.ServeMux {
+	middleware := apm.GRPCGatewayMiddleware(infra.httpOptions()...)
+	opts = append([]runtime.ServeMuxOption{runtime.WithMiddlewares(middleware)}, opts...)
+	return runtime.NewServeMux(opts...)
 }
 
 // Tableflip returns the tableflip of the infra.
@@ -364,30 +725,49 @@ func (infra *Infra) Tableflip() *tableflip.Upgrader {
 	return infra.upg
 }
 
-// Stop stops the infra.
+// Stop stops the infra, the servers created by the infra are stopped first,
+// then the components are closed, and the apm is closed last to flush all the spans.
 func (infra *Infra) Stop() {
+	// take a snapshot so that the closers could access the infra without deadlock
+	infra.mu.RLock()
+	serverStops := slices.Clone(infra.serverStops)
+	deferFuncs := slices.Clone(infra.deferFuncs)
+	redisV6s, redisV9s, redisClusterV9s := maps.Clone(infra.redisV6s), maps.Clone(infra.redisV9s), maps.Clone(infra.redisClusterV9s)
+	mysqls, gorms := maps.Clone(infra.mysqls), maps.Clone(infra.gorms)
+	apmCloseFunc := infra.apmCloseFunc
+	infra.mu.RUnlock()
+
+	// stop the servers first, so that their final spans could be captured
+	for i := len(serverStops) - 1; i >= 0; i-- {
+		serverStops[i]()
+	}
+
 	// close the components in the reverse order of the creation
-	for i := len(infra.deferFuncs) - 1; i >= 0; i-- {
-		infra.deferFuncs[i]()
+	for i := len(deferFuncs) - 1; i >= 0; i-- {
+		deferFuncs[i]()
 	}
 
 	// close redis
-	for name, client := range infra.redisV6s {
+	for name, client := range redisV6s {
 		_ = client.Close()
 		apm.Logger.Info(context.TODO(), fmt.Sprintf("goapm redis v6 client[%s] closed", name), nil)
 	}
-	for name, client := range infra.redisV9s {
+	for name, client := range redisV9s {
 		_ = client.Close()
 		apm.Logger.Info(context.TODO(), fmt.Sprintf("goapm redis v9 client[%s] closed", name), nil)
 	}
+	for name, client := range redisClusterV9s {
+		_ = client.Close()
+		apm.Logger.Info(context.TODO(), fmt.Sprintf("goapm redis v9 cluster client[%s] closed", name), nil)
+	}
 
 	// close sql.DB
-	for name, db := range infra.mysqls {
+	for name, db := range mysqls {
 		_ = db.Close()
 		apm.Logger.Info(context.TODO(), fmt.Sprintf("goapm mysql sql.DB[%s] closed", name), nil)
 	}
 	// close gorm
-	for name, db := range infra.gorms {
+	for name, db := range gorms {
 		d, _ := db.DB()
 		if d != nil {
 			_ = d.Close()
@@ -395,6 +775,11 @@ func (infra *Infra) Stop() {
 		}
 	}
 
+	// close the apm last, so that the spans of all the above would be flushed
+	if apmCloseFunc != nil {
+		apmCloseFunc()
+	}
+
 	apm.Logger.Info(context.TODO(), "goapm infra finished stopping", map[string]any{
 		"name": infra.Name,
 	})
@@ -415,3 +800,38 @@ func (infra *Infra) WaitToStop() {
 		<-upg.Exit()
 	}
 }
+
+// Run blocks until one of the shutdown signals is received, or the tableflip upgrade is completed,
+// then stops the infra gracefully, so that no signal handling is needed in main.
+func (infra *Infra) Run() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, infra.shutdownSignals...)
+	defer signal.Stop(sig)
+
+	var exit <-chan struct{}
+	if upg := infra.upg; upg != nil {
+		if err := upg.Ready(); err != nil {
+			apm.Logger.Error(context.TODO(), "goapm tableflip ready failed", err, map[string]any{"name": infra.Name})
+		} else {
+			apm.Logger.Info(context.TODO(), "goapm tableflip ready success", map[string]any{"name": infra.Name})
+		}
+		exit = upg.Exit()
+	}
+
+	select {
+	case s := <-sig:
+		apm.Logger.Info(context.TODO(), "goapm infra received shutdown signal", map[string]any{
+			"name":   infra.Name,
+			"signal": s.String(),
+		})
+	case <-exit:
+	}
+	infra.Stop()
+}
+
+// snapshot returns a copy of the map guarded by mu.
+func snapshot[V any](mu *sync.RWMutex, m map[string]V) map[string]V {
+	mu.RLock()
+	defer mu.RUnlock()
+	return maps.Clone(m)
+}
@@ -0,0 +1,162 @@
+package goapm
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hedon954/goapm/apm"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+)
+
+func TestInfra_WithIsolation_Metrics(t *testing.T) {
+	infra := NewInfra("isolated", WithIsolation())
+	engine := infra.NewGin(nil)
+	engine.GET("/hello", func(c *gin.Context) {
+		c.String(http.StatusOK, "hello")
+	})
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/hello")
+	assert.Nil(t, err)
+	assert.Nil(t, resp.Body.Close())
+
+	resp, err = http.Get(server.URL + "/metrics")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Contains(t, string(body), `server_handle_total{`)
+	assert.Contains(t, string(body), `method="GET./hello"`)
+	assert.Contains(t, string(body), "go_goroutines")
+}
+
+func TestInfra_NewGinWithOptions_Metrics(t *testing.T) {
+	infra := NewInfra("gin", WithIsolation())
+	metricsStatus := func(engine *gin.Engine) int {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, metricsStatus(infra.NewGinWithOptions(GinOpts{})))
+	assert.Equal(t, http.StatusNotFound, metricsStatus(infra.NewGinWithOptions(GinOpts{DisableMetrics: true})))
+}
+
+func TestInfra_WithMySQL_NotBlockReaders(t *testing.T) {
+	addr := newSilentServer(t)
+	assertNotBlockReaders(t, WithMySQL("stuck", "root:root@tcp("+addr+")/goapm?timeout=1s&readTimeout=1s"))
+}
+
+func TestInfra_WithRedisV9_NotBlockReaders(t *testing.T) {
+	addr := newSilentServer(t)
+	assertNotBlockReaders(t, WithRedisV9("stuck", &redis.Options{Addr: addr, ReadTimeout: time.Second, MaxRetries: -1}))
+}
+
+// newSilentServer starts a server which accepts the connections but never responds, and returns its address.
+func newSilentServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { _ = conn.Close() })
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// assertNotBlockReaders asserts that Info is not blocked while opt is creating a client, which fails finally.
+func assertNotBlockReaders(t *testing.T, opt InfraOption) {
+	infra := NewInfra("blocking")
+	created := make(chan any)
+	go func() {
+		defer func() { created <- recover() }()
+		opt(infra)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	read := make(chan struct{})
+	go func() {
+		_ = infra.Info()
+		close(read)
+	}()
+	select {
+	case <-read:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Info should not be blocked by creating the client")
+	}
+	assert.NotNil(t, <-created)
+}
+
+func TestInfra_WithMySQL_Duplicate(t *testing.T) {
+	const addr = "root:root@tcp(127.0.0.1:3306)/goapm"
+	infra := NewInfra("duplicate", WithMySQL("db", addr))
+	defer infra.Stop()
+	db := infra.MySQL("db")
+
+	assert.Panics(t, func() { WithMySQL("db", addr)(infra) })
+	assert.Same(t, db, infra.MySQL("db"))
+	assert.Nil(t, db.Ping())
+}
+
+func TestInfra_WithAPM_TracerProvider(t *testing.T) {
+	prev := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	first := NewInfra("first", WithAPM("127.0.0.1:4317"))
+	first.Stop()
+	second := NewInfra("second", WithAPM("127.0.0.1:4317"))
+	defer second.Stop()
+
+	assert.Same(t, otel.GetTracerProvider(), second.TracerProvider)
+	assert.NotSame(t, first.TracerProvider, second.TracerProvider)
+}
+
+func TestInfra_WithRedisV9_Duplicate(t *testing.T) {
+	opts := &redis.Options{Addr: "127.0.0.1:6379"}
+	infra := NewInfra("duplicate", WithRedisV9("redis", opts))
+	defer infra.Stop()
+	client := infra.RedisV9("redis")
+
+	assert.Panics(t, func() { WithRedisV9("redis", opts)(infra) })
+	assert.Same(t, client, infra.RedisV9("redis"))
+	assert.Nil(t, client.Ping(context.Background()).Err())
+}
+
+func TestInfra_WithDisabled(t *testing.T) {
+	scrape := func(engine *gin.Engine, path string) string {
+		engine.GET(path, func(c *gin.Context) {})
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return w.Body.String()
+	}
+
+	disabled := NewInfra("disabled", WithDisabled())
+	assert.False(t, apm.Disabled())
+	assert.NotContains(t, scrape(disabled.NewGin(nil), "/disabled"), `method="GET./disabled"`)
+
+	// the other infras are not affected
+	enabled := NewInfra("enabled")
+	assert.Contains(t, scrape(enabled.NewGin(nil), "/enabled"), `method="GET./enabled"`)
+}
+
+func TestWithShutdownSignals(t *testing.T) {
+	assert.Equal(t, []os.Signal{syscall.SIGHUP}, NewInfra("signals", WithShutdownSignals(syscall.SIGHUP)).shutdownSignals)
+	assert.Equal(t, defaultShutdownSignals, NewInfra("signals", WithShutdownSignals()).shutdownSignals)
+}
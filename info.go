@@ -0,0 +1,82 @@
+package goapm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/hedon954/goapm/apm"
+	"github.com/hedon954/goapm/internal"
+)
+
+// InfraInfo is the information of what the infra has wired up.
+type InfraInfo struct {
+	Name     string `json:"name"`
+	AppName  string `json:"app_name"`
+	Hostname string `json:"hostname"`
+	Version  string `json:"version"`
+	Commit   string `json:"commit"`
+	// Components holds the names of the registered components grouped by their kinds.
+	Components map[string][]string `json:"components"`
+	// DeferFuncs is the number of the functions to be called when the infra stops.
+	DeferFuncs int `json:"defer_funcs"`
+}
+
+// Info returns the information of the infra.
+func (infra *Infra) Info() InfraInfo {
+	infra.mu.RLock()
+	defer infra.mu.RUnlock()
+
+	return InfraInfo{
+		Name:     infra.Name,
+		AppName:  internal.BuildInfo.AppName(),
+		Hostname: internal.BuildInfo.Hostname(),
+		Version:  internal.BuildInfo.Version(),
+		Commit:   internal.BuildInfo.Commit(),
+		Components: map[string][]string{
+			"mysql":            sortedKeys(infra.mysqls),
+			"gorm":             sortedKeys(infra.gorms),
+			"redis_v6":         sortedKeys(infra.redisV6s),
+			"redis_v9":         sortedKeys(infra.redisV9s),
+			"redis_cluster_v9": sortedKeys(infra.redisClusterV9s),
+			"component":        sortedKeys(infra.components),
+		},
+		DeferFuncs: len(infra.deferFuncs),
+	}
+}
+
+// PrintComponents logs the registered components and the number of the defer functions of the infra.
+func (infra *Infra) PrintComponents() {
+	info := infra.Info()
+	apm.Logger.Info(context.TODO(), "goapm infra components", map[string]any{
+		"name":        info.Name,
+		"components":  info.Components,
+		"defer_funcs": info.DeferFuncs,
+	})
+}
+
+// InfoHandler returns a http handler responding the infra information in json,
+// it could be mounted on any router, e.g. gin.WrapH(infra.InfoHandler()).
+func (infra *Infra) InfoHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(infra.Info()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// EnableInfoEndpoint mounts the InfoHandler under /goapm/info on the http server.
+func (infra *Infra) EnableInfoEndpoint(server *apm.HTTPServer) {
+	server.Handle("/goapm/info", infra.InfoHandler())
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
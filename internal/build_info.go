@@ -3,11 +3,18 @@ package internal
 import (
 	"os"
 	"path/filepath"
+	"runtime/debug"
 )
 
 var (
 	hostname string
 	appName  string
+
+	// version and commit could be injected by ldflags, e.g.
+	// -ldflags "-X github.com/hedon954/goapm/internal.version=v1.0.0 -X github.com/hedon954/goapm/internal.commit=abc123"
+	// Otherwise, they are read from the build info embedded by the go toolchain.
+	version string
+	commit  string
 )
 
 func init() {
@@ -16,6 +23,17 @@ func init() {
 	if appName == "" {
 		appName = filepath.Base(os.Args[0])
 	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if version == "" && info.Main.Version != "(devel)" {
+			version = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			if commit == "" && setting.Key == "vcs.revision" {
+				commit = setting.Value
+			}
+		}
+	}
 }
 
 type buildInfo struct{}
@@ -36,3 +54,13 @@ func (b *buildInfo) AppName() string {
 func (b *buildInfo) SetAppName(name string) {
 	appName = name
 }
+
+// Version returns the version of the application, it may be empty.
+func (b *buildInfo) Version() string {
+	return version
+}
+
+// Commit returns the git commit of the application, it may be empty.
+func (b *buildInfo) Commit() string {
+	return commit
+}